@@ -14,6 +14,20 @@ import (
 
 var DB *sql.DB
 
+// Geo-query backends selectable with GEO_BACKEND
+const (
+	GeoBackendPostGIS  = "postgis"
+	GeoBackendPortable = "portable"
+)
+
+// GeoBackend returns the configured geo-query backend, defaulting to PostGIS
+func GeoBackend() string {
+	if os.Getenv("GEO_BACKEND") == GeoBackendPortable {
+		return GeoBackendPortable
+	}
+	return GeoBackendPostGIS
+}
+
 func InitDatabase() error {
 	dsn := os.Getenv("DATABASE_URL")
 	if dsn == "" {
@@ -71,8 +85,20 @@ func CloseDatabase() {
 }
 
 func runMigrations() error {
+	// onPostGIS keeps a PostGIS-only migration in its place in the list, or skips it when
+	// running with the portable geo backend
+	postGIS := GeoBackend() == GeoBackendPostGIS
+	onPostGIS := func(q string) string {
+		if !postGIS {
+			return ""
+		}
+		return q
+	}
+
 	migrations := []string{
 		`CREATE EXTENSION IF NOT EXISTS pgcrypto`,
+		// PostGIS powers every radius/venue query (ST_DWithin, ST_Contains, ...)
+		onPostGIS(`CREATE EXTENSION IF NOT EXISTS postgis`),
 
 		`CREATE TABLE IF NOT EXISTS users (
 			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
@@ -150,19 +176,225 @@ func runMigrations() error {
 			created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
 		)`,
 
+		// Geography points derived from lat/lon so radius queries can use a GiST index
+		onPostGIS(`ALTER TABLE scenes ADD COLUMN IF NOT EXISTS geog geography(Point, 4326)
+			GENERATED ALWAYS AS (ST_SetSRID(ST_MakePoint(longitude, latitude), 4326)::geography) STORED`),
+		onPostGIS(`ALTER TABLE user_locations ADD COLUMN IF NOT EXISTS geog geography(Point, 4326)
+			GENERATED ALWAYS AS (ST_SetSRID(ST_MakePoint(longitude, latitude), 4326)::geography) STORED`),
+
+		// Highest number of nearby scenes seen during the scene's lifetime (for the recap)
+		`ALTER TABLE scenes ADD COLUMN IF NOT EXISTS peak_nearby_count INTEGER DEFAULT 0`,
+		// Running recap counters; the chats and messages themselves are deleted as they end
+		`ALTER TABLE scenes ADD COLUMN IF NOT EXISTS requests_sent_count INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE scenes ADD COLUMN IF NOT EXISTS requests_received_count INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE scenes ADD COLUMN IF NOT EXISTS chats_accepted_count INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE scenes ADD COLUMN IF NOT EXISTS messages_count INTEGER NOT NULL DEFAULT 0`,
+
+		// Admin-managed geofenced venues (festivals, conferences, ...). The polygon is kept
+		// as GeoJSON for every backend, plus as a PostGIS geometry when that is in use.
+		`CREATE TABLE IF NOT EXISTS venues (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			name VARCHAR(100) NOT NULL,
+			boundary_geojson JSONB NOT NULL,
+			created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`ALTER TABLE scenes ADD COLUMN IF NOT EXISTS venue_id UUID REFERENCES venues(id) ON DELETE SET NULL`,
+		onPostGIS(`ALTER TABLE venues ADD COLUMN IF NOT EXISTS boundary geometry(Polygon, 4326)`),
+		// Venues created while running without PostGIS get their geometry once it is enabled
+		onPostGIS(`UPDATE venues SET boundary = ST_SetSRID(ST_GeomFromGeoJSON(boundary_geojson::text), 4326)
+			WHERE boundary IS NULL`),
+
+		// Per-user notification preferences (radius, muted event types, quiet hours)
+		`CREATE TABLE IF NOT EXISTS notification_preferences (
+			user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+			radius_meters INTEGER NOT NULL DEFAULT 5000,
+			muted_events JSONB NOT NULL DEFAULT '[]',
+			quiet_hours_start VARCHAR(5),
+			quiet_hours_end VARCHAR(5),
+			timezone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+			updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		// Mutual chat extensions
+		`ALTER TABLE chat_requests ADD COLUMN IF NOT EXISTS extension_count INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE chat_requests ADD COLUMN IF NOT EXISTS extension_proposed_by UUID`,
+		`ALTER TABLE chat_requests ADD COLUMN IF NOT EXISTS extension_proposed_at TIMESTAMPTZ`,
+
+		// Message edits (unsent messages are deleted outright)
+		`ALTER TABLE chat_messages ADD COLUMN IF NOT EXISTS edited BOOLEAN NOT NULL DEFAULT false`,
+		`ALTER TABLE chat_messages ADD COLUMN IF NOT EXISTS edited_at TIMESTAMPTZ`,
+
+		// Emoji reactions; one per scene per emoji, removed together with the message/chat
+		`CREATE TABLE IF NOT EXISTS chat_message_reactions (
+			message_id UUID REFERENCES chat_messages(id) ON DELETE CASCADE,
+			scene_id UUID REFERENCES scenes(id) ON DELETE CASCADE,
+			emoji VARCHAR(16) NOT NULL,
+			created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (message_id, scene_id, emoji)
+		)`,
+
+		// Image attachments; the bytes live in the blob store under blob_key
+		`CREATE TABLE IF NOT EXISTS chat_attachments (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			chat_request_id UUID REFERENCES chat_requests(id) ON DELETE CASCADE,
+			from_scene_id UUID REFERENCES scenes(id) ON DELETE CASCADE,
+			blob_key TEXT NOT NULL,
+			mime_type VARCHAR(64) NOT NULL,
+			size_bytes INT NOT NULL,
+			width INT NOT NULL,
+			height INT NOT NULL,
+			created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`ALTER TABLE chat_messages ADD COLUMN IF NOT EXISTS attachment_id UUID REFERENCES chat_attachments(id) ON DELETE SET NULL`,
+
+		// Group rooms: ephemeral multi-scene chats owned by the creator's scene
+		`CREATE TABLE IF NOT EXISTS group_rooms (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			creator_scene_id UUID REFERENCES scenes(id) ON DELETE CASCADE,
+			name VARCHAR(100) NOT NULL,
+			expires_at TIMESTAMPTZ NOT NULL,
+			created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS group_room_members (
+			room_id UUID REFERENCES group_rooms(id) ON DELETE CASCADE,
+			scene_id UUID REFERENCES scenes(id) ON DELETE CASCADE,
+			status VARCHAR(20) NOT NULL DEFAULT 'invited',
+			invited_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+			joined_at TIMESTAMPTZ,
+			PRIMARY KEY (room_id, scene_id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS group_messages (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			room_id UUID REFERENCES group_rooms(id) ON DELETE CASCADE,
+			from_scene_id UUID REFERENCES scenes(id) ON DELETE CASCADE,
+			content TEXT NOT NULL,
+			created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		// Blocks are by user, so a blocked persona cannot come back with a new scene
+		`CREATE TABLE IF NOT EXISTS user_blocks (
+			blocker_user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+			blocked_user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+			created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (blocker_user_id, blocked_user_id)
+		)`,
+
+		// Abuse reports keep their own copy of the evidence; chats are deleted when they end
+		`CREATE TABLE IF NOT EXISTS reports (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			reporter_user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+			reported_user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+			reason VARCHAR(30) NOT NULL,
+			details TEXT,
+			chat_request_id UUID,
+			message_ids JSONB DEFAULT '[]',
+			content_snapshot JSONB DEFAULT '{}',
+			status VARCHAR(20) DEFAULT 'open',
+			created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		// Opt-in end-to-end encryption: scenes publish an ephemeral public key, encrypted
+		// chats store only ciphertext
+		`ALTER TABLE scenes ADD COLUMN IF NOT EXISTS public_key TEXT`,
+		`ALTER TABLE chat_requests ADD COLUMN IF NOT EXISTS e2e BOOLEAN NOT NULL DEFAULT false`,
+		`ALTER TABLE chat_messages ADD COLUMN IF NOT EXISTS ciphertext TEXT`,
+		`ALTER TABLE chat_messages ADD COLUMN IF NOT EXISTS cipher_nonce TEXT`,
+
+		// Client nonces make chat message sends safe to retry
+		`ALTER TABLE chat_messages ADD COLUMN IF NOT EXISTS client_nonce TEXT`,
+
+		// Stored responses for requests sent with an Idempotency-Key header
+		`CREATE TABLE IF NOT EXISTS idempotency_keys (
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			scope VARCHAR(50) NOT NULL,
+			key VARCHAR(255) NOT NULL,
+			request_hash VARCHAR(64) NOT NULL,
+			status_code INTEGER,
+			response_body BYTEA,
+			created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (user_id, scope, key)
+		)`,
+
+		// Delivery and read receipts; a message without either is just 'sent'
+		`ALTER TABLE chat_messages ADD COLUMN IF NOT EXISTS delivered_at TIMESTAMPTZ`,
+		`ALTER TABLE chat_messages ADD COLUMN IF NOT EXISTS read_at TIMESTAMPTZ`,
+
+		// Both sides must consent before a chat transcript can be exported
+		`ALTER TABLE chat_requests ADD COLUMN IF NOT EXISTS export_consent_from BOOLEAN NOT NULL DEFAULT false`,
+		`ALTER TABLE chat_requests ADD COLUMN IF NOT EXISTS export_consent_to BOOLEAN NOT NULL DEFAULT false`,
+
+		// Anti-spam bookkeeping for chat requests; rows outlive the scenes involved
+		`CREATE TABLE IF NOT EXISTS chat_request_sends (
+			id BIGSERIAL PRIMARY KEY,
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS chat_request_cooldowns (
+			from_user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			to_user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			until TIMESTAMPTZ NOT NULL,
+			PRIMARY KEY (from_user_id, to_user_id)
+		)`,
+
+		// Chat request state machine: pending -> accepted | rejected | canceled | expired,
+		// accepted -> ended | expired (see handlers/chat_state.go)
+		// Applied once; altering the table on every boot would lock and rescan it each time
+		`DO $$ BEGIN
+			IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'chat_requests_status_check') THEN
+				UPDATE chat_requests SET status = 'pending' WHERE status IS NULL;
+				ALTER TABLE chat_requests ALTER COLUMN status SET NOT NULL;
+				ALTER TABLE chat_requests ADD CONSTRAINT chat_requests_status_check
+					CHECK (status IN ('pending', 'accepted', 'rejected', 'canceled', 'expired', 'ended'));
+			END IF;
+		END $$`,
+
+		// Mutual-match mode: silent likes between scenes, gone when either scene ends
+		`CREATE TABLE IF NOT EXISTS scene_likes (
+			from_scene_id UUID NOT NULL REFERENCES scenes(id) ON DELETE CASCADE,
+			to_scene_id UUID NOT NULL REFERENCES scenes(id) ON DELETE CASCADE,
+			created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (from_scene_id, to_scene_id)
+		)`,
+
+		// Uploaded persona avatars; the image variants live in the blob store
+		`CREATE TABLE IF NOT EXISTS persona_avatars (
+			id UUID PRIMARY KEY,
+			persona_id UUID NOT NULL REFERENCES personas(id) ON DELETE CASCADE,
+			mime_type VARCHAR(50) NOT NULL,
+			created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+		)`,
+
 		// ---- Indexes ----
 		`CREATE INDEX IF NOT EXISTS idx_scenes_location ON scenes(latitude, longitude)`,
+		onPostGIS(`CREATE INDEX IF NOT EXISTS idx_scenes_geog ON scenes USING GIST(geog)`),
+		onPostGIS(`CREATE INDEX IF NOT EXISTS idx_user_locations_geog ON user_locations USING GIST(geog)`),
 		`CREATE INDEX IF NOT EXISTS idx_scenes_active_expires ON scenes(is_active, expires_at) WHERE is_active = true`,
 		`CREATE INDEX IF NOT EXISTS idx_personas_user_active ON personas(user_id, is_active) WHERE is_active = true`,
 		`CREATE INDEX IF NOT EXISTS idx_chat_requests_status ON chat_requests(status)`,
 		`CREATE INDEX IF NOT EXISTS idx_chat_requests_expiration ON chat_requests(expires_at, status)`,
 		`CREATE INDEX IF NOT EXISTS idx_chat_messages_request ON chat_messages(chat_request_id, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_user_blocks_blocked ON user_blocks(blocked_user_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_reports_reported ON reports(reported_user_id, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_group_room_members_scene ON group_room_members(scene_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_group_messages_room ON group_messages(room_id, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_chat_attachments_request ON chat_attachments(chat_request_id)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_chat_messages_nonce ON chat_messages(chat_request_id, from_scene_id, client_nonce) WHERE client_nonce IS NOT NULL`,
+		`CREATE INDEX IF NOT EXISTS idx_idempotency_keys_created ON idempotency_keys(created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_chat_request_sends_user ON chat_request_sends(user_id, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_scene_likes_to ON scene_likes(to_scene_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_persona_avatars_persona ON persona_avatars(persona_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_chat_messages_cursor ON chat_messages(chat_request_id, created_at, id)`,
 		`CREATE INDEX IF NOT EXISTS idx_otp_email ON otp_codes(email, expires_at)`,
 		`CREATE INDEX IF NOT EXISTS idx_user_locations_user ON user_locations(user_id, created_at DESC)`,
+		onPostGIS(`CREATE INDEX IF NOT EXISTS idx_venues_boundary ON venues USING GIST(boundary)`),
+		`CREATE INDEX IF NOT EXISTS idx_scenes_venue ON scenes(venue_id) WHERE venue_id IS NOT NULL`,
 	}
 
 	log.Println("🔄 Checking/Applying internal schema migrations...")
 	for i, q := range migrations {
+		if q == "" {
+			continue
+		}
 		if _, err := DB.Exec(q); err != nil {
 			// Basic error handling - printing query might be verbose but useful here
 			return fmt.Errorf("migration %d failed: \nQuery: %s\nError: %w", i+1, q, err)
@@ -0,0 +1,37 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// GetDuration reads a Go duration (e.g. "90s", "10m") from the environment,
+// falling back to def when unset or invalid
+func GetDuration(key string, def time.Duration) time.Duration {
+	val := os.Getenv(key)
+	if val == "" {
+		return def
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil || d <= 0 {
+		log.Printf("⚠️  Invalid duration for %s=%q, using default %s", key, val, def)
+		return def
+	}
+	return d
+}
+
+// GetInt reads an integer from the environment, falling back to def when unset or invalid
+func GetInt(key string, def int) int {
+	val := os.Getenv(key)
+	if val == "" {
+		return def
+	}
+	n, err := strconv.Atoi(val)
+	if err != nil {
+		log.Printf("⚠️  Invalid integer for %s=%q, using default %d", key, val, def)
+		return def
+	}
+	return n
+}
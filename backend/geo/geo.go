@@ -0,0 +1,77 @@
+package geo
+
+import (
+	"log"
+	"math"
+	"scene-on/backend/config"
+
+	"github.com/google/uuid"
+)
+
+// MaxRecipientRadius is the largest notification radius a user may pick.
+// The portable backend uses it to size its prefilter box when recipient radii apply.
+const MaxRecipientRadius = 50000 // meters
+
+// NearbyQuery describes a radius search for active scenes
+type NearbyQuery struct {
+	Latitude       float64
+	Longitude      float64
+	RadiusMeters   float64
+	ExcludeUserID  uuid.UUID  // Skip scenes owned by this user (uuid.Nil = none)
+	ExcludeSceneID uuid.UUID  // Skip this scene (uuid.Nil = none)
+	VenueID        *uuid.UUID // Only scenes tagged with this venue
+	HideBlockedFor uuid.UUID  // Skip scenes of users blocked by or blocking this user (uuid.Nil = none)
+	// UseRecipientRadius matches each scene against its owner's notification radius
+	// (falling back to RadiusMeters) instead of RadiusMeters alone
+	UseRecipientRadius bool
+	Limit              int // 0 = no limit
+}
+
+// SceneHit is an active scene matched by a NearbyQuery
+type SceneHit struct {
+	SceneID        uuid.UUID
+	UserID         uuid.UUID
+	DistanceMeters float64
+}
+
+// GeoIndex answers radius queries over active scenes and venue lookups. Hits are
+// ordered by distance.
+type GeoIndex interface {
+	NearbyScenes(q NearbyQuery) ([]SceneHit, error)
+	// VenueAt returns the smallest venue containing a point, or nil
+	VenueAt(lat, lon float64) (*uuid.UUID, error)
+	// VenueScenes returns the active scenes located inside a venue
+	VenueScenes(venueID, excludeSceneID uuid.UUID) ([]uuid.UUID, error)
+}
+
+// New returns the GeoIndex for the configured backend
+func New(backend string) GeoIndex {
+	switch backend {
+	case config.GeoBackendPortable:
+		log.Println("🧭 Using portable geo backend (bounding box + haversine)")
+		return &PortableIndex{}
+	default:
+		log.Println("🧭 Using PostGIS geo backend")
+		return &PostGISIndex{}
+	}
+}
+
+// Distance calculates the distance in meters between two coordinates (Haversine formula)
+func Distance(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadius = 6371000 // meters
+
+	rad := func(deg float64) float64 {
+		return deg * (math.Pi / 180)
+	}
+
+	dLat := rad(lat2 - lat1)
+	dLon := rad(lon2 - lon1)
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(rad(lat1))*math.Cos(rad(lat2))*
+			math.Sin(dLon/2)*math.Sin(dLon/2)
+
+	c := 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+
+	return earthRadius * c
+}
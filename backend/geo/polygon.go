@@ -0,0 +1,96 @@
+package geo
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+)
+
+// Polygon is a GeoJSON polygon: an outer ring followed by any holes, each ring a closed
+// list of [longitude, latitude] positions
+type Polygon [][][2]float64
+
+var errInvalidPolygon = errors.New("expected a GeoJSON Polygon")
+
+// ParsePolygon parses and validates a GeoJSON Polygon geometry
+func ParsePolygon(raw []byte) (Polygon, error) {
+	var geometry struct {
+		Type        string        `json:"type"`
+		Coordinates [][][]float64 `json:"coordinates"`
+	}
+	if err := json.Unmarshal(raw, &geometry); err != nil || geometry.Type != "Polygon" || len(geometry.Coordinates) == 0 {
+		return nil, errInvalidPolygon
+	}
+
+	polygon := make(Polygon, len(geometry.Coordinates))
+	for i, ring := range geometry.Coordinates {
+		if len(ring) < 4 {
+			return nil, errInvalidPolygon
+		}
+		polygon[i] = make([][2]float64, len(ring))
+		for j, pos := range ring {
+			if len(pos) < 2 || math.Abs(pos[0]) > 180 || math.Abs(pos[1]) > 90 {
+				return nil, errInvalidPolygon
+			}
+			polygon[i][j] = [2]float64{pos[0], pos[1]}
+		}
+		if polygon[i][0] != polygon[i][len(ring)-1] {
+			return nil, errInvalidPolygon
+		}
+	}
+	return polygon, nil
+}
+
+// GeoJSON returns the polygon as a GeoJSON geometry
+func (p Polygon) GeoJSON() []byte {
+	out, _ := json.Marshal(map[string]interface{}{"type": "Polygon", "coordinates": p})
+	return out
+}
+
+// Contains reports whether a point lies inside the outer ring and outside every hole
+func (p Polygon) Contains(lat, lon float64) bool {
+	if len(p) == 0 || !ringContains(p[0], lat, lon) {
+		return false
+	}
+	for _, hole := range p[1:] {
+		if ringContains(hole, lat, lon) {
+			return false
+		}
+	}
+	return true
+}
+
+// ringContains is the even-odd rule: a ray cast from the point crosses the ring an odd
+// number of times when the point is inside
+func ringContains(ring [][2]float64, lat, lon float64) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		xi, yi := ring[i][0], ring[i][1]
+		xj, yj := ring[j][0], ring[j][1]
+		if (yi > lat) != (yj > lat) && lon < (xj-xi)*(lat-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// Bounds returns the latitude/longitude box around the outer ring
+func (p Polygon) Bounds() (minLat, maxLat, minLon, maxLon float64) {
+	minLat, maxLat, minLon, maxLon = 90, -90, 180, -180
+	for _, pos := range p[0] {
+		minLon, maxLon = math.Min(minLon, pos[0]), math.Max(maxLon, pos[0])
+		minLat, maxLat = math.Min(minLat, pos[1]), math.Max(maxLat, pos[1])
+	}
+	return minLat, maxLat, minLon, maxLon
+}
+
+// Area returns the planar area of the outer ring in square degrees. It is only meant
+// for picking the smallest of several overlapping venues.
+func (p Polygon) Area() float64 {
+	ring := p[0]
+	var sum float64
+	for i := 0; i < len(ring)-1; i++ {
+		sum += ring[i][0]*ring[i+1][1] - ring[i+1][0]*ring[i][1]
+	}
+	return math.Abs(sum) / 2
+}
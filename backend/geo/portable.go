@@ -0,0 +1,181 @@
+package geo
+
+import (
+	"database/sql"
+	"math"
+	"scene-on/backend/config"
+	"sort"
+
+	"github.com/google/uuid"
+)
+
+// metersPerDegree is the approximate length of one degree of latitude
+const metersPerDegree = 111320.0
+
+// PortableIndex answers radius queries without PostGIS: a bounding-box prefilter on the
+// plain latitude/longitude columns, then an exact haversine check in Go.
+type PortableIndex struct{}
+
+func (p *PortableIndex) NearbyScenes(q NearbyQuery) ([]SceneHit, error) {
+	boxRadius := q.RadiusMeters
+	if q.UseRecipientRadius && boxRadius < MaxRecipientRadius {
+		boxRadius = MaxRecipientRadius
+	}
+
+	minLat, maxLat, minLon, maxLon, wrapsLon := boundingBox(q.Latitude, q.Longitude, boxRadius)
+
+	rows, err := config.DB.Query(
+		`SELECT s.id, p.user_id, s.latitude, s.longitude, np.radius_meters
+		 FROM scenes s
+		 JOIN personas p ON s.persona_id = p.id
+		 LEFT JOIN notification_preferences np ON np.user_id = p.user_id
+		 WHERE s.is_active = true
+		   AND s.expires_at > NOW()
+		   AND p.user_id != $1
+		   AND s.id != $2
+		   AND ($3::uuid IS NULL OR s.venue_id = $3)
+		   AND s.latitude BETWEEN $4 AND $5
+		   AND ($6::boolean OR s.longitude BETWEEN $7 AND $8)
+		   AND NOT EXISTS (
+		       SELECT 1 FROM user_blocks b
+		       WHERE (b.blocker_user_id = $9 AND b.blocked_user_id = p.user_id)
+		          OR (b.blocker_user_id = p.user_id AND b.blocked_user_id = $9)
+		   )`,
+		q.ExcludeUserID, q.ExcludeSceneID, q.VenueID,
+		minLat, maxLat, wrapsLon, minLon, maxLon, q.HideBlockedFor,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hits []SceneHit
+	for rows.Next() {
+		var hit SceneHit
+		var lat, lon float64
+		var recipientRadius sql.NullInt64
+		if err := rows.Scan(&hit.SceneID, &hit.UserID, &lat, &lon, &recipientRadius); err != nil {
+			return nil, err
+		}
+
+		radius := q.RadiusMeters
+		if q.UseRecipientRadius && recipientRadius.Valid {
+			radius = float64(recipientRadius.Int64)
+		}
+
+		hit.DistanceMeters = Distance(q.Latitude, q.Longitude, lat, lon)
+		if hit.DistanceMeters > radius {
+			continue
+		}
+		hits = append(hits, hit)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		return hits[i].DistanceMeters < hits[j].DistanceMeters
+	})
+	if q.Limit > 0 && len(hits) > q.Limit {
+		hits = hits[:q.Limit]
+	}
+
+	return hits, nil
+}
+
+// VenueAt checks every venue's polygon in Go. Venues are few, so that beats keeping
+// another spatial index.
+func (p *PortableIndex) VenueAt(lat, lon float64) (*uuid.UUID, error) {
+	rows, err := config.DB.Query(`SELECT id, boundary_geojson FROM venues`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var found *uuid.UUID
+	smallest := math.Inf(1)
+	for rows.Next() {
+		var venueID uuid.UUID
+		var raw []byte
+		if err := rows.Scan(&venueID, &raw); err != nil {
+			return nil, err
+		}
+		polygon, err := ParsePolygon(raw)
+		if err != nil || !polygon.Contains(lat, lon) {
+			continue
+		}
+		if area := polygon.Area(); area < smallest {
+			smallest = area
+			found = &venueID
+		}
+	}
+	return found, rows.Err()
+}
+
+// VenueScenes prefilters on the venue's bounding box, then checks the polygon in Go
+func (p *PortableIndex) VenueScenes(venueID, excludeSceneID uuid.UUID) ([]uuid.UUID, error) {
+	var raw []byte
+	err := config.DB.QueryRow(`SELECT boundary_geojson FROM venues WHERE id = $1`, venueID).Scan(&raw)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	polygon, err := ParsePolygon(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	minLat, maxLat, minLon, maxLon := polygon.Bounds()
+	rows, err := config.DB.Query(
+		`SELECT id, latitude, longitude FROM scenes
+		 WHERE is_active = true
+		   AND expires_at > NOW()
+		   AND id != $1
+		   AND latitude BETWEEN $2 AND $3
+		   AND longitude BETWEEN $4 AND $5`,
+		excludeSceneID, minLat, maxLat, minLon, maxLon,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sceneIDs []uuid.UUID
+	for rows.Next() {
+		var sceneID uuid.UUID
+		var lat, lon float64
+		if err := rows.Scan(&sceneID, &lat, &lon); err != nil {
+			return nil, err
+		}
+		if polygon.Contains(lat, lon) {
+			sceneIDs = append(sceneIDs, sceneID)
+		}
+	}
+	return sceneIDs, rows.Err()
+}
+
+// boundingBox returns a lat/lon box enclosing the circle of radiusMeters around a point.
+// wrapsLon is true when the box crosses the antimeridian or a pole, in which case the
+// longitude bounds must not be used as a filter.
+func boundingBox(lat, lon, radiusMeters float64) (minLat, maxLat, minLon, maxLon float64, wrapsLon bool) {
+	dLat := radiusMeters / metersPerDegree
+	minLat = math.Max(lat-dLat, -90)
+	maxLat = math.Min(lat+dLat, 90)
+
+	// Longitude degrees shrink towards the poles, so size the box at its widest edge
+	cosLat := math.Cos(math.Max(math.Abs(minLat), math.Abs(maxLat)) * math.Pi / 180)
+	if cosLat < 1e-6 || maxLat >= 90 || minLat <= -90 {
+		return minLat, maxLat, -180, 180, true
+	}
+
+	dLon := radiusMeters / (metersPerDegree * cosLat)
+	minLon = lon - dLon
+	maxLon = lon + dLon
+	if minLon < -180 || maxLon > 180 {
+		return minLat, maxLat, -180, 180, true
+	}
+
+	return minLat, maxLat, minLon, maxLon, false
+}
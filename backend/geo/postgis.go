@@ -0,0 +1,108 @@
+package geo
+
+import (
+	"database/sql"
+	"scene-on/backend/config"
+
+	"github.com/google/uuid"
+)
+
+// PostGISIndex runs radius queries in the database against the indexed scenes.geog column
+type PostGISIndex struct{}
+
+func (p *PostGISIndex) NearbyScenes(q NearbyQuery) ([]SceneHit, error) {
+	// A constant radius the GiST index can use; the per-recipient radius below is
+	// checked on the rows it lets through
+	indexRadius := q.RadiusMeters
+	if q.UseRecipientRadius && indexRadius < MaxRecipientRadius {
+		indexRadius = MaxRecipientRadius
+	}
+
+	rows, err := config.DB.Query(
+		`SELECT s.id, p.user_id,
+		        ST_Distance(s.geog, ST_SetSRID(ST_MakePoint($1, $2), 4326)::geography) AS distance
+		 FROM scenes s
+		 JOIN personas p ON s.persona_id = p.id
+		 LEFT JOIN notification_preferences np ON np.user_id = p.user_id
+		 WHERE s.is_active = true
+		   AND s.expires_at > NOW()
+		   AND p.user_id != $4
+		   AND s.id != $5
+		   AND ($6::uuid IS NULL OR s.venue_id = $6)
+		   AND NOT EXISTS (
+		       SELECT 1 FROM user_blocks b
+		       WHERE (b.blocker_user_id = $9 AND b.blocked_user_id = p.user_id)
+		          OR (b.blocker_user_id = p.user_id AND b.blocked_user_id = $9)
+		   )
+		   AND ST_DWithin(s.geog, ST_SetSRID(ST_MakePoint($1, $2), 4326)::geography, $10)
+		   AND ST_DWithin(
+		       s.geog,
+		       ST_SetSRID(ST_MakePoint($1, $2), 4326)::geography,
+		       CASE WHEN $7::boolean THEN COALESCE(np.radius_meters::float8, $3) ELSE $3 END
+		   )
+		 ORDER BY distance
+		 LIMIT NULLIF($8::int, 0)`,
+		q.Longitude, q.Latitude, q.RadiusMeters, q.ExcludeUserID, q.ExcludeSceneID,
+		q.VenueID, q.UseRecipientRadius, q.Limit, q.HideBlockedFor, indexRadius,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hits []SceneHit
+	for rows.Next() {
+		var hit SceneHit
+		if err := rows.Scan(&hit.SceneID, &hit.UserID, &hit.DistanceMeters); err != nil {
+			return nil, err
+		}
+		hits = append(hits, hit)
+	}
+
+	return hits, rows.Err()
+}
+
+func (p *PostGISIndex) VenueAt(lat, lon float64) (*uuid.UUID, error) {
+	var venueID uuid.UUID
+	err := config.DB.QueryRow(
+		`SELECT id FROM venues
+		 WHERE ST_Contains(boundary, ST_SetSRID(ST_MakePoint($1, $2), 4326))
+		 ORDER BY ST_Area(boundary) ASC
+		 LIMIT 1`,
+		lon, lat,
+	).Scan(&venueID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &venueID, nil
+}
+
+func (p *PostGISIndex) VenueScenes(venueID, excludeSceneID uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := config.DB.Query(`
+		SELECT s.id
+		FROM scenes s
+		JOIN venues v ON v.id = $1
+		WHERE s.is_active = true
+		  AND s.expires_at > NOW()
+		  AND s.id != $2
+		  AND ST_Contains(v.boundary, ST_SetSRID(ST_MakePoint(s.longitude, s.latitude), 4326))`,
+		venueID, excludeSceneID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sceneIDs []uuid.UUID
+	for rows.Next() {
+		var sceneID uuid.UUID
+		if err := rows.Scan(&sceneID); err != nil {
+			return nil, err
+		}
+		sceneIDs = append(sceneIDs, sceneID)
+	}
+	return sceneIDs, rows.Err()
+}
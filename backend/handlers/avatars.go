@@ -0,0 +1,315 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"scene-on/backend/config"
+	"scene-on/backend/media"
+	"scene-on/backend/middleware"
+	"scene-on/backend/models"
+	"scene-on/backend/storage"
+	"scene-on/backend/websocket"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// defaultAvatarMaxBytes caps the upload size (AVATAR_MAX_BYTES)
+	defaultAvatarMaxBytes = 5 << 20
+	// defaultAvatarMaxPixels caps decoded dimensions (AVATAR_MAX_PIXELS)
+	defaultAvatarMaxPixels = 16_000_000
+	// avatarURLPrefix is where avatars are served from, on our own origin
+	avatarURLPrefix = "/api/v1/avatars/"
+	// avatarDefaultVariant is the variant stored in the persona's avatar_url
+	avatarDefaultVariant = "medium"
+	// maxAvatarSymbolLength bounds the emoji-style avatars clients may still set directly
+	maxAvatarSymbolLength = 16
+)
+
+// avatarVariants are the square sizes produced for every upload, largest first so
+// each one can be scaled from the previous
+var avatarVariants = []struct {
+	Name string
+	Size int
+}{
+	{"large", 512},
+	{avatarDefaultVariant, 256},
+	{"thumb", 64},
+}
+
+func avatarBlobKey(avatarID uuid.UUID, variant, mimeType string) string {
+	return fmt.Sprintf("avatars/%s/%s%s", avatarID, variant, media.Extension(mimeType))
+}
+
+func avatarURL(avatarID uuid.UUID, variant string) string {
+	return avatarURLPrefix + avatarID.String() + "/" + variant
+}
+
+func isAvatarVariant(name string) bool {
+	for _, v := range avatarVariants {
+		if v.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// validateAvatarURL checks an avatar_url sent with a persona. Only short symbols (the
+// emoji avatars) and avatars uploaded for this persona are allowed; anything that
+// could point at another host is rejected so image loads can't be used to track
+// viewers. personaID is uuid.Nil for a persona that doesn't exist yet.
+func validateAvatarURL(url string, personaID uuid.UUID) *apiError {
+	if url == "" {
+		return nil
+	}
+
+	if rest, ok := strings.CutPrefix(url, avatarURLPrefix); ok {
+		idStr, variant, _ := strings.Cut(rest, "/")
+		avatarID, err := uuid.Parse(idStr)
+		if err == nil && isAvatarVariant(variant) && personaID != uuid.Nil {
+			var owned bool
+			err = config.DB.QueryRow(
+				`SELECT EXISTS(SELECT 1 FROM persona_avatars WHERE id = $1 AND persona_id = $2)`,
+				avatarID, personaID,
+			).Scan(&owned)
+			if err == nil && owned {
+				return nil
+			}
+		}
+		return &apiError{Status: http.StatusBadRequest, Code: "INVALID_AVATAR", Message: "Unknown avatar; upload it to this persona first"}
+	}
+
+	if strings.ContainsAny(url, "/:\\.@") || utf8.RuneCountInString(url) > maxAvatarSymbolLength {
+		return &apiError{
+			Status:  http.StatusBadRequest,
+			Code:    "EXTERNAL_AVATAR_URL",
+			Message: "External avatar URLs are not allowed. Upload an image instead.",
+		}
+	}
+	return nil
+}
+
+// avatarIDFromURL returns the uploaded avatar an avatar_url points at, or uuid.Nil
+func avatarIDFromURL(url string) uuid.UUID {
+	rest, ok := strings.CutPrefix(url, avatarURLPrefix)
+	if !ok {
+		return uuid.Nil
+	}
+	idStr, _, _ := strings.Cut(rest, "/")
+	avatarID, err := uuid.Parse(idStr)
+	if err != nil {
+		return uuid.Nil
+	}
+	return avatarID
+}
+
+// deletePersonaAvatars deletes a persona's uploaded avatars other than keep and returns
+// the blob keys they leave behind. Remove those with deleteAvatarBlobs once q commits.
+func deletePersonaAvatars(q chatQuerier, personaID, keep uuid.UUID) ([]string, error) {
+	rows, err := q.Query(
+		`DELETE FROM persona_avatars WHERE persona_id = $1 AND id != $2 RETURNING id, mime_type`,
+		personaID, keep,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var avatarID uuid.UUID
+		var mimeType string
+		if err := rows.Scan(&avatarID, &mimeType); err != nil {
+			return nil, err
+		}
+		for _, v := range avatarVariants {
+			keys = append(keys, avatarBlobKey(avatarID, v.Name, mimeType))
+		}
+	}
+	return keys, rows.Err()
+}
+
+func deleteAvatarBlobs(blobs storage.BlobStore, keys []string) {
+	for _, key := range keys {
+		if err := blobs.Delete(key); err != nil {
+			log.Printf("Warning: Failed to delete blob %s: %v", key, err)
+		}
+	}
+}
+
+// purgePersonaAvatars deletes a persona's uploaded avatars other than keep, together
+// with their blobs. Call it before deleting the persona, since the rows would
+// otherwise cascade away and leave their blobs behind.
+func purgePersonaAvatars(blobs storage.BlobStore, personaID, keep uuid.UUID) {
+	keys, err := deletePersonaAvatars(config.DB, personaID, keep)
+	if err != nil {
+		log.Printf("Failed to delete persona avatars: %v", err)
+		return
+	}
+	deleteAvatarBlobs(blobs, keys)
+}
+
+// setPersonaAvatar stores an uploaded avatar and points the persona at it. The persona
+// row stays locked until the old avatars are gone, so concurrent uploads (or a PATCH)
+// can't purge the avatar the other one just set. It returns the old avatars' blob keys.
+func setPersonaAvatar(persona *models.Persona, avatarID uuid.UUID, mimeType string) ([]string, error) {
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT 1 FROM personas WHERE id = $1 FOR UPDATE`, persona.ID); err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO persona_avatars (id, persona_id, mime_type) VALUES ($1, $2, $3)`,
+		avatarID, persona.ID, mimeType,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	persona.AvatarURL = avatarURL(avatarID, avatarDefaultVariant)
+	persona.UpdatedAt = time.Now()
+	err = tx.QueryRow(
+		`UPDATE personas SET avatar_url = $1, updated_at = $2 WHERE id = $3
+		 RETURNING name, description`,
+		persona.AvatarURL, persona.UpdatedAt, persona.ID,
+	).Scan(&persona.Name, &persona.Description)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only one avatar per persona is kept
+	keys, err := deletePersonaAvatars(tx, persona.ID, avatarID)
+	if err != nil {
+		return nil, err
+	}
+	return keys, tx.Commit()
+}
+
+// UploadPersonaAvatar replaces a persona's avatar with an uploaded image. The image is
+// re-encoded without metadata and cropped to square variants (see avatarVariants).
+// Multipart field: "image".
+func UploadPersonaAvatar(wsHub *websocket.Hub, blobs storage.BlobStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := middleware.GetUserID(c)
+
+		persona, ok := loadOwnedPersona(c, userID)
+		if !ok {
+			return
+		}
+
+		data, ok := readImageUpload(c, config.GetInt("AVATAR_MAX_BYTES", defaultAvatarMaxBytes), "AVATAR_TOO_LARGE")
+		if !ok {
+			return
+		}
+
+		// Decoding and encoding again keeps only the pixels, dropping EXIF and friends
+		img, mimeType, err := media.Decode(data, config.GetInt("AVATAR_MAX_PIXELS", defaultAvatarMaxPixels))
+		if err != nil {
+			respondImageError(c, err)
+			return
+		}
+
+		avatarID := uuid.New()
+		variants := gin.H{}
+		var stored []string
+		discard := func() {
+			for _, key := range stored {
+				if err := blobs.Delete(key); err != nil {
+					log.Printf("Warning: Failed to delete orphaned blob %s: %v", key, err)
+				}
+			}
+		}
+
+		for _, v := range avatarVariants {
+			img = media.Square(img, v.Size)
+			key := avatarBlobKey(avatarID, v.Name, mimeType)
+
+			out, err := media.Encode(img, mimeType)
+			if err == nil {
+				err = blobs.Put(key, out)
+			}
+			if err != nil {
+				log.Printf("Failed to store avatar: %v", err)
+				discard()
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store image"})
+				return
+			}
+			stored = append(stored, key)
+
+			variants[v.Name] = gin.H{"url": avatarURL(avatarID, v.Name), "size": img.Bounds().Dx()}
+		}
+
+		oldKeys, err := setPersonaAvatar(&persona, avatarID, mimeType)
+		if err != nil {
+			log.Printf("Failed to save avatar: %v", err)
+			discard()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store image"})
+			return
+		}
+
+		deleteAvatarBlobs(blobs, oldKeys)
+		notifyActivePersonaChanged(wsHub, persona)
+
+		log.Printf("🖼️ Uploaded avatar %s for persona %s", avatarID, persona.Name)
+		c.JSON(http.StatusCreated, gin.H{
+			"avatar_url": persona.AvatarURL,
+			"variants":   variants,
+			"persona":    persona,
+		})
+	}
+}
+
+// GetAvatar serves one variant of an uploaded avatar. Avatars are public: their ids are
+// unguessable and change with every upload, so responses can be cached for good.
+func GetAvatar(blobs storage.BlobStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		avatarID, err := uuid.Parse(c.Param("id"))
+		variant := c.Param("variant")
+		if err != nil || !isAvatarVariant(variant) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Avatar not found"})
+			return
+		}
+
+		var mimeType string
+		err = config.DB.QueryRow(`SELECT mime_type FROM persona_avatars WHERE id = $1`, avatarID).Scan(&mimeType)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Avatar not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to get avatar: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get avatar"})
+			return
+		}
+
+		key := avatarBlobKey(avatarID, variant, mimeType)
+		blob, err := blobs.Open(key)
+		if errors.Is(err, storage.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Avatar not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to open avatar blob %s: %v", key, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get avatar"})
+			return
+		}
+		defer blob.Close()
+
+		c.DataFromReader(http.StatusOK, -1, mimeType, blob, map[string]string{
+			"Cache-Control":          "public, max-age=31536000, immutable",
+			"X-Content-Type-Options": "nosniff",
+		})
+	}
+}
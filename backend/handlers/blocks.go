@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"scene-on/backend/config"
+	"scene-on/backend/middleware"
+	"scene-on/backend/websocket"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// personaOwner resolves the path's persona id to its user
+func personaOwner(c *gin.Context) (uuid.UUID, bool) {
+	personaID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid persona id"})
+		return uuid.Nil, false
+	}
+
+	var ownerID uuid.UUID
+	err = config.DB.QueryRow(`SELECT user_id FROM personas WHERE id = $1`, personaID).Scan(&ownerID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Persona not found"})
+		return uuid.Nil, false
+	}
+	if err != nil {
+		log.Printf("Failed to get persona: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get persona"})
+		return uuid.Nil, false
+	}
+	return ownerID, true
+}
+
+// refreshBlocks reloads the hub's blocked-user sets for the active scenes of the given users
+func refreshBlocks(wsHub *websocket.Hub, userIDs ...uuid.UUID) {
+	ids := make([]string, len(userIDs))
+	for i, id := range userIDs {
+		ids[i] = id.String()
+	}
+
+	rows, err := config.DB.Query(
+		`SELECT s.id FROM scenes s
+		 JOIN personas p ON s.persona_id = p.id
+		 WHERE p.user_id = ANY($1::uuid[]) AND s.is_active = true`,
+		ids,
+	)
+	if err != nil {
+		log.Printf("Failed to get scenes to refresh blocks: %v", err)
+		return
+	}
+
+	var sceneIDs []uuid.UUID
+	for rows.Next() {
+		var sceneID uuid.UUID
+		if err := rows.Scan(&sceneID); err == nil {
+			sceneIDs = append(sceneIDs, sceneID)
+		}
+	}
+	rows.Close()
+
+	for _, sceneID := range sceneIDs {
+		wsHub.SetSceneBlocks(sceneID, websocket.LoadSceneBlocks(sceneID))
+	}
+}
+
+// endChatsBetweenUsers rejects pending requests, ends accepted chats and drops likes
+// between the scenes of two users. The cleanup scheduler removes the ended chats'
+// messages and attachments.
+func endChatsBetweenUsers(wsHub *websocket.Hub, userA, userB uuid.UUID) {
+	const between = `(from_scene_id IN (SELECT s.id FROM scenes s JOIN personas p ON s.persona_id = p.id WHERE p.user_id = $3)
+		  AND to_scene_id IN (SELECT s.id FROM scenes s JOIN personas p ON s.persona_id = p.id WHERE p.user_id = $4))
+		 OR (from_scene_id IN (SELECT s.id FROM scenes s JOIN personas p ON s.persona_id = p.id WHERE p.user_id = $4)
+		  AND to_scene_id IN (SELECT s.id FROM scenes s JOIN personas p ON s.persona_id = p.id WHERE p.user_id = $3))`
+
+	if _, err := transitionChatRequests(chatStatusPending, chatStatusRejected, between, "", userA, userB); err != nil {
+		log.Printf("Failed to reject chat requests between blocked users: %v", err)
+	}
+
+	ended, err := transitionChatRequests(chatStatusAccepted, chatStatusEnded, between, `expires_at = NOW()`, userA, userB)
+	if err != nil {
+		log.Printf("Failed to end chats between blocked users: %v", err)
+		return
+	}
+	for _, ref := range ended {
+		notifyChatEnded(wsHub, ref, uuid.Nil)
+	}
+
+	// Pending likes would otherwise still turn into a match
+	_, err = config.DB.Exec(
+		`DELETE FROM scene_likes
+		 WHERE from_scene_id IN (SELECT s.id FROM scenes s JOIN personas p ON s.persona_id = p.id WHERE p.user_id IN ($1, $2))
+		   AND to_scene_id IN (SELECT s.id FROM scenes s JOIN personas p ON s.persona_id = p.id WHERE p.user_id IN ($1, $2))`,
+		userA, userB,
+	)
+	if err != nil {
+		log.Printf("Failed to delete likes between blocked users: %v", err)
+	}
+
+	// The ended chats freed slots that matches with other scenes may be waiting for
+	completeWaitingMatches(wsHub, chatRefScenes(ended)...)
+}
+
+// BlockPersona blocks the user behind a persona. Blocks apply in both directions and to
+// every persona and scene of both users.
+func BlockPersona(wsHub *websocket.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := middleware.GetUserID(c)
+
+		blockedUserID, ok := personaOwner(c)
+		if !ok {
+			return
+		}
+
+		if blockedUserID == userID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot block yourself"})
+			return
+		}
+
+		_, err := config.DB.Exec(
+			`INSERT INTO user_blocks (blocker_user_id, blocked_user_id)
+			 VALUES ($1, $2)
+			 ON CONFLICT (blocker_user_id, blocked_user_id) DO NOTHING`,
+			userID, blockedUserID,
+		)
+		if err != nil {
+			log.Printf("Failed to block user: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to block"})
+			return
+		}
+
+		endChatsBetweenUsers(wsHub, userID, blockedUserID)
+		refreshBlocks(wsHub, userID, blockedUserID)
+
+		log.Printf("🚫 User %s blocked user %s", userID, blockedUserID)
+		c.JSON(http.StatusOK, gin.H{"message": "Blocked"})
+	}
+}
+
+// UnblockPersona lifts a block the caller placed on the user behind a persona
+func UnblockPersona(wsHub *websocket.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := middleware.GetUserID(c)
+
+		blockedUserID, ok := personaOwner(c)
+		if !ok {
+			return
+		}
+
+		result, err := config.DB.Exec(
+			`DELETE FROM user_blocks WHERE blocker_user_id = $1 AND blocked_user_id = $2`,
+			userID, blockedUserID,
+		)
+		if err != nil {
+			log.Printf("Failed to unblock user: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unblock"})
+			return
+		}
+
+		if count, _ := result.RowsAffected(); count == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Not blocked"})
+			return
+		}
+
+		refreshBlocks(wsHub, userID, blockedUserID)
+		c.JSON(http.StatusOK, gin.H{"message": "Unblocked"})
+	}
+}
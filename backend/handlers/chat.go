@@ -2,22 +2,56 @@ package handlers
 
 import (
 	"database/sql"
+	"fmt"
 	"log"
 	"net/http"
 	"scene-on/backend/config"
 	"scene-on/backend/middleware"
 	"scene-on/backend/models"
+	"scene-on/backend/moderation"
 	"scene-on/backend/websocket"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
 
+const (
+	// defaultChatRequestTTL is how long a pending request waits for an answer (CHAT_REQUEST_TTL)
+	defaultChatRequestTTL = 10 * time.Minute
+	// defaultChatDuration is the initial length of an accepted chat (CHAT_DURATION)
+	defaultChatDuration = 5 * time.Minute
+	// defaultMaxConcurrentChats is how many accepted chats a scene may hold at once (CHAT_MAX_CONCURRENT)
+	defaultMaxConcurrentChats = 1
+)
+
+// chatSlotsFull reports whether a scene already holds the maximum number of active chats.
+// On its own this is only advisory; acceptChatRequest enforces the limit.
+func chatSlotsFull(sceneID uuid.UUID) (bool, error) {
+	return chatSlotsFullOn(config.DB, sceneID)
+}
+
+func chatSlotsFullOn(q chatQuerier, sceneID uuid.UUID) (bool, error) {
+	var active int
+	err := q.QueryRow(
+		`SELECT COUNT(*) FROM chat_requests
+		 WHERE (from_scene_id = $1 OR to_scene_id = $1)
+		 AND status = 'accepted' AND expires_at > NOW()`,
+		sceneID,
+	).Scan(&active)
+	if err != nil {
+		return false, err
+	}
+	return active >= config.GetInt("CHAT_MAX_CONCURRENT", defaultMaxConcurrentChats), nil
+}
+
 // Request/Response types
 type SendChatRequestReq struct {
 	ToSceneID string  `json:"to_scene_id" binding:"required"`
 	Message   *string `json:"message,omitempty"`
+	// InviteToken from the target's invite link (POST /scenes/invite) lifts the distance limit
+	InviteToken string `json:"invite_token,omitempty"`
 }
 
 type ChatRequestWithPersona struct {
@@ -28,14 +62,29 @@ type ChatRequestWithPersona struct {
 	ToPersonaName          string `json:"to_persona_name,omitempty"`
 }
 
+// ChatMessagesPage is the GetChatMessages response. Pass BeforeCursor as ?before= to load
+// older messages and AfterCursor as ?after= to fetch newer ones.
+type ChatMessagesPage struct {
+	Messages      []models.ChatMessage `json:"messages"`
+	HasMoreBefore bool                 `json:"has_more_before"`
+	HasMoreAfter  bool                 `json:"has_more_after"`
+	BeforeCursor  string               `json:"before_cursor,omitempty"`
+	AfterCursor   string               `json:"after_cursor,omitempty"`
+}
+
 type SendChatMessageReq struct {
-	RequestID string `json:"request_id" binding:"required"`
-	Content   string `json:"content" binding:"required"`
-	Nonce     string `json:"nonce,omitempty"`
+	RequestID    string `json:"request_id" binding:"required"`
+	Content      string `json:"content"`
+	AttachmentID string `json:"attachment_id,omitempty"` // from UploadChatAttachment
+	// Nonce is a client-generated id; a retry with the same nonce returns the original message
+	Nonce string `json:"nonce,omitempty" binding:"omitempty,max=128"`
+	// Ciphertext and CipherNonce replace Content in end-to-end encrypted chats (base64)
+	Ciphertext  string `json:"ciphertext,omitempty"`
+	CipherNonce string `json:"cipher_nonce,omitempty"`
 }
 
 // SendChatRequest sends a chat request to another scene
-func SendChatRequest(wsHub *websocket.Hub) gin.HandlerFunc {
+func SendChatRequest(wsHub *websocket.Hub, mod moderation.Moderator) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID, _ := middleware.GetUserID(c)
 
@@ -71,14 +120,22 @@ func SendChatRequest(wsHub *websocket.Hub) gin.HandlerFunc {
 			return
 		}
 
-		// Verify target scene exists and is active
-		var targetExists bool
+		// Verify target scene exists and is active. Scenes of users blocked in either
+		// direction look exactly like missing ones.
+		var toUserID uuid.UUID
 		err = config.DB.QueryRow(
-			`SELECT EXISTS(SELECT 1 FROM scenes WHERE id = $1 AND is_active = true AND expires_at > NOW())`,
-			toSceneID,
-		).Scan(&targetExists)
+			`SELECT p.user_id FROM scenes s
+			 JOIN personas p ON s.persona_id = p.id
+			 WHERE s.id = $1 AND s.is_active = true AND s.expires_at > NOW()
+			 AND NOT EXISTS (
+				SELECT 1 FROM user_blocks b
+				WHERE (b.blocker_user_id = $2 AND b.blocked_user_id = p.user_id)
+				   OR (b.blocker_user_id = p.user_id AND b.blocked_user_id = $2)
+			 )`,
+			toSceneID, userID,
+		).Scan(&toUserID)
 
-		if err != nil || !targetExists {
+		if err != nil {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Target scene not found or inactive"})
 			return
 		}
@@ -89,6 +146,17 @@ func SendChatRequest(wsHub *websocket.Hub) gin.HandlerFunc {
 			return
 		}
 
+		// Chats are for people nearby, unless the target shared an invite link
+		maxDistance, apiErr := chatRequestMaxDistance(toSceneID, req.InviteToken)
+		if apiErr != nil {
+			apiErr.respond(c)
+			return
+		}
+		if apiErr := checkSceneDistance(fromSceneID, toSceneID, maxDistance); apiErr != nil {
+			apiErr.respond(c)
+			return
+		}
+
 		// Check if there's already a pending or accepted request between these scenes
 		var existingID uuid.UUID
 		err = config.DB.QueryRow(
@@ -104,28 +172,49 @@ func SendChatRequest(wsHub *websocket.Hub) gin.HandlerFunc {
 			return
 		}
 
-		// Create chat request
+		// Tell the sender up front when the recipient has no free chat slot
+		if full, err := chatSlotsFull(toSceneID); err != nil {
+			log.Printf("Failed to count active chats: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create chat request"})
+			return
+		} else if full {
+			c.JSON(http.StatusConflict, gin.H{
+				"error": "This scene is busy in another chat. Try again later.",
+				"code":  "RECIPIENT_BUSY",
+			})
+			return
+		}
+
+		if req.Message != nil {
+			message, ok := moderateText(c, mod, moderation.ContextChatRequest, userID, *req.Message)
+			if !ok {
+				return
+			}
+			req.Message = &message
+		}
+
+		// Create chat request; it stays pending until accepted, rejected or its TTL passes
+		now := time.Now().UTC()
+		pendingExpiresAt := now.Add(config.GetDuration("CHAT_REQUEST_TTL", defaultChatRequestTTL))
 		chatRequest := models.ChatRequest{
 			ID:          uuid.New(),
 			FromSceneID: fromSceneID,
 			ToSceneID:   toSceneID,
 			Message:     req.Message,
 			Status:      "pending",
-			CreatedAt:   time.Now(),
+			ExpiresAt:   &pendingExpiresAt,
+			CreatedAt:   now,
 		}
 
-		_, err = config.DB.Exec(
-			`INSERT INTO chat_requests (id, from_scene_id, to_scene_id, message, status, created_at)
-			 VALUES ($1, $2, $3, $4, $5, $6)`,
-			chatRequest.ID, chatRequest.FromSceneID, chatRequest.ToSceneID,
-			chatRequest.Message, chatRequest.Status, chatRequest.CreatedAt,
-		)
-
-		if err != nil {
+		if hit, err := insertChatRequest(&chatRequest, userID, toUserID); err != nil {
 			log.Printf("Failed to create chat request: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create chat request"})
 			return
+		} else if hit != nil {
+			hit.respond(c)
+			return
 		}
+		countSceneActivity(config.DB, recapRequestsReceived, toSceneID)
 
 		// Fetch requester persona info for the notification
 		var fromPersonaName, fromPersonaAvatar, fromPersonaDescription string
@@ -150,6 +239,7 @@ func SendChatRequest(wsHub *websocket.Hub) gin.HandlerFunc {
 						"from_persona_avatar":      fromPersonaAvatar,
 						"from_persona_description": fromPersonaDescription,
 						"message":                  chatRequest.Message,
+						"expires_at":               chatRequest.ExpiresAt,
 						"created_at":               chatRequest.CreatedAt,
 					},
 				},
@@ -193,6 +283,7 @@ func GetChatInbox(c *gin.Context) {
 		 JOIN scenes s ON cr.from_scene_id = s.id
 		 JOIN personas p ON s.persona_id = p.id
 		 WHERE cr.to_scene_id = $1 AND cr.status = 'pending'
+		 AND (cr.expires_at IS NULL OR cr.expires_at > NOW())
 		 ORDER BY cr.created_at DESC`,
 		userSceneID,
 	)
@@ -259,6 +350,7 @@ func GetSentChatRequests(c *gin.Context) {
 		 JOIN scenes s ON cr.to_scene_id = s.id
 		 JOIN personas p ON s.persona_id = p.id
 		 WHERE cr.from_scene_id = $1 AND cr.status = 'pending'
+		 AND (cr.expires_at IS NULL OR cr.expires_at > NOW())
 		 ORDER BY cr.created_at DESC`,
 		userSceneID,
 	)
@@ -329,10 +421,11 @@ func AcceptChatRequest(wsHub *websocket.Hub) gin.HandlerFunc {
 		// Verify request is for this user's scene and is pending
 		var fromSceneID, toSceneID uuid.UUID
 		var status string
+		var pendingExpiresAt *time.Time
 		err = config.DB.QueryRow(
-			`SELECT from_scene_id, to_scene_id, status FROM chat_requests WHERE id = $1`,
+			`SELECT from_scene_id, to_scene_id, status, expires_at FROM chat_requests WHERE id = $1`,
 			reqUUID,
-		).Scan(&fromSceneID, &toSceneID, &status)
+		).Scan(&fromSceneID, &toSceneID, &status, &pendingExpiresAt)
 
 		if err == sql.ErrNoRows {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Chat request not found"})
@@ -349,27 +442,61 @@ func AcceptChatRequest(wsHub *websocket.Hub) gin.HandlerFunc {
 			return
 		}
 
-		if status != "pending" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Request already " + status})
+		if !chatTransitionAllowed(status, chatStatusAccepted) {
+			chatTransitionError(status, chatStatusAccepted).respond(c)
 			return
 		}
 
-		// Accept request and set expiration (5 minutes from now)
-		now := time.Now().UTC()
-		expiresAt := now.Add(5 * time.Minute)
-
-		_, err = config.DB.Exec(
-			`UPDATE chat_requests 
-			 SET status = 'accepted', accepted_at = $1, expires_at = $2
-			 WHERE id = $3`,
-			now, expiresAt, reqUUID,
-		)
+		// The scheduler may not have caught up with an expired pending request yet
+		if pendingExpiresAt != nil && time.Now().After(*pendingExpiresAt) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Request already expired"})
+			return
+		}
 
+		// The chat is end-to-end encrypted when both scenes published a public key
+		fromKey, toKey, err := scenePublicKeys(fromSceneID, toSceneID)
 		if err != nil {
-			log.Printf("Failed to accept chat request: %v", err)
+			log.Printf("Failed to get scene public keys: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to accept chat request"})
 			return
 		}
+		e2e := fromKey != nil && toKey != nil
+
+		// Accept request and set expiration (CHAT_DURATION, 5 minutes by default)
+		now := time.Now().UTC()
+		expiresAt := now.Add(config.GetDuration("CHAT_DURATION", defaultChatDuration))
+
+		// Enforces the concurrent chat limit on both sides
+		ref := chatRequestRef{ID: reqUUID, FromSceneID: fromSceneID, ToSceneID: toSceneID}
+		fullSceneID, apiErr := acceptChatRequest(ref, `accepted_at = $4, expires_at = $5, e2e = $6`, now, expiresAt, e2e)
+		if apiErr != nil {
+			apiErr.respond(c)
+			return
+		}
+		switch fullSceneID {
+		case toSceneID:
+			// The request stays pending so it can be accepted once a slot frees up
+			wsHub.Targeted <- websocket.TargetedMessage{
+				TargetSceneID: fromSceneID,
+				Message: websocket.Message{
+					Type: "chat.request.busy",
+					Data: map[string]interface{}{
+						"request_id": reqUUID.String(),
+					},
+				},
+			}
+			c.JSON(http.StatusConflict, gin.H{
+				"error": "You already have the maximum number of active chats. End one first.",
+				"code":  "CHAT_LIMIT_REACHED",
+			})
+			return
+		case fromSceneID:
+			c.JSON(http.StatusConflict, gin.H{
+				"error": "The requester is busy in another chat. Try again later.",
+				"code":  "REQUESTER_BUSY",
+			})
+			return
+		}
 
 		// Send WebSocket notification to both parties via Targeted messages
 		acceptedMsg := websocket.Message{
@@ -379,8 +506,14 @@ func AcceptChatRequest(wsHub *websocket.Hub) gin.HandlerFunc {
 				"expires_at":   expiresAt.Format(time.RFC3339),
 				"from_scene_id": fromSceneID.String(),
 				"to_scene_id":   toSceneID.String(),
+				"e2e":           e2e,
 			},
 		}
+		if e2e {
+			// Key exchange: each side derives the shared secret from the other's public key
+			acceptedMsg.Data["from_public_key"] = *fromKey
+			acceptedMsg.Data["to_public_key"] = *toKey
+		}
 
 		// Notify requester
 		wsHub.Targeted <- websocket.TargetedMessage{
@@ -393,11 +526,16 @@ func AcceptChatRequest(wsHub *websocket.Hub) gin.HandlerFunc {
 			Message:       acceptedMsg,
 		}
 
-		c.JSON(http.StatusOK, gin.H{
+		response := gin.H{
 			"message":    "Chat request accepted",
 			"request_id": reqUUID.String(),
 			"expires_at": expiresAt,
-		})
+			"e2e":        e2e,
+		}
+		if e2e {
+			response["from_public_key"] = *fromKey
+		}
+		c.JSON(http.StatusOK, response)
 	}
 }
 
@@ -457,22 +595,12 @@ func RejectChatRequest(wsHub *websocket.Hub) gin.HandlerFunc {
 			return
 		}
 
-		if status != "pending" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Request already " + status})
-			return
-		}
-
 		// Reject request
-		_, err = config.DB.Exec(
-			`UPDATE chat_requests SET status = 'rejected' WHERE id = $1`,
-			reqUUID,
-		)
-
-		if err != nil {
-			log.Printf("Failed to reject chat request: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reject chat request"})
+		if apiErr := transitionChatRequest(reqUUID, status, chatStatusRejected, ""); apiErr != nil {
+			apiErr.respond(c)
 			return
 		}
+		startRequestCooldown(fromSceneID, toSceneID)
 
 		// Send Targeted WebSocket notification to requester
 		wsHub.Targeted <- websocket.TargetedMessage{
@@ -540,22 +668,11 @@ func CancelChatRequest(wsHub *websocket.Hub) gin.HandlerFunc {
 			return
 		}
 
-		if status != "pending" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Request already " + status})
-			return
-		}
-
-		// Set to 'rejected' to cancel
-		_, err = config.DB.Exec(
-			`UPDATE chat_requests SET status = 'rejected' WHERE id = $1`,
-			reqUUID,
-		)
-
-		if err != nil {
-			log.Printf("Failed to cancel chat request: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel chat request"})
+		if apiErr := transitionChatRequest(reqUUID, status, chatStatusCanceled, ""); apiErr != nil {
+			apiErr.respond(c)
 			return
 		}
+		startRequestCooldown(fromSceneID, toSceneID)
 
 		// Notify recipient via WebSocket
 		wsHub.Targeted <- websocket.TargetedMessage{
@@ -573,7 +690,7 @@ func CancelChatRequest(wsHub *websocket.Hub) gin.HandlerFunc {
 }
 
 // SendChatMessage sends a message in an accepted chat
-func SendChatMessage(wsHub *websocket.Hub) gin.HandlerFunc {
+func SendChatMessage(wsHub *websocket.Hub, mod moderation.Moderator) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID, _ := middleware.GetUserID(c)
 
@@ -589,6 +706,11 @@ func SendChatMessage(wsHub *websocket.Hub) gin.HandlerFunc {
 			return
 		}
 
+		if req.Content == "" && req.AttachmentID == "" && req.Ciphertext == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "content, ciphertext or attachment_id is required"})
+			return
+		}
+
 		// Get user's active scene
 		var userSceneID uuid.UUID
 		err = config.DB.QueryRow(
@@ -613,11 +735,12 @@ func SendChatMessage(wsHub *websocket.Hub) gin.HandlerFunc {
 		var fromSceneID, toSceneID uuid.UUID
 		var status string
 		var expiresAt *time.Time
+		var e2e bool
 		err = config.DB.QueryRow(
-			`SELECT from_scene_id, to_scene_id, status, expires_at 
+			`SELECT from_scene_id, to_scene_id, status, expires_at, e2e 
 			 FROM chat_requests WHERE id = $1`,
 			reqUUID,
-		).Scan(&fromSceneID, &toSceneID, &status, &expiresAt)
+		).Scan(&fromSceneID, &toSceneID, &status, &expiresAt, &e2e)
 
 		if err == sql.ErrNoRows {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Chat not found"})
@@ -647,19 +770,87 @@ func SendChatMessage(wsHub *websocket.Hub) gin.HandlerFunc {
 			return
 		}
 
+		// A retried send gets the message stored the first time instead of a duplicate
+		if req.Nonce != "" {
+			existing, err := loadMessageByNonce(reqUUID, userSceneID, req.Nonce)
+			if err == nil {
+				c.JSON(http.StatusOK, existing)
+				return
+			}
+			if err != sql.ErrNoRows {
+				log.Printf("Failed to look up message nonce: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message"})
+				return
+			}
+		}
+
+		// Encrypted chats carry only opaque ciphertext, which the server relays as-is
+		// and cannot moderate
+		content := req.Content
+		if e2e {
+			if req.Content != "" || req.AttachmentID != "" {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": "This chat is end-to-end encrypted; send ciphertext only",
+					"code":  "E2E_REQUIRED",
+				})
+				return
+			}
+			if err := validateCiphertext(req.Ciphertext, req.CipherNonce); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": "E2E_REQUIRED"})
+				return
+			}
+		} else {
+			if req.Ciphertext != "" || req.CipherNonce != "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "This chat is not end-to-end encrypted", "code": "E2E_NOT_ENABLED"})
+				return
+			}
+			var ok bool
+			content, ok = moderateText(c, mod, moderation.ContextChatMessage, userID, req.Content)
+			if !ok {
+				return
+			}
+		}
+
 		// Create message
 		message := models.ChatMessage{
 			ID:            uuid.New(),
 			ChatRequestID: reqUUID,
 			FromSceneID:   userSceneID,
-			Content:       req.Content,
+			Content:       content,
+			Ciphertext:    req.Ciphertext,
+			CipherNonce:   req.CipherNonce,
+			Status:        messageStatusSent,
 			CreatedAt:     time.Now(),
 		}
 
-		_, err = config.DB.Exec(
-			`INSERT INTO chat_messages (id, chat_request_id, from_scene_id, content, created_at)
-			 VALUES ($1, $2, $3, $4, $5)`,
-			message.ID, message.ChatRequestID, message.FromSceneID, message.Content, message.CreatedAt,
+		// The attachment must have been uploaded by this scene to this chat
+		if req.AttachmentID != "" {
+			attachmentID, err := uuid.Parse(req.AttachmentID)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid attachment_id"})
+				return
+			}
+			attachment, err := loadChatAttachment(attachmentID, reqUUID, userSceneID)
+			if err == sql.ErrNoRows {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Attachment not found"})
+				return
+			}
+			if err != nil {
+				log.Printf("Failed to get attachment: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message"})
+				return
+			}
+			message.AttachmentID = &attachment.ID
+			message.Attachment = attachment
+		}
+
+		result, err := config.DB.Exec(
+			`INSERT INTO chat_messages (id, chat_request_id, from_scene_id, content, ciphertext, cipher_nonce,
+			                            attachment_id, client_nonce, created_at)
+			 VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), $7, NULLIF($8, ''), $9)
+			 ON CONFLICT (chat_request_id, from_scene_id, client_nonce) WHERE client_nonce IS NOT NULL DO NOTHING`,
+			message.ID, message.ChatRequestID, message.FromSceneID, message.Content,
+			message.Ciphertext, message.CipherNonce, message.AttachmentID, req.Nonce, message.CreatedAt,
 		)
 
 		if err != nil {
@@ -668,6 +859,20 @@ func SendChatMessage(wsHub *websocket.Hub) gin.HandlerFunc {
 			return
 		}
 
+		// A concurrent retry with the same nonce won the insert
+		if count, _ := result.RowsAffected(); count == 0 {
+			existing, err := loadMessageByNonce(reqUUID, userSceneID, req.Nonce)
+			if err != nil {
+				log.Printf("Failed to load message by nonce: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message"})
+				return
+			}
+			c.JSON(http.StatusOK, existing)
+			return
+		}
+
+		countSceneActivity(config.DB, recapMessages, fromSceneID, toSceneID)
+
 		// Send WebSocket notification to other party
 		otherSceneID := toSceneID
 		if userSceneID == toSceneID {
@@ -683,6 +888,9 @@ func SendChatMessage(wsHub *websocket.Hub) gin.HandlerFunc {
 					"request_id":      reqUUID.String(),
 					"from_scene_id":   message.FromSceneID.String(),
 					"content":         message.Content,
+					"ciphertext":      message.Ciphertext,
+					"cipher_nonce":    message.CipherNonce,
+					"attachment":      message.Attachment,
 					"nonce":           req.Nonce,
 					"created_at":      message.CreatedAt.Format(time.RFC3339),
 					"target_scene_id": otherSceneID.String(),
@@ -694,7 +902,33 @@ func SendChatMessage(wsHub *websocket.Hub) gin.HandlerFunc {
 	}
 }
 
-// GetChatMessages gets all messages in a chat session
+// loadMessageByNonce finds the message a scene already sent to a chat with a client nonce
+func loadMessageByNonce(reqUUID, sceneID uuid.UUID, nonce string) (*models.ChatMessage, error) {
+	var msg models.ChatMessage
+	err := config.DB.QueryRow(
+		`SELECT id, chat_request_id, from_scene_id, content,
+		        COALESCE(ciphertext, ''), COALESCE(cipher_nonce, ''), edited, edited_at,
+		        delivered_at, read_at, attachment_id, created_at
+		 FROM chat_messages
+		 WHERE chat_request_id = $1 AND from_scene_id = $2 AND client_nonce = $3`,
+		reqUUID, sceneID, nonce,
+	).Scan(&msg.ID, &msg.ChatRequestID, &msg.FromSceneID, &msg.Content, &msg.Ciphertext, &msg.CipherNonce,
+		&msg.Edited, &msg.EditedAt, &msg.DeliveredAt, &msg.ReadAt, &msg.AttachmentID, &msg.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	msg.Status = messageStatus(msg.DeliveredAt, msg.ReadAt)
+
+	messages := []models.ChatMessage{msg}
+	attachReactionCounts(messages)
+	attachChatAttachments(messages)
+	return &messages[0], nil
+}
+
+// GetChatMessages gets a page of messages in a chat session.
+// Query params: limit (page size), before/after (cursors from a previous page) or
+// since (RFC3339 timestamp, for catching up after a reconnect). Without a cursor the
+// newest page is returned. Messages are always in chronological order.
 func GetChatMessages(c *gin.Context) {
 	userID, _ := middleware.GetUserID(c)
 	requestID := c.Param("request_id")
@@ -705,6 +939,30 @@ func GetChatMessages(c *gin.Context) {
 		return
 	}
 
+	limit := defaultMessagePageSize
+	if limitStr := c.Query("limit"); limitStr != "" {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+		if limit > maxMessagePageSize {
+			limit = maxMessagePageSize
+		}
+	}
+
+	beforeStr, afterStr, sinceStr := c.Query("before"), c.Query("after"), c.Query("since")
+	cursorParams := 0
+	for _, v := range []string{beforeStr, afterStr, sinceStr} {
+		if v != "" {
+			cursorParams++
+		}
+	}
+	if cursorParams > 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Use only one of before, after or since"})
+		return
+	}
+
 	// Get user's active scene
 	var userSceneID uuid.UUID
 	err = config.DB.QueryRow(
@@ -747,14 +1005,55 @@ func GetChatMessages(c *gin.Context) {
 		return
 	}
 
-	// Get messages
-	rows, err := config.DB.Query(
-		`SELECT id, chat_request_id, from_scene_id, content, created_at
+	// Build the page query. Forward pages (after/since) read ascending; backward pages
+	// (before, or the newest page by default) read descending and are reversed below.
+	query := `SELECT id, chat_request_id, from_scene_id, content,
+		        COALESCE(ciphertext, ''), COALESCE(cipher_nonce, ''), edited, edited_at,
+		        delivered_at, read_at, attachment_id, created_at
 		 FROM chat_messages
-		 WHERE chat_request_id = $1
-		 ORDER BY created_at ASC`,
-		reqUUID,
-	)
+		 WHERE chat_request_id = $1`
+	args := []interface{}{reqUUID}
+	descending := true
+
+	switch {
+	case afterStr != "":
+		cursor, err := parseMessageCursor(afterStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid after cursor"})
+			return
+		}
+		query += ` AND (created_at, id) > ($2, $3)`
+		args = append(args, cursor.CreatedAt, cursor.ID)
+		descending = false
+	case sinceStr != "":
+		since, err := time.Parse(time.RFC3339Nano, sinceStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid since timestamp (expected RFC3339)"})
+			return
+		}
+		query += ` AND created_at > $2`
+		args = append(args, since)
+		descending = false
+	case beforeStr != "":
+		cursor, err := parseMessageCursor(beforeStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid before cursor"})
+			return
+		}
+		query += ` AND (created_at, id) < ($2, $3)`
+		args = append(args, cursor.CreatedAt, cursor.ID)
+	}
+
+	if descending {
+		query += ` ORDER BY created_at DESC, id DESC`
+	} else {
+		query += ` ORDER BY created_at ASC, id ASC`
+	}
+	// Fetch one extra row to learn whether more messages exist in the paging direction
+	query += fmt.Sprintf(` LIMIT %d`, limit+1)
+
+	// Get messages
+	rows, err := config.DB.Query(query, args...)
 
 	if err != nil {
 		log.Printf("Failed to get messages: %v", err)
@@ -766,19 +1065,63 @@ func GetChatMessages(c *gin.Context) {
 	var messages []models.ChatMessage
 	for rows.Next() {
 		var msg models.ChatMessage
-		err := rows.Scan(&msg.ID, &msg.ChatRequestID, &msg.FromSceneID, &msg.Content, &msg.CreatedAt)
+		err := rows.Scan(&msg.ID, &msg.ChatRequestID, &msg.FromSceneID, &msg.Content, &msg.Ciphertext, &msg.CipherNonce,
+			&msg.Edited, &msg.EditedAt, &msg.DeliveredAt, &msg.ReadAt, &msg.AttachmentID, &msg.CreatedAt)
 		if err != nil {
 			log.Printf("Failed to scan message: %v", err)
 			continue
 		}
+		msg.Status = messageStatus(msg.DeliveredAt, msg.ReadAt)
 		messages = append(messages, msg)
 	}
 
+	hasMore := len(messages) > limit
+	if hasMore {
+		messages = messages[:limit]
+	}
+	if descending {
+		for l, r := 0, len(messages)-1; l < r; l, r = l+1, r-1 {
+			messages[l], messages[r] = messages[r], messages[l]
+		}
+	}
+
 	if messages == nil {
 		messages = []models.ChatMessage{}
 	}
+	attachReactionCounts(messages)
+	attachChatAttachments(messages)
+
+	page := ChatMessagesPage{Messages: messages}
+	if descending {
+		page.HasMoreBefore = hasMore
+		// A backward page ends right before the cursor, so newer messages exist
+		page.HasMoreAfter = beforeStr != ""
+	} else {
+		page.HasMoreAfter = hasMore
+		page.HasMoreBefore = afterStr != "" || (sinceStr != "" && len(messages) > 0 && chatHasMessagesBefore(reqUUID, messages[0]))
+	}
 
-	c.JSON(http.StatusOK, messages)
+	if len(messages) > 0 {
+		page.BeforeCursor = messageCursor{CreatedAt: messages[0].CreatedAt, ID: messages[0].ID}.String()
+		last := messages[len(messages)-1]
+		page.AfterCursor = messageCursor{CreatedAt: last.CreatedAt, ID: last.ID}.String()
+	} else if afterStr != "" {
+		// Nothing new yet: keep syncing from the same position
+		page.AfterCursor = afterStr
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// chatHasMessagesBefore reports whether the chat has messages older than msg
+func chatHasMessagesBefore(reqUUID uuid.UUID, msg models.ChatMessage) bool {
+	var exists bool
+	err := config.DB.QueryRow(
+		`SELECT EXISTS(SELECT 1 FROM chat_messages
+		 WHERE chat_request_id = $1 AND (created_at, id) < ($2, $3))`,
+		reqUUID, msg.CreatedAt, msg.ID,
+	).Scan(&exists)
+	return err == nil && exists
 }
 
 // GetActiveChatSessions gets all active chats for user's scene
@@ -807,17 +1150,20 @@ func GetActiveChatSessions(c *gin.Context) {
 
 	// Get active chat sessions
 	rows, err := config.DB.Query(
-		`SELECT cr.id, cr.from_scene_id, cr.to_scene_id, cr.expires_at,
+		`SELECT cr.id, cr.from_scene_id, cr.to_scene_id, cr.expires_at, cr.e2e,
 		        p.name as other_persona_name, p.avatar_url as other_persona_avatar,
 		        p.description as other_persona_description,
+		        s.public_key as other_public_key,
 		        cm.content as last_message_content,
+		        cm.ciphertext as last_message_ciphertext,
+		        cm.cipher_nonce as last_message_cipher_nonce,
 		        cm.from_scene_id as last_message_sender_id,
 		        cm.created_at as last_message_at
 		 FROM chat_requests cr
 		 JOIN scenes s ON (CASE WHEN cr.from_scene_id = $1 THEN cr.to_scene_id ELSE cr.from_scene_id END) = s.id
 		 JOIN personas p ON s.persona_id = p.id
 		 LEFT JOIN LATERAL (
-		     SELECT content, from_scene_id, created_at
+		     SELECT content, ciphertext, cipher_nonce, from_scene_id, created_at
 		     FROM chat_messages
 		     WHERE chat_request_id = cr.id
 		     ORDER BY created_at DESC
@@ -841,14 +1187,17 @@ func GetActiveChatSessions(c *gin.Context) {
 	for rows.Next() {
 		var id, fromSceneID, toSceneID uuid.UUID
 		var expiresAt time.Time
+		var e2e bool
 		var otherPersonaName, otherPersonaAvatar, otherPersonaDescription string
-		var lastMsgContent, lastMsgSenderID sql.NullString
+		var otherPublicKey sql.NullString
+		var lastMsgContent, lastMsgCiphertext, lastMsgCipherNonce, lastMsgSenderID sql.NullString
 		var lastMsgAt sql.NullTime
 
 		err := rows.Scan(
-			&id, &fromSceneID, &toSceneID, &expiresAt,
+			&id, &fromSceneID, &toSceneID, &expiresAt, &e2e,
 			&otherPersonaName, &otherPersonaAvatar, &otherPersonaDescription,
-			&lastMsgContent, &lastMsgSenderID, &lastMsgAt,
+			&otherPublicKey,
+			&lastMsgContent, &lastMsgCiphertext, &lastMsgCipherNonce, &lastMsgSenderID, &lastMsgAt,
 		)
 		if err != nil {
 			log.Printf("Failed to scan session: %v", err)
@@ -863,10 +1212,20 @@ func GetActiveChatSessions(c *gin.Context) {
 			"other_persona_name":        otherPersonaName,
 			"other_persona_avatar":      otherPersonaAvatar,
 			"other_persona_description": otherPersonaDescription,
+			"e2e":                       e2e,
+		}
+
+		// Lets a reconnecting client re-derive the shared key of an encrypted chat
+		if e2e && otherPublicKey.Valid {
+			session["other_public_key"] = otherPublicKey.String
 		}
 
 		if lastMsgContent.Valid {
 			session["last_message_content"] = lastMsgContent.String
+			if lastMsgCiphertext.Valid {
+				session["last_message_ciphertext"] = lastMsgCiphertext.String
+				session["last_message_cipher_nonce"] = lastMsgCipherNonce.String
+			}
 			session["last_message_sender_id"] = lastMsgSenderID.String
 			session["last_message_at"] = lastMsgAt.Time
 		}
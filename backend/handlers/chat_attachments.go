@@ -0,0 +1,352 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"scene-on/backend/config"
+	"scene-on/backend/media"
+	"scene-on/backend/middleware"
+	"scene-on/backend/models"
+	"scene-on/backend/storage"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// defaultAttachmentMaxBytes caps the upload size (ATTACHMENT_MAX_BYTES)
+	defaultAttachmentMaxBytes = 5 << 20
+	// defaultAttachmentMaxPixels caps decoded dimensions (ATTACHMENT_MAX_PIXELS)
+	defaultAttachmentMaxPixels = 16_000_000
+	// defaultAttachmentURLTTL is how long a signed attachment URL stays valid (ATTACHMENT_URL_TTL)
+	defaultAttachmentURLTTL = 5 * time.Minute
+)
+
+// attachmentURLSecret signs attachment URLs (ATTACHMENT_URL_SECRET, falling back to JWT_SECRET)
+func attachmentURLSecret() []byte {
+	if secret := os.Getenv("ATTACHMENT_URL_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+	return []byte(os.Getenv("JWT_SECRET"))
+}
+
+func attachmentSignature(attachmentID string, expires int64) string {
+	mac := hmac.New(sha256.New, attachmentURLSecret())
+	fmt.Fprintf(mac, "%s:%d", attachmentID, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signAttachmentURL returns a short-lived link to an attachment. The signature is the
+// authorization, so the link works in an <img> tag without the bearer token.
+func signAttachmentURL(attachmentID uuid.UUID) (string, time.Time) {
+	expiresAt := time.Now().Add(config.GetDuration("ATTACHMENT_URL_TTL", defaultAttachmentURLTTL)).UTC()
+	expires := expiresAt.Unix()
+	url := fmt.Sprintf("/api/v1/chat/attachments/%s?expires=%d&sig=%s",
+		attachmentID, expires, attachmentSignature(attachmentID.String(), expires))
+	return url, time.Unix(expires, 0).UTC()
+}
+
+// loadChatAttachment loads an attachment uploaded by sceneID to the given chat
+func loadChatAttachment(attachmentID, reqUUID, sceneID uuid.UUID) (*models.ChatAttachment, error) {
+	var att models.ChatAttachment
+	err := config.DB.QueryRow(
+		`SELECT id, mime_type, size_bytes, width, height
+		 FROM chat_attachments
+		 WHERE id = $1 AND chat_request_id = $2 AND from_scene_id = $3`,
+		attachmentID, reqUUID, sceneID,
+	).Scan(&att.ID, &att.MimeType, &att.SizeBytes, &att.Width, &att.Height)
+	if err != nil {
+		return nil, err
+	}
+	att.URL, att.URLExpiresAt = signAttachmentURL(att.ID)
+	return &att, nil
+}
+
+// attachChatAttachments fills in the Attachment field (with fresh signed URLs) of
+// each message that has one
+func attachChatAttachments(messages []models.ChatMessage) {
+	var ids []string
+	for _, msg := range messages {
+		if msg.AttachmentID != nil {
+			ids = append(ids, msg.AttachmentID.String())
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	rows, err := config.DB.Query(
+		`SELECT id, mime_type, size_bytes, width, height
+		 FROM chat_attachments
+		 WHERE id = ANY($1::uuid[])`,
+		ids,
+	)
+	if err != nil {
+		log.Printf("Failed to load chat attachments: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	attachments := make(map[uuid.UUID]*models.ChatAttachment)
+	for rows.Next() {
+		var att models.ChatAttachment
+		if err := rows.Scan(&att.ID, &att.MimeType, &att.SizeBytes, &att.Width, &att.Height); err != nil {
+			log.Printf("Failed to scan chat attachment: %v", err)
+			continue
+		}
+		att.URL, att.URLExpiresAt = signAttachmentURL(att.ID)
+		attachments[att.ID] = &att
+	}
+
+	for i := range messages {
+		if messages[i].AttachmentID != nil {
+			messages[i].Attachment = attachments[*messages[i].AttachmentID]
+		}
+	}
+}
+
+// UploadChatAttachment stores an image for an active chat. The image is re-encoded to
+// strip metadata (EXIF GPS in particular) and can then be sent with SendChatMessage
+// via attachment_id. Multipart field: "image".
+func UploadChatAttachment(blobs storage.BlobStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := middleware.GetUserID(c)
+
+		reqUUID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request_id"})
+			return
+		}
+
+		// Get user's active scene
+		var userSceneID uuid.UUID
+		err = config.DB.QueryRow(
+			`SELECT s.id FROM scenes s
+			 JOIN personas p ON s.persona_id = p.id
+			 WHERE p.user_id = $1 AND s.is_active = true AND s.expires_at > NOW()
+			 ORDER BY s.started_at DESC LIMIT 1`,
+			userID,
+		).Scan(&userSceneID)
+
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No active scene found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to get active scene: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get active scene"})
+			return
+		}
+
+		// Verify chat is accepted, not expired, and user is part of it
+		var fromSceneID, toSceneID uuid.UUID
+		var status string
+		var expiresAt *time.Time
+		var e2e bool
+		err = config.DB.QueryRow(
+			`SELECT from_scene_id, to_scene_id, status, expires_at, e2e
+			 FROM chat_requests WHERE id = $1`,
+			reqUUID,
+		).Scan(&fromSceneID, &toSceneID, &status, &expiresAt, &e2e)
+
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Chat not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to get chat request: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get chat"})
+			return
+		}
+
+		if userSceneID != fromSceneID && userSceneID != toSceneID {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You are not part of this chat"})
+			return
+		}
+
+		if status != "accepted" || (expiresAt != nil && time.Now().After(*expiresAt)) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Chat is no longer active"})
+			return
+		}
+
+		// Attachments are processed server-side, which encrypted chats must not allow
+		if e2e {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Attachments are not supported in end-to-end encrypted chats",
+				"code":  "E2E_UNSUPPORTED",
+			})
+			return
+		}
+
+		data, ok := readImageUpload(c, config.GetInt("ATTACHMENT_MAX_BYTES", defaultAttachmentMaxBytes), "ATTACHMENT_TOO_LARGE")
+		if !ok {
+			return
+		}
+
+		img, err := media.Sanitize(data, config.GetInt("ATTACHMENT_MAX_PIXELS", defaultAttachmentMaxPixels))
+		if err != nil {
+			respondImageError(c, err)
+			return
+		}
+
+		attachmentID := uuid.New()
+		blobKey := fmt.Sprintf("attachments/%s/%s%s", reqUUID, attachmentID, media.Extension(img.MimeType))
+		if err := blobs.Put(blobKey, img.Data); err != nil {
+			log.Printf("Failed to store attachment: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store image"})
+			return
+		}
+
+		_, err = config.DB.Exec(
+			`INSERT INTO chat_attachments (id, chat_request_id, from_scene_id, blob_key, mime_type, size_bytes, width, height)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+			attachmentID, reqUUID, userSceneID, blobKey, img.MimeType, len(img.Data), img.Width, img.Height,
+		)
+		if err != nil {
+			log.Printf("Failed to save attachment: %v", err)
+			if err := blobs.Delete(blobKey); err != nil {
+				log.Printf("Warning: Failed to delete orphaned blob %s: %v", blobKey, err)
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store image"})
+			return
+		}
+
+		attachment := models.ChatAttachment{
+			ID:        attachmentID,
+			MimeType:  img.MimeType,
+			SizeBytes: len(img.Data),
+			Width:     img.Width,
+			Height:    img.Height,
+		}
+		attachment.URL, attachment.URLExpiresAt = signAttachmentURL(attachmentID)
+
+		c.JSON(http.StatusCreated, attachment)
+	}
+}
+
+// GetChatAttachment serves an attachment through a signed URL from signAttachmentURL.
+// Query params: expires (unix seconds), sig.
+func GetChatAttachment(blobs storage.BlobStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		attachmentID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+			return
+		}
+
+		expires, err := strconv.ParseInt(c.Query("expires"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Invalid attachment link"})
+			return
+		}
+
+		expected := attachmentSignature(attachmentID.String(), expires)
+		if !hmac.Equal([]byte(expected), []byte(c.Query("sig"))) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Invalid attachment link"})
+			return
+		}
+
+		remaining := time.Until(time.Unix(expires, 0))
+		if remaining <= 0 {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Attachment link has expired", "code": "LINK_EXPIRED"})
+			return
+		}
+
+		// Only serve attachments of chats that are still running, or that both sides
+		// agreed to export while the export grace period lasts, so exported transcripts
+		// can load their images
+		var blobKey, mimeType string
+		var sizeBytes int64
+		err = config.DB.QueryRow(
+			`SELECT a.blob_key, a.mime_type, a.size_bytes
+			 FROM chat_attachments a
+			 JOIN chat_requests cr ON a.chat_request_id = cr.id
+			 WHERE a.id = $1
+			 AND ((cr.status = 'accepted' AND cr.expires_at > NOW())
+			   OR (cr.status IN ('accepted', 'expired', 'ended')
+			       AND cr.export_consent_from AND cr.export_consent_to AND cr.expires_at > $2))`,
+			attachmentID, time.Now().Add(-chatExportGrace()),
+		).Scan(&blobKey, &mimeType, &sizeBytes)
+
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to get attachment: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get attachment"})
+			return
+		}
+
+		blob, err := blobs.Open(blobKey)
+		if errors.Is(err, storage.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to open attachment blob %s: %v", blobKey, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get attachment"})
+			return
+		}
+		defer blob.Close()
+
+		c.DataFromReader(http.StatusOK, sizeBytes, mimeType, blob, map[string]string{
+			"Cache-Control":          fmt.Sprintf("private, max-age=%d", int(remaining.Seconds())),
+			"X-Content-Type-Options": "nosniff",
+		})
+	}
+}
+
+// purgeChatAttachments deletes the attachments matching cond (a condition on
+// chat_attachments columns) together with their blobs. Call it before deleting or
+// expiring the chats they belong to, since the rows would otherwise cascade away and
+// leave their blobs behind. A row is only deleted once its blob is gone, so a failed
+// blob delete is retried by the next purge.
+func purgeChatAttachments(blobs storage.BlobStore, cond string, args ...interface{}) {
+	rows, err := config.DB.Query(`SELECT id, blob_key FROM chat_attachments WHERE `+cond, args...)
+	if err != nil {
+		log.Printf("Failed to query chat attachments: %v", err)
+		return
+	}
+
+	type attachment struct {
+		id      uuid.UUID
+		blobKey string
+	}
+	var attachments []attachment
+	for rows.Next() {
+		var a attachment
+		if err := rows.Scan(&a.id, &a.blobKey); err != nil {
+			log.Printf("Failed to scan chat attachment: %v", err)
+			continue
+		}
+		attachments = append(attachments, a)
+	}
+	rows.Close()
+
+	deleted := 0
+	for _, a := range attachments {
+		if err := blobs.Delete(a.blobKey); err != nil {
+			log.Printf("Warning: Failed to delete blob %s: %v", a.blobKey, err)
+			continue
+		}
+		if _, err := config.DB.Exec(`DELETE FROM chat_attachments WHERE id = $1`, a.id); err != nil {
+			log.Printf("Failed to delete chat attachment %s: %v", a.id, err)
+			continue
+		}
+		deleted++
+	}
+
+	if deleted > 0 {
+		log.Printf("🗑️  Deleted %d chat attachment(s)", deleted)
+	}
+}
@@ -3,17 +3,30 @@ package handlers
 import (
 	"log"
 	"scene-on/backend/config"
+	"scene-on/backend/geo"
+	"scene-on/backend/middleware"
+	"scene-on/backend/storage"
 	"scene-on/backend/websocket"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+const (
+	// defaultExpiryInterval is how often pending requests and chats past their end are
+	// expired (CHAT_EXPIRY_INTERVAL). Both sides wait for that, so it runs often and cheaply.
+	defaultExpiryInterval = 30 * time.Second
+	// defaultCleanupInterval is how often the scheduler sweeps the rest of the expired
+	// data and samples the recaps' nearby counts (CLEANUP_INTERVAL)
+	defaultCleanupInterval = 5 * time.Minute
+)
+
 // RunBootCleanup performs one-time cleanup of expired data during server boot
-func RunBootCleanup(wsHub *websocket.Hub) {
+func RunBootCleanup(wsHub *websocket.Hub, blobs storage.BlobStore) {
 	log.Println("🧹 Running boot cleanup...")
 	
-	// Mark all active scenes as inactive on startup
-	res, err := config.DB.Exec(`UPDATE scenes SET is_active = false WHERE is_active = true`)
+	// Mark all active scenes as inactive on startup, dropping their E2E public keys
+	res, err := config.DB.Exec(`UPDATE scenes SET is_active = false, public_key = NULL WHERE is_active = true`)
 	if err != nil {
 		log.Printf("❌ Failed to deactivate scenes: %v", err)
 	} else {
@@ -22,94 +35,237 @@ func RunBootCleanup(wsHub *websocket.Hub) {
 			log.Printf("✓ Marked %d scenes as inactive", count)
 		}
 	}
+
+	// Likes only live as long as both scenes
+	if _, err := config.DB.Exec(`DELETE FROM scene_likes`); err != nil {
+		log.Printf("❌ Failed to clear scene likes: %v", err)
+	}
 	
 	// Clean up expired data
-	cleanupExpiredChats(wsHub)
+	expirePendingChatRequests(wsHub)
+	expireChats(wsHub)
+	cleanupExpiredData(wsHub, blobs)
 	
 	log.Println("✅ Boot cleanup completed")
 }
 
-func cleanupExpiredChats(wsHub *websocket.Hub) {
-	// Find all accepted chats that have expired
-	rows, err := config.DB.Query(
-		`SELECT id, from_scene_id, to_scene_id 
-		 FROM chat_requests 
-		 WHERE status = 'accepted' 
-		 AND expires_at < NOW()`,
-	)
+// StartCleanupScheduler runs two loops while the server runs: a fast one that expires
+// pending requests and chats (CHAT_EXPIRY_INTERVAL), and a slower sweep of everything
+// else that expired plus the recaps' nearby sampling (CLEANUP_INTERVAL)
+func StartCleanupScheduler(wsHub *websocket.Hub, blobs storage.BlobStore, geoIndex geo.GeoIndex) {
+	expiryInterval := config.GetDuration("CHAT_EXPIRY_INTERVAL", defaultExpiryInterval)
+	cleanupInterval := config.GetDuration("CLEANUP_INTERVAL", defaultCleanupInterval)
+	log.Printf("⏱️  Expiring chats every %s, cleaning up every %s", expiryInterval, cleanupInterval)
 
+	go func() {
+		ticker := time.NewTicker(expiryInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			expirePendingChatRequests(wsHub)
+			expireChats(wsHub)
+		}
+	}()
+
+	go func() {
+		ticker := time.NewTicker(cleanupInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			cleanupExpiredData(wsHub, blobs)
+			sampleActiveScenesNearby(geoIndex)
+		}
+	}()
+}
+
+// expirePendingChatRequests moves pending requests past their TTL to 'expired'
+// and tells both sides
+func expirePendingChatRequests(wsHub *websocket.Hub) {
+	expired, err := transitionChatRequests(chatStatusPending, chatStatusExpired, `expires_at < NOW()`, "")
 	if err != nil {
-		log.Printf("Failed to query expired chats: %v", err)
+		log.Printf("Failed to expire pending chat requests: %v", err)
 		return
 	}
-	defer rows.Close()
 
-	expiredCount := 0
-	for rows.Next() {
-		var id, fromSceneID, toSceneID uuid.UUID
-		if err := rows.Scan(&id, &fromSceneID, &toSceneID); err != nil {
-			log.Printf("Failed to scan expired chat: %v", err)
-			continue
-		}
+	for _, ref := range expired {
+		notifyChatRequestExpired(wsHub, ref)
+	}
 
-		// Delete all messages (CASCADE will handle this, but we'll do it explicitly for logging)
-		result, err := config.DB.Exec(
-			`DELETE FROM chat_messages WHERE chat_request_id = $1`,
-			id,
-		)
-		if err != nil {
-			log.Printf("Failed to delete chat messages for request %s: %v", id, err)
-		} else {
-			if count, _ := result.RowsAffected(); count > 0 {
-				log.Printf("🗑️  Deleted %d messages from expired chat %s", count, id)
-			}
-		}
+	if len(expired) > 0 {
+		log.Printf("⌛ Expired %d pending chat request(s)", len(expired))
+	}
+}
 
-		// Update request status to expired
-		_, err = config.DB.Exec(
-			`UPDATE chat_requests SET status = 'expired' WHERE id = $1`,
-			id,
-		)
-		if err != nil {
-			log.Printf("Failed to mark chat as expired %s: %v", id, err)
-			continue
-		}
+// notifyChatRequestExpired tells both sides a pending request expired
+func notifyChatRequestExpired(wsHub *websocket.Hub, ref chatRequestRef) {
+	expiredMsg := websocket.Message{
+		Type: "chat.request.expired",
+		Data: map[string]interface{}{
+			"request_id":    ref.ID.String(),
+			"from_scene_id": ref.FromSceneID.String(),
+			"to_scene_id":   ref.ToSceneID.String(),
+		},
+	}
+	wsHub.Targeted <- websocket.TargetedMessage{TargetSceneID: ref.FromSceneID, Message: expiredMsg}
+	wsHub.Targeted <- websocket.TargetedMessage{TargetSceneID: ref.ToSceneID, Message: expiredMsg}
+}
+
+// chatRefScenes lists both scenes of every chat request in refs
+func chatRefScenes(refs []chatRequestRef) []uuid.UUID {
+	sceneIDs := make([]uuid.UUID, 0, 2*len(refs))
+	for _, ref := range refs {
+		sceneIDs = append(sceneIDs, ref.FromSceneID, ref.ToSceneID)
+	}
+	return sceneIDs
+}
+
+// closeSceneChats expires the pending requests and ends the accepted chats of a scene
+// that stopped, telling both sides. It returns the scenes whose chat slot was freed.
+func closeSceneChats(wsHub *websocket.Hub, sceneID uuid.UUID) []uuid.UUID {
+	const ofScene = `from_scene_id = $3 OR to_scene_id = $3`
+
+	expired, err := transitionChatRequests(chatStatusPending, chatStatusExpired, ofScene, "", sceneID)
+	if err != nil {
+		log.Printf("Warning: Failed to expire chat requests of scene %s: %v", sceneID, err)
+	}
+	for _, ref := range expired {
+		notifyChatRequestExpired(wsHub, ref)
+	}
+
+	// expires_at marks when the chat ended, which starts the export grace period
+	ended, err := transitionChatRequests(chatStatusAccepted, chatStatusEnded, ofScene, `expires_at = NOW()`, sceneID)
+	if err != nil {
+		log.Printf("Warning: Failed to end chats of scene %s: %v", sceneID, err)
+	}
+	for _, ref := range ended {
+		notifyChatEnded(wsHub, ref, sceneID)
+	}
+	return chatRefScenes(ended)
+}
 
+// expireChats moves accepted chats past their end to 'expired' and tells both sides
+func expireChats(wsHub *websocket.Hub) {
+	expired, err := transitionChatRequests(chatStatusAccepted, chatStatusExpired, `expires_at < NOW()`, "")
+	if err != nil {
+		log.Printf("Failed to expire chats: %v", err)
+	}
+
+	for _, ref := range expired {
 		// Send WebSocket notification to both parties
 		wsHub.Broadcast <- websocket.BroadcastMessage{
 			Message: websocket.Message{
 				Type: "chat.expired",
 				Data: map[string]interface{}{
-					"request_id":   id.String(),
-					"from_scene_id": fromSceneID.String(),
-					"to_scene_id":   toSceneID.String(),
+					"request_id":    ref.ID.String(),
+					"from_scene_id": ref.FromSceneID.String(),
+					"to_scene_id":   ref.ToSceneID.String(),
 				},
 			},
 		}
-
-		expiredCount++
 	}
 
-	if expiredCount > 0 {
-		log.Printf("✅ Cleaned up %d expired chat(s)", expiredCount)
+	if len(expired) > 0 {
+		log.Printf("✅ Cleaned up %d expired chat(s)", len(expired))
 	}
 
+	// Matches that waited for a slot can go ahead now
+	completeWaitingMatches(wsHub, chatRefScenes(expired)...)
+}
+
+// cleanupExpiredData deletes what finished chats, scenes and rooms leave behind
+func cleanupExpiredData(wsHub *websocket.Hub, blobs storage.BlobStore) {
+	// Delete the messages of chats that expired or were ended
+	purgeFinishedChats(blobs)
+
+	// End group rooms before their creators' scenes are deleted out from under them
+	cleanupExpiredGroupRooms(wsHub)
+
 	// Clean up expired scenes
-	cleanupExpiredScenes(wsHub)
+	cleanupExpiredScenes(wsHub, blobs)
 
 	// Clean up old chat requests
-	cleanupOldChatRequests()
+	cleanupOldChatRequests(blobs)
 
 	// Clean up old user location history (keep last 100 per user)
 	cleanupOldUserLocations()
+
+	// Forget stored responses of idempotent requests past their TTL
+	cleanupExpiredIdempotencyKeys()
+
+	// Drop anti-spam bookkeeping that no longer limits anyone
+	cleanupChatRequestLimits()
 }
 
-func cleanupExpiredScenes(wsHub *websocket.Hub) {
-	// Delete expired scenes
+// deleteChatMessages removes a chat's messages and attachments
+func deleteChatMessages(blobs storage.BlobStore, requestID uuid.UUID) {
+	// Attachments go first so their blobs are removed too
+	purgeChatAttachments(blobs, `chat_request_id = $1`, requestID)
+
+	// Delete all messages (CASCADE will handle this, but we'll do it explicitly for logging)
+	result, err := config.DB.Exec(
+		`DELETE FROM chat_messages WHERE chat_request_id = $1`,
+		requestID,
+	)
+	if err != nil {
+		log.Printf("Failed to delete chat messages for request %s: %v", requestID, err)
+	} else {
+		if count, _ := result.RowsAffected(); count > 0 {
+			log.Printf("🗑️  Deleted %d messages from finished chat %s", count, requestID)
+		}
+	}
+}
+
+// purgeFinishedChats deletes the messages and attachments of expired and ended chats.
+// Chats both sides agreed to export keep theirs until the export grace period has passed.
+func purgeFinishedChats(blobs storage.BlobStore) {
+	rows, err := config.DB.Query(
+		`SELECT cr.id FROM chat_requests cr
+		 WHERE cr.status IN ('expired', 'ended')
+		 AND (NOT (cr.export_consent_from AND cr.export_consent_to) OR cr.expires_at < $1)
+		 AND (EXISTS (SELECT 1 FROM chat_messages cm WHERE cm.chat_request_id = cr.id)
+		   OR EXISTS (SELECT 1 FROM chat_attachments ca WHERE ca.chat_request_id = cr.id))`,
+		time.Now().Add(-chatExportGrace()),
+	)
+	if err != nil {
+		log.Printf("Failed to query finished chats: %v", err)
+		return
+	}
+
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		deleteChatMessages(blobs, id)
+	}
+}
+
+func cleanupExpiredScenes(wsHub *websocket.Hub, blobs storage.BlobStore) {
+	// Their chats cascade away with the scenes, so remove attachment blobs first
+	purgeChatAttachments(blobs,
+		`chat_request_id IN (
+			SELECT cr.id FROM chat_requests cr
+			JOIN scenes s ON s.id = cr.from_scene_id OR s.id = cr.to_scene_id
+			WHERE s.is_active = true AND s.expires_at < NOW()
+		 )`,
+	)
+
+	// Delete expired scenes; ones whose attachment blobs couldn't be deleted wait for
+	// the next sweep rather than cascading the rows away
 	result, err := config.DB.Exec(
 		`DELETE FROM scenes 
 		 WHERE is_active = true 
-		 AND expires_at < NOW()`,
+		 AND expires_at < NOW()
+		 AND NOT EXISTS (
+			SELECT 1 FROM chat_attachments ca
+			JOIN chat_requests cr ON ca.chat_request_id = cr.id
+			WHERE cr.from_scene_id = scenes.id OR cr.to_scene_id = scenes.id
+		 )`,
 	)
 
 	if err != nil {
@@ -122,14 +278,21 @@ func cleanupExpiredScenes(wsHub *websocket.Hub) {
 	}
 }
 
-func cleanupOldChatRequests() {
-	// Delete chat requests that are expired, rejected, or old pending ones
-	// Keep accepted ones as they may still be referenced
+func cleanupOldChatRequests(blobs storage.BlobStore) {
+	// Delete chat requests an hour after they reached a final status. Pending and accepted
+	// ones are never deleted here; expirePendingChatRequests and expireChats move
+	// them to 'expired' first, so both sides are told.
+	const old = `(status IN ('expired', 'ended') AND expires_at < NOW() - INTERVAL '1 hour')
+		 OR (status IN ('rejected', 'canceled') AND created_at < NOW() - INTERVAL '1 hour')`
+
+	// Attachment rows would cascade away with the requests, so remove their blobs first
+	// and keep any request whose blobs couldn't be deleted
+	purgeChatAttachments(blobs, `chat_request_id IN (SELECT id FROM chat_requests WHERE `+old+`)`)
+
 	result, err := config.DB.Exec(
 		`DELETE FROM chat_requests 
-		 WHERE (status = 'expired' AND expires_at < NOW() - INTERVAL '1 hour')
-		 OR (status = 'rejected' AND created_at < NOW() - INTERVAL '1 hour')
-		 OR (status = 'pending' AND expires_at < NOW())`,
+		 WHERE (` + old + `)
+		 AND NOT EXISTS (SELECT 1 FROM chat_attachments ca WHERE ca.chat_request_id = chat_requests.id)`,
 	)
 
 	if err != nil {
@@ -165,3 +328,32 @@ func cleanupOldUserLocations() {
 		log.Printf("🗑️  Deleted %d old user location(s)", count)
 	}
 }
+
+func cleanupChatRequestLimits() {
+	_, err := config.DB.Exec(`DELETE FROM chat_request_sends WHERE created_at < NOW() - INTERVAL '1 hour'`)
+	if err != nil {
+		log.Printf("Failed to cleanup chat request sends: %v", err)
+	}
+
+	_, err = config.DB.Exec(`DELETE FROM chat_request_cooldowns WHERE until < NOW()`)
+	if err != nil {
+		log.Printf("Failed to cleanup chat request cooldowns: %v", err)
+	}
+}
+
+func cleanupExpiredIdempotencyKeys() {
+	ttl := config.GetDuration("IDEMPOTENCY_KEY_TTL", middleware.DefaultIdempotencyKeyTTL)
+	result, err := config.DB.Exec(
+		`DELETE FROM idempotency_keys WHERE created_at < $1`,
+		time.Now().Add(-ttl),
+	)
+
+	if err != nil {
+		log.Printf("Failed to cleanup idempotency keys: %v", err)
+		return
+	}
+
+	if count, _ := result.RowsAffected(); count > 0 {
+		log.Printf("🗑️  Deleted %d expired idempotency key(s)", count)
+	}
+}
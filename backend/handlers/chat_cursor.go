@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// defaultMessagePageSize is used when the client does not pass ?limit=
+	defaultMessagePageSize = 50
+	// maxMessagePageSize caps ?limit= for GetChatMessages
+	maxMessagePageSize = 200
+)
+
+// messageCursor identifies a position in a chat's message history. Messages are ordered
+// by (created_at, id) so cursors stay stable when two messages share a timestamp.
+type messageCursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+// String encodes the cursor as "<unix micros>_<message id>"
+func (mc messageCursor) String() string {
+	return fmt.Sprintf("%d_%s", mc.CreatedAt.UnixMicro(), mc.ID)
+}
+
+func parseMessageCursor(value string) (messageCursor, error) {
+	micros, id, ok := strings.Cut(value, "_")
+	if !ok {
+		return messageCursor{}, fmt.Errorf("invalid cursor")
+	}
+
+	ts, err := strconv.ParseInt(micros, 10, 64)
+	if err != nil {
+		return messageCursor{}, fmt.Errorf("invalid cursor")
+	}
+
+	msgID, err := uuid.Parse(id)
+	if err != nil {
+		return messageCursor{}, fmt.Errorf("invalid cursor")
+	}
+
+	return messageCursor{CreatedAt: time.UnixMicro(ts).UTC(), ID: msgID}, nil
+}
@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"scene-on/backend/config"
+	"scene-on/backend/middleware"
+	"scene-on/backend/models"
+	"scene-on/backend/moderation"
+	"scene-on/backend/storage"
+	"scene-on/backend/websocket"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// defaultMessageEditWindow is how long after sending a message may be edited or unsent
+// (CHAT_MESSAGE_EDIT_WINDOW)
+const defaultMessageEditWindow = 2 * time.Minute
+
+// EditChatMessageReq replaces a message's content, or its ciphertext in end-to-end
+// encrypted chats
+type EditChatMessageReq struct {
+	Content     string `json:"content"`
+	Ciphertext  string `json:"ciphertext,omitempty"`
+	CipherNonce string `json:"cipher_nonce,omitempty"`
+}
+
+// loadEditableMessage loads one of the user's own messages and checks it can still be
+// changed. It returns the message and the scene on the other side of the chat.
+func loadEditableMessage(userID, messageID uuid.UUID) (*models.ChatMessage, uuid.UUID, *apiError) {
+	// Get user's active scene
+	var userSceneID uuid.UUID
+	err := config.DB.QueryRow(
+		`SELECT s.id FROM scenes s
+		 JOIN personas p ON s.persona_id = p.id
+		 WHERE p.user_id = $1 AND s.is_active = true AND s.expires_at > NOW()
+		 ORDER BY s.started_at DESC LIMIT 1`,
+		userID,
+	).Scan(&userSceneID)
+
+	if err == sql.ErrNoRows {
+		return nil, uuid.Nil, &apiError{Status: http.StatusBadRequest, Message: "No active scene found"}
+	}
+	if err != nil {
+		log.Printf("Failed to get active scene: %v", err)
+		return nil, uuid.Nil, &apiError{Status: http.StatusInternalServerError, Message: "Failed to get active scene"}
+	}
+
+	var msg models.ChatMessage
+	var fromSceneID, toSceneID uuid.UUID
+	var status string
+	var expiresAt *time.Time
+	err = config.DB.QueryRow(
+		`SELECT cm.id, cm.chat_request_id, cm.from_scene_id, cm.content,
+		        COALESCE(cm.ciphertext, ''), COALESCE(cm.cipher_nonce, ''), cm.edited, cm.edited_at,
+		        cm.delivered_at, cm.read_at, cm.attachment_id, cm.created_at,
+		        cr.from_scene_id, cr.to_scene_id, cr.status, cr.expires_at
+		 FROM chat_messages cm
+		 JOIN chat_requests cr ON cm.chat_request_id = cr.id
+		 WHERE cm.id = $1`,
+		messageID,
+	).Scan(&msg.ID, &msg.ChatRequestID, &msg.FromSceneID, &msg.Content, &msg.Ciphertext, &msg.CipherNonce,
+		&msg.Edited, &msg.EditedAt, &msg.DeliveredAt, &msg.ReadAt, &msg.AttachmentID, &msg.CreatedAt,
+		&fromSceneID, &toSceneID, &status, &expiresAt)
+
+	if err == sql.ErrNoRows {
+		return nil, uuid.Nil, &apiError{Status: http.StatusNotFound, Message: "Message not found"}
+	}
+	if err != nil {
+		log.Printf("Failed to get chat message: %v", err)
+		return nil, uuid.Nil, &apiError{Status: http.StatusInternalServerError, Message: "Failed to get message"}
+	}
+	msg.Status = messageStatus(msg.DeliveredAt, msg.ReadAt)
+
+	if msg.FromSceneID != userSceneID {
+		return nil, uuid.Nil, &apiError{Status: http.StatusForbidden, Message: "You can only change your own messages"}
+	}
+
+	if status != "accepted" || (expiresAt != nil && time.Now().After(*expiresAt)) {
+		return nil, uuid.Nil, &apiError{Status: http.StatusBadRequest, Message: "Chat is no longer active"}
+	}
+
+	window := config.GetDuration("CHAT_MESSAGE_EDIT_WINDOW", defaultMessageEditWindow)
+	if time.Since(msg.CreatedAt) > window {
+		return nil, uuid.Nil, &apiError{
+			Status:  http.StatusForbidden,
+			Code:    "EDIT_WINDOW_PASSED",
+			Message: "Messages can only be changed shortly after sending",
+		}
+	}
+
+	otherSceneID := toSceneID
+	if userSceneID == toSceneID {
+		otherSceneID = fromSceneID
+	}
+
+	return &msg, otherSceneID, nil
+}
+
+// EditChatMessage lets the sender fix a message shortly after sending it
+func EditChatMessage(wsHub *websocket.Hub, mod moderation.Moderator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := middleware.GetUserID(c)
+
+		messageID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message id"})
+			return
+		}
+
+		var req EditChatMessageReq
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		msg, otherSceneID, apiErr := loadEditableMessage(userID, messageID)
+		if apiErr != nil {
+			apiErr.respond(c)
+			return
+		}
+
+		// Messages of encrypted chats always carry ciphertext, and edits must too
+		content := req.Content
+		if msg.Ciphertext != "" {
+			if req.Content != "" {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": "This chat is end-to-end encrypted; send ciphertext only",
+					"code":  "E2E_REQUIRED",
+				})
+				return
+			}
+			if err := validateCiphertext(req.Ciphertext, req.CipherNonce); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": "E2E_REQUIRED"})
+				return
+			}
+		} else {
+			if req.Ciphertext != "" || req.CipherNonce != "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "This chat is not end-to-end encrypted", "code": "E2E_NOT_ENABLED"})
+				return
+			}
+			if req.Content == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "content is required"})
+				return
+			}
+			var ok bool
+			content, ok = moderateText(c, mod, moderation.ContextChatMessage, userID, req.Content)
+			if !ok {
+				return
+			}
+		}
+
+		now := time.Now().UTC()
+		_, err = config.DB.Exec(
+			`UPDATE chat_messages
+			 SET content = $1, ciphertext = NULLIF($2, ''), cipher_nonce = NULLIF($3, ''), edited = true, edited_at = $4
+			 WHERE id = $5`,
+			content, req.Ciphertext, req.CipherNonce, now, msg.ID,
+		)
+		if err != nil {
+			log.Printf("Failed to edit chat message: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to edit message"})
+			return
+		}
+
+		msg.Content = content
+		msg.Ciphertext = req.Ciphertext
+		msg.CipherNonce = req.CipherNonce
+		msg.Edited = true
+		msg.EditedAt = &now
+
+		wsHub.Targeted <- websocket.TargetedMessage{
+			TargetSceneID: otherSceneID,
+			Message: websocket.Message{
+				Type: "chat.message.edited",
+				Data: map[string]interface{}{
+					"message_id":   msg.ID.String(),
+					"request_id":   msg.ChatRequestID.String(),
+					"content":      msg.Content,
+					"ciphertext":   msg.Ciphertext,
+					"cipher_nonce": msg.CipherNonce,
+					"edited_at":    now.Format(time.RFC3339),
+				},
+			},
+		}
+
+		c.JSON(http.StatusOK, msg)
+	}
+}
+
+// DeleteChatMessage unsends one of the user's own messages shortly after sending it,
+// along with its attachment
+func DeleteChatMessage(wsHub *websocket.Hub, blobs storage.BlobStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := middleware.GetUserID(c)
+
+		messageID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message id"})
+			return
+		}
+
+		msg, otherSceneID, apiErr := loadEditableMessage(userID, messageID)
+		if apiErr != nil {
+			apiErr.respond(c)
+			return
+		}
+
+		// Unsending takes the image with it, so links already handed out stop working
+		if msg.AttachmentID != nil {
+			purgeChatAttachments(blobs, "id = $1", *msg.AttachmentID)
+		}
+
+		_, err = config.DB.Exec(`DELETE FROM chat_messages WHERE id = $1`, msg.ID)
+		if err != nil {
+			log.Printf("Failed to delete chat message: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete message"})
+			return
+		}
+
+		wsHub.Targeted <- websocket.TargetedMessage{
+			TargetSceneID: otherSceneID,
+			Message: websocket.Message{
+				Type: "chat.message.deleted",
+				Data: map[string]interface{}{
+					"message_id": msg.ID.String(),
+					"request_id": msg.ChatRequestID.String(),
+				},
+			},
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Message deleted"})
+	}
+}
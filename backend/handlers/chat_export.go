@@ -0,0 +1,295 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"scene-on/backend/config"
+	"scene-on/backend/middleware"
+	"scene-on/backend/models"
+	"scene-on/backend/websocket"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// defaultChatExportGrace is how long after a chat ends it can still be exported, if
+	// both sides consented (CHAT_EXPORT_GRACE)
+	defaultChatExportGrace = 10 * time.Minute
+	// maxChatExportGrace keeps the grace period well inside the hour after which
+	// cleanupOldChatRequests deletes expired chats
+	maxChatExportGrace = 30 * time.Minute
+)
+
+// chatExportGrace returns the configured export grace period, capped at maxChatExportGrace
+func chatExportGrace() time.Duration {
+	grace := config.GetDuration("CHAT_EXPORT_GRACE", defaultChatExportGrace)
+	if grace > maxChatExportGrace {
+		grace = maxChatExportGrace
+	}
+	return grace
+}
+
+// chatExportState is the part of a chat request the export flow works with
+type chatExportState struct {
+	FromSceneID   uuid.UUID
+	ToSceneID     uuid.UUID
+	Status        string
+	ExpiresAt     *time.Time
+	ConsentFrom   bool
+	ConsentTo     bool
+	FromPersona   string
+	ToPersona     string
+	OtherSceneID  uuid.UUID
+	UserConsented bool
+}
+
+// loadChatForExport loads a chat and checks the user owns one of its scenes. The scene
+// doesn't have to be active: the transcript stays exportable for CHAT_EXPORT_GRACE after
+// the chat ended, and stopping the scene ends its chats. It returns the user's scene.
+func loadChatForExport(userID, reqUUID uuid.UUID) (*chatExportState, uuid.UUID, *apiError) {
+	var st chatExportState
+	var fromUserID, toUserID uuid.UUID
+	err := config.DB.QueryRow(
+		`SELECT cr.from_scene_id, cr.to_scene_id, cr.status, cr.expires_at,
+		        cr.export_consent_from, cr.export_consent_to, fp.name, tp.name, fp.user_id, tp.user_id
+		 FROM chat_requests cr
+		 JOIN scenes fs ON cr.from_scene_id = fs.id
+		 JOIN personas fp ON fs.persona_id = fp.id
+		 JOIN scenes ts ON cr.to_scene_id = ts.id
+		 JOIN personas tp ON ts.persona_id = tp.id
+		 WHERE cr.id = $1`,
+		reqUUID,
+	).Scan(&st.FromSceneID, &st.ToSceneID, &st.Status, &st.ExpiresAt,
+		&st.ConsentFrom, &st.ConsentTo, &st.FromPersona, &st.ToPersona, &fromUserID, &toUserID)
+
+	if err == sql.ErrNoRows {
+		return nil, uuid.Nil, &apiError{Status: http.StatusNotFound, Message: "Chat not found"}
+	}
+	if err != nil {
+		log.Printf("Failed to get chat request: %v", err)
+		return nil, uuid.Nil, &apiError{Status: http.StatusInternalServerError, Message: "Failed to get chat"}
+	}
+
+	var userSceneID uuid.UUID
+	switch userID {
+	case fromUserID:
+		userSceneID = st.FromSceneID
+		st.OtherSceneID = st.ToSceneID
+		st.UserConsented = st.ConsentFrom
+	case toUserID:
+		userSceneID = st.ToSceneID
+		st.OtherSceneID = st.FromSceneID
+		st.UserConsented = st.ConsentTo
+	default:
+		return nil, uuid.Nil, &apiError{Status: http.StatusForbidden, Message: "You are not part of this chat"}
+	}
+
+	return &st, userSceneID, nil
+}
+
+// SetChatExportConsent records (POST) or withdraws (DELETE) the caller's consent to
+// exporting the transcript of an active chat
+func SetChatExportConsent(wsHub *websocket.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := middleware.GetUserID(c)
+		consent := c.Request.Method != http.MethodDelete
+
+		reqUUID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request_id"})
+			return
+		}
+
+		st, userSceneID, apiErr := loadChatForExport(userID, reqUUID)
+		if apiErr != nil {
+			apiErr.respond(c)
+			return
+		}
+
+		if st.Status != chatStatusAccepted || (st.ExpiresAt != nil && time.Now().After(*st.ExpiresAt)) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Chat is not active"})
+			return
+		}
+
+		column := "export_consent_to"
+		if userSceneID == st.FromSceneID {
+			column = "export_consent_from"
+		}
+		_, err = config.DB.Exec(
+			`UPDATE chat_requests SET `+column+` = $1 WHERE id = $2`,
+			consent, reqUUID,
+		)
+		if err != nil {
+			log.Printf("Failed to update export consent: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update export consent"})
+			return
+		}
+
+		otherConsented := st.ConsentTo
+		if userSceneID == st.ToSceneID {
+			otherConsented = st.ConsentFrom
+		}
+		exportable := consent && otherConsented
+
+		consentMsg := websocket.Message{
+			Type: "chat.export.consent",
+			Data: map[string]interface{}{
+				"request_id": reqUUID.String(),
+				"scene_id":   userSceneID.String(),
+				"consented":  consent,
+				"exportable": exportable,
+			},
+		}
+		wsHub.Targeted <- websocket.TargetedMessage{TargetSceneID: st.OtherSceneID, Message: consentMsg, FromUserID: userID}
+		wsHub.Targeted <- websocket.TargetedMessage{TargetSceneID: userSceneID, Message: consentMsg}
+
+		c.JSON(http.StatusOK, gin.H{
+			"request_id": reqUUID.String(),
+			"consented":  consent,
+			"exportable": exportable,
+		})
+	}
+}
+
+// ExportChatTranscript returns the transcript of a chat both sides consented to export,
+// while it is active or for CHAT_EXPORT_GRACE after it ended.
+// Query params: format (json, the default, or text).
+func ExportChatTranscript(wsHub *websocket.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := middleware.GetUserID(c)
+
+		reqUUID, err := uuid.Parse(c.Param("request_id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request_id"})
+			return
+		}
+
+		format := c.DefaultQuery("format", "json")
+		if format != "json" && format != "text" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json or text"})
+			return
+		}
+
+		st, userSceneID, apiErr := loadChatForExport(userID, reqUUID)
+		if apiErr != nil {
+			apiErr.respond(c)
+			return
+		}
+
+		if st.Status != chatStatusAccepted && st.Status != chatStatusExpired && st.Status != chatStatusEnded {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Chat was never active (status: " + st.Status + ")"})
+			return
+		}
+
+		if st.ExpiresAt == nil || time.Now().After(st.ExpiresAt.Add(chatExportGrace())) {
+			c.JSON(http.StatusGone, gin.H{"error": "This chat can no longer be exported", "code": "EXPORT_WINDOW_CLOSED"})
+			return
+		}
+
+		if !st.ConsentFrom || !st.ConsentTo {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "Both participants must agree before the chat can be exported",
+				"code":  "EXPORT_CONSENT_REQUIRED",
+			})
+			return
+		}
+
+		rows, err := config.DB.Query(
+			`SELECT id, chat_request_id, from_scene_id, content,
+			        COALESCE(ciphertext, ''), COALESCE(cipher_nonce, ''), edited, edited_at,
+			        delivered_at, read_at, attachment_id, created_at
+			 FROM chat_messages
+			 WHERE chat_request_id = $1
+			 ORDER BY created_at ASC, id ASC`,
+			reqUUID,
+		)
+		if err != nil {
+			log.Printf("Failed to get messages for export: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export chat"})
+			return
+		}
+
+		messages := []models.ChatMessage{}
+		for rows.Next() {
+			var msg models.ChatMessage
+			err := rows.Scan(&msg.ID, &msg.ChatRequestID, &msg.FromSceneID, &msg.Content, &msg.Ciphertext, &msg.CipherNonce,
+				&msg.Edited, &msg.EditedAt, &msg.DeliveredAt, &msg.ReadAt, &msg.AttachmentID, &msg.CreatedAt)
+			if err != nil {
+				log.Printf("Failed to scan message: %v", err)
+				continue
+			}
+			msg.Status = messageStatus(msg.DeliveredAt, msg.ReadAt)
+			messages = append(messages, msg)
+		}
+		rows.Close()
+
+		attachReactionCounts(messages)
+		attachChatAttachments(messages)
+
+		wsHub.Targeted <- websocket.TargetedMessage{
+			TargetSceneID: st.OtherSceneID,
+			Message: websocket.Message{
+				Type: "chat.exported",
+				Data: map[string]interface{}{
+					"request_id": reqUUID.String(),
+					"scene_id":   userSceneID.String(),
+					"format":     format,
+				},
+			},
+		}
+		log.Printf("📤 Scene %s exported chat %s (%s)", userSceneID, reqUUID, format)
+
+		exportedAt := time.Now().UTC()
+		if format == "text" {
+			c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="chat-%s.txt"`, reqUUID))
+			c.String(http.StatusOK, formatTranscript(st, messages, exportedAt))
+			return
+		}
+
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="chat-%s.json"`, reqUUID))
+		c.JSON(http.StatusOK, gin.H{
+			"request_id":  reqUUID.String(),
+			"exported_at": exportedAt,
+			"participants": []gin.H{
+				{"scene_id": st.FromSceneID.String(), "persona_name": st.FromPersona},
+				{"scene_id": st.ToSceneID.String(), "persona_name": st.ToPersona},
+			},
+			"messages": messages,
+		})
+	}
+}
+
+// formatTranscript renders a chat as plain text, one line per message
+func formatTranscript(st *chatExportState, messages []models.ChatMessage, exportedAt time.Time) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Chat between %s and %s\n", st.FromPersona, st.ToPersona)
+	fmt.Fprintf(&b, "Exported %s\n\n", exportedAt.Format(time.RFC3339))
+
+	for _, msg := range messages {
+		name := st.ToPersona
+		if msg.FromSceneID == st.FromSceneID {
+			name = st.FromPersona
+		}
+
+		text := msg.Content
+		if msg.Ciphertext != "" {
+			// Only the clients hold the keys; use the JSON export to decrypt locally
+			text = "[encrypted message]"
+		}
+		if msg.Attachment != nil {
+			text = strings.TrimSpace(text + " [image]")
+		}
+		if msg.Edited {
+			text += " (edited)"
+		}
+
+		fmt.Fprintf(&b, "[%s] %s: %s\n", msg.CreatedAt.UTC().Format("2006-01-02 15:04:05"), name, text)
+	}
+
+	return b.String()
+}
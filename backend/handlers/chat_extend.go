@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"scene-on/backend/config"
+	"scene-on/backend/middleware"
+	"scene-on/backend/websocket"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// defaultChatExtension is how much time one accepted extension adds (CHAT_EXTENSION_DURATION)
+	defaultChatExtension = 5 * time.Minute
+	// defaultMaxChatExtensions caps the number of extensions per chat (CHAT_MAX_EXTENSIONS)
+	defaultMaxChatExtensions = 3
+	// defaultMaxChatDuration caps the total chat length from acceptance (CHAT_MAX_DURATION)
+	defaultMaxChatDuration = 30 * time.Minute
+)
+
+// chatExtensionState is the part of a chat request the extension flow works with
+type chatExtensionState struct {
+	FromSceneID    uuid.UUID
+	ToSceneID      uuid.UUID
+	Status         string
+	AcceptedAt     *time.Time
+	ExpiresAt      *time.Time
+	ExtensionCount int
+	ProposedBy     *uuid.UUID
+}
+
+// loadChatForExtension loads a chat and checks the scene may extend it
+func loadChatForExtension(sceneID, reqUUID uuid.UUID) (*chatExtensionState, *apiError) {
+	var st chatExtensionState
+	err := config.DB.QueryRow(
+		`SELECT from_scene_id, to_scene_id, status, accepted_at, expires_at, extension_count, extension_proposed_by
+		 FROM chat_requests WHERE id = $1`,
+		reqUUID,
+	).Scan(&st.FromSceneID, &st.ToSceneID, &st.Status, &st.AcceptedAt, &st.ExpiresAt,
+		&st.ExtensionCount, &st.ProposedBy)
+
+	if err == sql.ErrNoRows {
+		return nil, &apiError{Status: http.StatusNotFound, Message: "Chat not found"}
+	}
+	if err != nil {
+		log.Printf("Failed to get chat request: %v", err)
+		return nil, &apiError{Status: http.StatusInternalServerError, Message: "Failed to get chat"}
+	}
+
+	if sceneID != st.FromSceneID && sceneID != st.ToSceneID {
+		return nil, &apiError{Status: http.StatusForbidden, Message: "You are not part of this chat"}
+	}
+
+	if st.Status != "accepted" || st.AcceptedAt == nil || st.ExpiresAt == nil {
+		return nil, &apiError{Status: http.StatusBadRequest, Message: "Chat is not active (status: " + st.Status + ")"}
+	}
+
+	if time.Now().After(*st.ExpiresAt) {
+		return nil, &apiError{Status: http.StatusBadRequest, Message: "Chat has expired"}
+	}
+
+	maxEnd := st.AcceptedAt.Add(config.GetDuration("CHAT_MAX_DURATION", defaultMaxChatDuration))
+	if st.ExtensionCount >= config.GetInt("CHAT_MAX_EXTENSIONS", defaultMaxChatExtensions) || !st.ExpiresAt.Before(maxEnd) {
+		return nil, &apiError{
+			Status:  http.StatusBadRequest,
+			Code:    "EXTENSION_LIMIT",
+			Message: "This chat cannot be extended any further",
+		}
+	}
+
+	return &st, nil
+}
+
+// proposeChatExtension records an extension proposal from sceneID. If the other side
+// already proposed one, the proposal counts as acceptance and the chat is extended.
+func proposeChatExtension(wsHub *websocket.Hub, sceneID, reqUUID uuid.UUID) (gin.H, *apiError) {
+	st, apiErr := loadChatForExtension(sceneID, reqUUID)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	if st.ProposedBy != nil {
+		if *st.ProposedBy == sceneID {
+			return nil, &apiError{Status: http.StatusConflict, Message: "Extension already proposed"}
+		}
+		return acceptChatExtension(wsHub, sceneID, reqUUID)
+	}
+
+	result, err := config.DB.Exec(
+		`UPDATE chat_requests SET extension_proposed_by = $1, extension_proposed_at = NOW()
+		 WHERE id = $2 AND status = 'accepted' AND extension_proposed_by IS NULL`,
+		sceneID, reqUUID,
+	)
+	if err != nil {
+		log.Printf("Failed to propose chat extension: %v", err)
+		return nil, &apiError{Status: http.StatusInternalServerError, Message: "Failed to propose extension"}
+	}
+	if count, _ := result.RowsAffected(); count == 0 {
+		return nil, &apiError{Status: http.StatusConflict, Message: "Extension already proposed"}
+	}
+
+	extension := config.GetDuration("CHAT_EXTENSION_DURATION", defaultChatExtension)
+	proposedMsg := websocket.Message{
+		Type: "chat.extend.proposed",
+		Data: map[string]interface{}{
+			"request_id":        reqUUID.String(),
+			"proposed_by":       sceneID.String(),
+			"extension_seconds": int(extension.Seconds()),
+		},
+	}
+	wsHub.Targeted <- websocket.TargetedMessage{TargetSceneID: st.FromSceneID, Message: proposedMsg}
+	wsHub.Targeted <- websocket.TargetedMessage{TargetSceneID: st.ToSceneID, Message: proposedMsg}
+
+	return gin.H{
+		"message":    "Extension proposed",
+		"request_id": reqUUID.String(),
+	}, nil
+}
+
+// acceptChatExtension accepts the other side's pending proposal and moves expires_at
+// forward, capped by the maximum chat length
+func acceptChatExtension(wsHub *websocket.Hub, sceneID, reqUUID uuid.UUID) (gin.H, *apiError) {
+	st, apiErr := loadChatForExtension(sceneID, reqUUID)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	if st.ProposedBy == nil || *st.ProposedBy == sceneID {
+		return nil, &apiError{Status: http.StatusBadRequest, Message: "No extension proposal to accept"}
+	}
+
+	extension := config.GetDuration("CHAT_EXTENSION_DURATION", defaultChatExtension)
+	maxEnd := st.AcceptedAt.Add(config.GetDuration("CHAT_MAX_DURATION", defaultMaxChatDuration))
+	expiresAt := st.ExpiresAt.Add(extension)
+	if expiresAt.After(maxEnd) {
+		expiresAt = maxEnd
+	}
+
+	// Guard on the proposer so two concurrent accepts only extend once
+	result, err := config.DB.Exec(
+		`UPDATE chat_requests
+		 SET expires_at = $1, extension_count = extension_count + 1,
+		     extension_proposed_by = NULL, extension_proposed_at = NULL
+		 WHERE id = $2 AND status = 'accepted' AND extension_proposed_by = $3`,
+		expiresAt, reqUUID, *st.ProposedBy,
+	)
+	if err != nil {
+		log.Printf("Failed to extend chat: %v", err)
+		return nil, &apiError{Status: http.StatusInternalServerError, Message: "Failed to extend chat"}
+	}
+	if count, _ := result.RowsAffected(); count == 0 {
+		return nil, &apiError{Status: http.StatusConflict, Message: "Extension proposal is no longer pending"}
+	}
+
+	extendedMsg := websocket.Message{
+		Type: "chat.extended",
+		Data: map[string]interface{}{
+			"request_id":      reqUUID.String(),
+			"expires_at":      expiresAt.Format(time.RFC3339),
+			"extension_count": st.ExtensionCount + 1,
+		},
+	}
+	wsHub.Targeted <- websocket.TargetedMessage{TargetSceneID: st.FromSceneID, Message: extendedMsg}
+	wsHub.Targeted <- websocket.TargetedMessage{TargetSceneID: st.ToSceneID, Message: extendedMsg}
+
+	return gin.H{
+		"message":         "Chat extended",
+		"request_id":      reqUUID.String(),
+		"expires_at":      expiresAt,
+		"extension_count": st.ExtensionCount + 1,
+	}, nil
+}
+
+// ProposeChatExtension lets one side of an active chat ask for more time
+func ProposeChatExtension(wsHub *websocket.Hub) gin.HandlerFunc {
+	return chatExtensionHandler(wsHub, proposeChatExtension)
+}
+
+// AcceptChatExtension accepts the other side's extension proposal
+func AcceptChatExtension(wsHub *websocket.Hub) gin.HandlerFunc {
+	return chatExtensionHandler(wsHub, acceptChatExtension)
+}
+
+func chatExtensionHandler(
+	wsHub *websocket.Hub,
+	action func(*websocket.Hub, uuid.UUID, uuid.UUID) (gin.H, *apiError),
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := middleware.GetUserID(c)
+
+		reqUUID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request_id"})
+			return
+		}
+
+		// Get user's active scene
+		var userSceneID uuid.UUID
+		err = config.DB.QueryRow(
+			`SELECT s.id FROM scenes s
+			 JOIN personas p ON s.persona_id = p.id
+			 WHERE p.user_id = $1 AND s.is_active = true AND s.expires_at > NOW()
+			 ORDER BY s.started_at DESC LIMIT 1`,
+			userID,
+		).Scan(&userSceneID)
+
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No active scene found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to get active scene: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get active scene"})
+			return
+		}
+
+		resp, apiErr := action(wsHub, userSceneID, reqUUID)
+		if apiErr != nil {
+			apiErr.respond(c)
+			return
+		}
+
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+// handleChatExtendAccept is the WebSocket form of AcceptChatExtension:
+// {"type": "chat.extend.accept", "data": {"request_id": "..."}}
+func handleChatExtendAccept(wsHub *websocket.Hub) websocket.CommandHandler {
+	return func(client *websocket.Client, data map[string]interface{}) {
+		requestID, _ := data["request_id"].(string)
+		reqUUID, err := uuid.Parse(requestID)
+		if err != nil {
+			client.Send <- websocket.Message{Type: "error", Data: (&apiError{
+				Status:  http.StatusBadRequest,
+				Message: "Invalid request_id",
+			}).wsData("chat.extend.accept")}
+			return
+		}
+
+		if _, apiErr := acceptChatExtension(wsHub, client.SceneID, reqUUID); apiErr != nil {
+			client.Send <- websocket.Message{Type: "error", Data: apiErr.wsData("chat.extend.accept")}
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"math"
+	"net/http"
+	"scene-on/backend/config"
+	"scene-on/backend/models"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Anti-spam limits on chat requests. A value of 0 turns a limit off.
+const (
+	// defaultMaxPendingRequests caps the unanswered requests a scene has out at once
+	// (CHAT_MAX_PENDING_REQUESTS)
+	defaultMaxPendingRequests = 5
+	// defaultRequestsPerHour is how many requests a user may send in any hour
+	// (CHAT_REQUESTS_PER_HOUR)
+	defaultRequestsPerHour = 20
+	// defaultRequestCooldown is how long a user must wait before requesting someone again
+	// after being rejected or canceling (CHAT_REQUEST_COOLDOWN)
+	defaultRequestCooldown = 30 * time.Minute
+)
+
+// requestLimitHit describes which limit a chat request ran into
+type requestLimitHit struct {
+	Code       string
+	Message    string
+	RetryAfter time.Duration
+}
+
+// respond writes a 429 with a Retry-After header (in seconds)
+func (h *requestLimitHit) respond(c *gin.Context) {
+	seconds := int(math.Ceil(h.RetryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":       h.Message,
+		"code":        h.Code,
+		"retry_after": seconds,
+	})
+}
+
+// insertChatRequest stores a new pending request from the scene of fromUserID, unless it
+// would break an anti-spam limit; then the limit is returned and nothing is stored. The
+// sender's scene is locked while the limits are checked and the send is recorded, so
+// concurrent requests can't all slip under the same limit.
+func insertChatRequest(req *models.ChatRequest, fromUserID, toUserID uuid.UUID) (*requestLimitHit, error) {
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT 1 FROM scenes WHERE id = $1 FOR UPDATE`, req.FromSceneID); err != nil {
+		return nil, err
+	}
+
+	hit, err := checkChatRequestLimits(tx, req.FromSceneID, fromUserID, toUserID)
+	if err != nil || hit != nil {
+		return hit, err
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO chat_requests (id, from_scene_id, to_scene_id, message, status, expires_at, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		req.ID, req.FromSceneID, req.ToSceneID, req.Message, req.Status, req.ExpiresAt, req.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// Counts against the user's hourly budget
+	if _, err := tx.Exec(`INSERT INTO chat_request_sends (user_id) VALUES ($1)`, fromUserID); err != nil {
+		return nil, err
+	}
+	countSceneActivity(tx, recapRequestsSent, req.FromSceneID)
+
+	return nil, tx.Commit()
+}
+
+// checkChatRequestLimits reports the first anti-spam limit a new request from
+// fromSceneID (owned by fromUserID) to toUserID would break, or nil
+func checkChatRequestLimits(q chatQuerier, fromSceneID, fromUserID, toUserID uuid.UUID) (*requestLimitHit, error) {
+	now := time.Now()
+
+	if maxPending := config.GetInt("CHAT_MAX_PENDING_REQUESTS", defaultMaxPendingRequests); maxPending > 0 {
+		var pending int
+		var oldestExpiry sql.NullTime
+		err := q.QueryRow(
+			`SELECT COUNT(*), MIN(expires_at) FROM chat_requests
+			 WHERE from_scene_id = $1 AND status = 'pending' AND expires_at > NOW()`,
+			fromSceneID,
+		).Scan(&pending, &oldestExpiry)
+		if err != nil {
+			return nil, err
+		}
+		if pending >= maxPending {
+			return &requestLimitHit{
+				Code:       "PENDING_LIMIT_REACHED",
+				Message:    "You have too many unanswered chat requests. Wait for some to be answered.",
+				RetryAfter: oldestExpiry.Time.Sub(now),
+			}, nil
+		}
+	}
+
+	var cooldownUntil time.Time
+	err := q.QueryRow(
+		`SELECT until FROM chat_request_cooldowns
+		 WHERE from_user_id = $1 AND to_user_id = $2 AND until > NOW()`,
+		fromUserID, toUserID,
+	).Scan(&cooldownUntil)
+	if err == nil {
+		return &requestLimitHit{
+			Code:       "REQUEST_COOLDOWN",
+			Message:    "You can't send this person another request yet",
+			RetryAfter: cooldownUntil.Sub(now),
+		}, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	if perHour := config.GetInt("CHAT_REQUESTS_PER_HOUR", defaultRequestsPerHour); perHour > 0 {
+		var sent int
+		var oldestSend sql.NullTime
+		err := q.QueryRow(
+			`SELECT COUNT(*), MIN(created_at) FROM chat_request_sends
+			 WHERE user_id = $1 AND created_at > $2`,
+			fromUserID, now.Add(-time.Hour),
+		).Scan(&sent, &oldestSend)
+		if err != nil {
+			return nil, err
+		}
+		if sent >= perHour {
+			return &requestLimitHit{
+				Code:       "HOURLY_LIMIT_REACHED",
+				Message:    "You've sent too many chat requests. Try again later.",
+				RetryAfter: oldestSend.Time.Add(time.Hour).Sub(now),
+			}, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// startRequestCooldown stops the sender of a rejected or canceled request from
+// requesting the same person again for CHAT_REQUEST_COOLDOWN
+func startRequestCooldown(fromSceneID, toSceneID uuid.UUID) {
+	cooldown := config.GetDuration("CHAT_REQUEST_COOLDOWN", defaultRequestCooldown)
+	if cooldown <= 0 {
+		return
+	}
+
+	_, err := config.DB.Exec(
+		`INSERT INTO chat_request_cooldowns (from_user_id, to_user_id, until)
+		 SELECT fp.user_id, tp.user_id, $3
+		 FROM scenes fs JOIN personas fp ON fs.persona_id = fp.id,
+		      scenes ts JOIN personas tp ON ts.persona_id = tp.id
+		 WHERE fs.id = $1 AND ts.id = $2
+		 ON CONFLICT (from_user_id, to_user_id) DO UPDATE SET until = EXCLUDED.until`,
+		fromSceneID, toSceneID, time.Now().Add(cooldown),
+	)
+	if err != nil {
+		log.Printf("Failed to start chat request cooldown: %v", err)
+	}
+}
@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"scene-on/backend/config"
+	"scene-on/backend/middleware"
+	"scene-on/backend/models"
+	"scene-on/backend/websocket"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// allowedReactions is the emoji allow-list for message reactions
+var allowedReactions = map[string]bool{
+	"👍": true,
+	"❤️": true,
+	"😂": true,
+	"😮": true,
+	"😢": true,
+	"🔥": true,
+}
+
+type AddReactionReq struct {
+	Emoji string `json:"emoji" binding:"required"`
+}
+
+// reactionTarget is a message the user may react to, with both sides of its chat
+type reactionTarget struct {
+	MessageID     uuid.UUID
+	ChatRequestID uuid.UUID
+	UserSceneID   uuid.UUID
+	OtherSceneID  uuid.UUID
+}
+
+// loadReactionTarget checks the user is part of the message's chat and the chat is active
+func loadReactionTarget(userID, messageID uuid.UUID) (*reactionTarget, *apiError) {
+	// Get user's active scene
+	var userSceneID uuid.UUID
+	err := config.DB.QueryRow(
+		`SELECT s.id FROM scenes s
+		 JOIN personas p ON s.persona_id = p.id
+		 WHERE p.user_id = $1 AND s.is_active = true AND s.expires_at > NOW()
+		 ORDER BY s.started_at DESC LIMIT 1`,
+		userID,
+	).Scan(&userSceneID)
+
+	if err == sql.ErrNoRows {
+		return nil, &apiError{Status: http.StatusBadRequest, Message: "No active scene found"}
+	}
+	if err != nil {
+		log.Printf("Failed to get active scene: %v", err)
+		return nil, &apiError{Status: http.StatusInternalServerError, Message: "Failed to get active scene"}
+	}
+
+	target := reactionTarget{MessageID: messageID, UserSceneID: userSceneID}
+	var fromSceneID, toSceneID uuid.UUID
+	var status string
+	var expiresAt *time.Time
+	err = config.DB.QueryRow(
+		`SELECT cm.chat_request_id, cr.from_scene_id, cr.to_scene_id, cr.status, cr.expires_at
+		 FROM chat_messages cm
+		 JOIN chat_requests cr ON cm.chat_request_id = cr.id
+		 WHERE cm.id = $1`,
+		messageID,
+	).Scan(&target.ChatRequestID, &fromSceneID, &toSceneID, &status, &expiresAt)
+
+	if err == sql.ErrNoRows {
+		return nil, &apiError{Status: http.StatusNotFound, Message: "Message not found"}
+	}
+	if err != nil {
+		log.Printf("Failed to get chat message: %v", err)
+		return nil, &apiError{Status: http.StatusInternalServerError, Message: "Failed to get message"}
+	}
+
+	if userSceneID != fromSceneID && userSceneID != toSceneID {
+		return nil, &apiError{Status: http.StatusForbidden, Message: "You are not part of this chat"}
+	}
+
+	if status != "accepted" || (expiresAt != nil && time.Now().After(*expiresAt)) {
+		return nil, &apiError{Status: http.StatusBadRequest, Message: "Chat is no longer active"}
+	}
+
+	target.OtherSceneID = toSceneID
+	if userSceneID == toSceneID {
+		target.OtherSceneID = fromSceneID
+	}
+
+	return &target, nil
+}
+
+// AddReaction adds an emoji reaction to a chat message
+func AddReaction(wsHub *websocket.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := middleware.GetUserID(c)
+
+		messageID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message id"})
+			return
+		}
+
+		var req AddReactionReq
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if !allowedReactions[req.Emoji] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Reaction not allowed", "code": "REACTION_NOT_ALLOWED"})
+			return
+		}
+
+		target, apiErr := loadReactionTarget(userID, messageID)
+		if apiErr != nil {
+			apiErr.respond(c)
+			return
+		}
+
+		_, err = config.DB.Exec(
+			`INSERT INTO chat_message_reactions (message_id, scene_id, emoji)
+			 VALUES ($1, $2, $3)
+			 ON CONFLICT (message_id, scene_id, emoji) DO NOTHING`,
+			target.MessageID, target.UserSceneID, req.Emoji,
+		)
+		if err != nil {
+			log.Printf("Failed to add reaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add reaction"})
+			return
+		}
+
+		notifyReaction(wsHub, target, req.Emoji, "added")
+		c.JSON(http.StatusOK, gin.H{"message": "Reaction added"})
+	}
+}
+
+// RemoveReaction removes the user's emoji reaction from a chat message
+func RemoveReaction(wsHub *websocket.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := middleware.GetUserID(c)
+
+		messageID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message id"})
+			return
+		}
+
+		emoji := c.Param("emoji")
+		if !allowedReactions[emoji] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Reaction not allowed", "code": "REACTION_NOT_ALLOWED"})
+			return
+		}
+
+		target, apiErr := loadReactionTarget(userID, messageID)
+		if apiErr != nil {
+			apiErr.respond(c)
+			return
+		}
+
+		result, err := config.DB.Exec(
+			`DELETE FROM chat_message_reactions WHERE message_id = $1 AND scene_id = $2 AND emoji = $3`,
+			target.MessageID, target.UserSceneID, emoji,
+		)
+		if err != nil {
+			log.Printf("Failed to remove reaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove reaction"})
+			return
+		}
+
+		if count, _ := result.RowsAffected(); count > 0 {
+			notifyReaction(wsHub, target, emoji, "removed")
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Reaction removed"})
+	}
+}
+
+// notifyReaction sends the updated counts for a message to both sides of the chat
+func notifyReaction(wsHub *websocket.Hub, target *reactionTarget, emoji, action string) {
+	counts, err := loadReactionCounts([]uuid.UUID{target.MessageID})
+	if err != nil {
+		log.Printf("Failed to load reaction counts: %v", err)
+	}
+
+	reactionMsg := websocket.Message{
+		Type: "chat.message.reaction",
+		Data: map[string]interface{}{
+			"message_id": target.MessageID.String(),
+			"request_id": target.ChatRequestID.String(),
+			"scene_id":   target.UserSceneID.String(),
+			"emoji":      emoji,
+			"action":     action,
+			"reactions":  counts[target.MessageID],
+		},
+	}
+	wsHub.Targeted <- websocket.TargetedMessage{TargetSceneID: target.OtherSceneID, Message: reactionMsg}
+	wsHub.Targeted <- websocket.TargetedMessage{TargetSceneID: target.UserSceneID, Message: reactionMsg}
+}
+
+// loadReactionCounts aggregates reactions per message: message id -> emoji -> count
+func loadReactionCounts(messageIDs []uuid.UUID) (map[uuid.UUID]map[string]int, error) {
+	counts := make(map[uuid.UUID]map[string]int)
+	if len(messageIDs) == 0 {
+		return counts, nil
+	}
+
+	ids := make([]string, len(messageIDs))
+	for i, id := range messageIDs {
+		ids[i] = id.String()
+	}
+
+	rows, err := config.DB.Query(
+		`SELECT message_id, emoji, COUNT(*)
+		 FROM chat_message_reactions
+		 WHERE message_id = ANY($1::uuid[])
+		 GROUP BY message_id, emoji`,
+		ids,
+	)
+	if err != nil {
+		return counts, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID uuid.UUID
+		var emoji string
+		var count int
+		if err := rows.Scan(&messageID, &emoji, &count); err != nil {
+			return counts, err
+		}
+		if counts[messageID] == nil {
+			counts[messageID] = make(map[string]int)
+		}
+		counts[messageID][emoji] = count
+	}
+
+	return counts, rows.Err()
+}
+
+// attachReactionCounts fills in the Reactions field of each message
+func attachReactionCounts(messages []models.ChatMessage) {
+	ids := make([]uuid.UUID, len(messages))
+	for i, msg := range messages {
+		ids[i] = msg.ID
+	}
+
+	counts, err := loadReactionCounts(ids)
+	if err != nil {
+		log.Printf("Failed to load reaction counts: %v", err)
+		return
+	}
+
+	for i := range messages {
+		messages[i].Reactions = counts[messages[i].ID]
+	}
+}
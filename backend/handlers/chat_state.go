@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"scene-on/backend/config"
+	"scene-on/backend/middleware"
+	"scene-on/backend/websocket"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Chat request statuses. The database enforces the same set with a CHECK constraint.
+const (
+	chatStatusPending  = "pending"
+	chatStatusAccepted = "accepted"
+	chatStatusRejected = "rejected" // by the recipient
+	chatStatusCanceled = "canceled" // by the sender, before an answer
+	chatStatusExpired  = "expired"  // pending or accepted past expires_at
+	chatStatusEnded    = "ended"    // accepted, ended early by either side
+)
+
+// chatTransitions lists the legal moves between chat request statuses. Statuses missing
+// from the map are final.
+var chatTransitions = map[string][]string{
+	chatStatusPending:  {chatStatusAccepted, chatStatusRejected, chatStatusCanceled, chatStatusExpired},
+	chatStatusAccepted: {chatStatusEnded, chatStatusExpired},
+}
+
+func chatTransitionAllowed(from, to string) bool {
+	for _, next := range chatTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// chatRequestRef identifies a chat request moved by transitionChatRequests
+type chatRequestRef struct {
+	ID          uuid.UUID
+	FromSceneID uuid.UUID
+	ToSceneID   uuid.UUID
+}
+
+// chatQuerier is the part of *sql.DB and *sql.Tx the chat helpers use
+type chatQuerier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// transitionChatRequests is the only place chat request statuses change. It moves every
+// request in status from that matches cond to status to, also applying the optional set
+// clause, and returns the requests it moved. In cond and set, $1 and $2 are the two
+// statuses and args start at $3.
+func transitionChatRequests(from, to, cond, set string, args ...interface{}) ([]chatRequestRef, error) {
+	return transitionChatRequestsOn(config.DB, from, to, cond, set, args...)
+}
+
+// transitionChatRequestsOn is transitionChatRequests within a transaction
+func transitionChatRequestsOn(q chatQuerier, from, to, cond, set string, args ...interface{}) ([]chatRequestRef, error) {
+	if !chatTransitionAllowed(from, to) {
+		return nil, fmt.Errorf("illegal chat request transition %s -> %s", from, to)
+	}
+
+	query := `UPDATE chat_requests SET status = $2`
+	if set != "" {
+		query += `, ` + set
+	}
+	query += ` WHERE status = $1 AND (` + cond + `) RETURNING id, from_scene_id, to_scene_id`
+
+	rows, err := q.Query(query, append([]interface{}{from, to}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var moved []chatRequestRef
+	for rows.Next() {
+		var ref chatRequestRef
+		if err := rows.Scan(&ref.ID, &ref.FromSceneID, &ref.ToSceneID); err != nil {
+			return nil, err
+		}
+		moved = append(moved, ref)
+	}
+	return moved, rows.Err()
+}
+
+// transitionChatRequest moves one chat request from its current status to another.
+// Illegal moves, and requests whose status changed in the meantime, get a 409. In set,
+// $3 is the request id and extra args start at $4.
+func transitionChatRequest(reqUUID uuid.UUID, from, to, set string, args ...interface{}) *apiError {
+	if !chatTransitionAllowed(from, to) {
+		return chatTransitionError(from, to)
+	}
+
+	moved, err := transitionChatRequests(from, to, `id = $3`, set, append([]interface{}{reqUUID}, args...)...)
+	if err != nil {
+		log.Printf("Failed to move chat request %s from %s to %s: %v", reqUUID, from, to, err)
+		return &apiError{Status: http.StatusInternalServerError, Message: "Failed to update chat request"}
+	}
+
+	if len(moved) == 0 {
+		return lostTransitionError(reqUUID, to)
+	}
+
+	return nil
+}
+
+// lostTransitionError explains a transition that matched no row because another one
+// changed the request's status first
+func lostTransitionError(reqUUID uuid.UUID, to string) *apiError {
+	var current string
+	err := config.DB.QueryRow(`SELECT status FROM chat_requests WHERE id = $1`, reqUUID).Scan(&current)
+	if err == sql.ErrNoRows {
+		return &apiError{Status: http.StatusNotFound, Message: "Chat request not found"}
+	}
+	return chatTransitionError(current, to)
+}
+
+// lockChatSlots locks two scenes' rows until tx ends and returns the first of them with
+// no free chat slot, or uuid.Nil. Accepting a chat takes a slot on both sides, so
+// concurrent accepts and matches must count slots one at a time.
+func lockChatSlots(tx *sql.Tx, sceneA, sceneB uuid.UUID) (uuid.UUID, error) {
+	rows, err := tx.Query(`SELECT id FROM scenes WHERE id IN ($1, $2) ORDER BY id FOR UPDATE`, sceneA, sceneB)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	rows.Close()
+
+	for _, sceneID := range []uuid.UUID{sceneA, sceneB} {
+		full, err := chatSlotsFullOn(tx, sceneID)
+		if err != nil {
+			return uuid.Nil, err
+		}
+		if full {
+			return sceneID, nil
+		}
+	}
+	return uuid.Nil, nil
+}
+
+// acceptChatRequest moves a pending request to accepted, applying set as
+// transitionChatRequest does. If either scene has no free slot (CHAT_MAX_CONCURRENT)
+// nothing changes and that scene is returned; the recipient is checked first.
+func acceptChatRequest(ref chatRequestRef, set string, args ...interface{}) (uuid.UUID, *apiError) {
+	failed := &apiError{Status: http.StatusInternalServerError, Message: "Failed to accept chat request"}
+
+	tx, err := config.DB.Begin()
+	if err != nil {
+		log.Printf("Failed to begin transaction: %v", err)
+		return uuid.Nil, failed
+	}
+	defer tx.Rollback()
+
+	fullSceneID, err := lockChatSlots(tx, ref.ToSceneID, ref.FromSceneID)
+	if err != nil {
+		log.Printf("Failed to count active chats: %v", err)
+		return uuid.Nil, failed
+	}
+	if fullSceneID != uuid.Nil {
+		return fullSceneID, nil
+	}
+
+	moved, err := transitionChatRequestsOn(tx, chatStatusPending, chatStatusAccepted, `id = $3`, set,
+		append([]interface{}{ref.ID}, args...)...)
+	if err != nil {
+		log.Printf("Failed to accept chat request %s: %v", ref.ID, err)
+		return uuid.Nil, failed
+	}
+	if len(moved) == 0 {
+		tx.Rollback()
+		return uuid.Nil, lostTransitionError(ref.ID, chatStatusAccepted)
+	}
+	countSceneActivity(tx, recapChatsAccepted, ref.FromSceneID, ref.ToSceneID)
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Failed to accept chat request %s: %v", ref.ID, err)
+		return uuid.Nil, failed
+	}
+	return uuid.Nil, nil
+}
+
+// chatTransitionError is the 409 for a move the state machine doesn't allow
+func chatTransitionError(from, to string) *apiError {
+	return &apiError{
+		Status:  http.StatusConflict,
+		Code:    "INVALID_TRANSITION",
+		Message: fmt.Sprintf("Chat request is %s and cannot become %s", from, to),
+	}
+}
+
+// notifyChatEnded tells both sides an accepted chat was ended early. endedBy is the
+// scene that ended it, or uuid.Nil when the server did.
+func notifyChatEnded(wsHub *websocket.Hub, ref chatRequestRef, endedBy uuid.UUID) {
+	data := map[string]interface{}{
+		"request_id":    ref.ID.String(),
+		"from_scene_id": ref.FromSceneID.String(),
+		"to_scene_id":   ref.ToSceneID.String(),
+	}
+	if endedBy != uuid.Nil {
+		data["ended_by_scene_id"] = endedBy.String()
+	}
+
+	endedMsg := websocket.Message{Type: "chat.ended", Data: data}
+	wsHub.Targeted <- websocket.TargetedMessage{TargetSceneID: ref.FromSceneID, Message: endedMsg}
+	wsHub.Targeted <- websocket.TargetedMessage{TargetSceneID: ref.ToSceneID, Message: endedMsg}
+}
+
+// EndChat lets either side end an accepted chat before it expires
+func EndChat(wsHub *websocket.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := middleware.GetUserID(c)
+
+		reqUUID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request_id"})
+			return
+		}
+
+		// Get user's active scene
+		var userSceneID uuid.UUID
+		err = config.DB.QueryRow(
+			`SELECT s.id FROM scenes s
+			 JOIN personas p ON s.persona_id = p.id
+			 WHERE p.user_id = $1 AND s.is_active = true AND s.expires_at > NOW()
+			 ORDER BY s.started_at DESC LIMIT 1`,
+			userID,
+		).Scan(&userSceneID)
+
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No active scene found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to get active scene: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get active scene"})
+			return
+		}
+
+		ref := chatRequestRef{ID: reqUUID}
+		var status string
+		err = config.DB.QueryRow(
+			`SELECT from_scene_id, to_scene_id, status FROM chat_requests WHERE id = $1`,
+			reqUUID,
+		).Scan(&ref.FromSceneID, &ref.ToSceneID, &status)
+
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Chat not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to get chat request: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get chat"})
+			return
+		}
+
+		if userSceneID != ref.FromSceneID && userSceneID != ref.ToSceneID {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You are not part of this chat"})
+			return
+		}
+
+		// expires_at marks when the chat ended, which starts the export grace period
+		if apiErr := transitionChatRequest(reqUUID, status, chatStatusEnded, `expires_at = NOW()`); apiErr != nil {
+			apiErr.respond(c)
+			return
+		}
+
+		notifyChatEnded(wsHub, ref, userSceneID)
+		completeWaitingMatches(wsHub, ref.FromSceneID, ref.ToSceneID)
+		c.JSON(http.StatusOK, gin.H{"message": "Chat ended"})
+	}
+}
@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"scene-on/backend/config"
+	"scene-on/backend/middleware"
+	"scene-on/backend/websocket"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Message statuses, in the order a message moves through them
+const (
+	messageStatusSent      = "sent"
+	messageStatusDelivered = "delivered"
+	messageStatusRead      = "read"
+)
+
+// maxStatusMessageIDs caps how many messages one receipt may cover
+const maxStatusMessageIDs = 100
+
+// UpdateMessageStatusReq acknowledges messages received from the other side of a chat
+type UpdateMessageStatusReq struct {
+	Status     string   `json:"status" binding:"required,oneof=delivered read"`
+	MessageIDs []string `json:"message_ids" binding:"required,min=1"`
+}
+
+// messageStatus derives a message's status from its receipt timestamps
+func messageStatus(deliveredAt, readAt *time.Time) string {
+	switch {
+	case readAt != nil:
+		return messageStatusRead
+	case deliveredAt != nil:
+		return messageStatusDelivered
+	default:
+		return messageStatusSent
+	}
+}
+
+// markMessageStatus records that sceneID received (or read) messages the other side of
+// the chat sent, and tells the sender with a chat.message.status event. Statuses only
+// move forward; messages already at the status are left alone.
+func markMessageStatus(wsHub *websocket.Hub, sceneID, reqUUID uuid.UUID, messageIDs []uuid.UUID, status string) *apiError {
+	if len(messageIDs) > maxStatusMessageIDs {
+		return &apiError{Status: http.StatusBadRequest, Message: "Too many message_ids"}
+	}
+
+	var fromSceneID, toSceneID uuid.UUID
+	err := config.DB.QueryRow(
+		`SELECT from_scene_id, to_scene_id FROM chat_requests WHERE id = $1`,
+		reqUUID,
+	).Scan(&fromSceneID, &toSceneID)
+
+	if err == sql.ErrNoRows {
+		return &apiError{Status: http.StatusNotFound, Message: "Chat not found"}
+	}
+	if err != nil {
+		log.Printf("Failed to get chat request: %v", err)
+		return &apiError{Status: http.StatusInternalServerError, Message: "Failed to get chat"}
+	}
+
+	if sceneID != fromSceneID && sceneID != toSceneID {
+		return &apiError{Status: http.StatusForbidden, Message: "You are not part of this chat"}
+	}
+
+	ids := make([]string, len(messageIDs))
+	for i, id := range messageIDs {
+		ids[i] = id.String()
+	}
+
+	// Only the recipient can acknowledge, so the sender's own messages are skipped
+	query := `UPDATE chat_messages SET delivered_at = $4
+		 WHERE chat_request_id = $1 AND from_scene_id <> $2 AND id = ANY($3::uuid[])
+		 AND delivered_at IS NULL AND read_at IS NULL
+		 RETURNING id`
+	if status == messageStatusRead {
+		query = `UPDATE chat_messages SET read_at = $4, delivered_at = COALESCE(delivered_at, $4)
+		 WHERE chat_request_id = $1 AND from_scene_id <> $2 AND id = ANY($3::uuid[])
+		 AND read_at IS NULL
+		 RETURNING id`
+	}
+
+	now := time.Now().UTC()
+	rows, err := config.DB.Query(query, reqUUID, sceneID, ids, now)
+	if err != nil {
+		log.Printf("Failed to update message status: %v", err)
+		return &apiError{Status: http.StatusInternalServerError, Message: "Failed to update message status"}
+	}
+	defer rows.Close()
+
+	updated := []string{}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			log.Printf("Failed to scan message id: %v", err)
+			continue
+		}
+		updated = append(updated, id.String())
+	}
+
+	if len(updated) == 0 {
+		return nil
+	}
+
+	senderSceneID := fromSceneID
+	if sceneID == fromSceneID {
+		senderSceneID = toSceneID
+	}
+
+	wsHub.Targeted <- websocket.TargetedMessage{
+		TargetSceneID: senderSceneID,
+		Message: websocket.Message{
+			Type: "chat.message.status",
+			Data: map[string]interface{}{
+				"request_id":  reqUUID.String(),
+				"message_ids": updated,
+				"status":      status,
+				"at":          now.Format(time.RFC3339),
+			},
+		},
+	}
+
+	return nil
+}
+
+// UpdateMessageStatus marks messages of a chat as delivered or read
+func UpdateMessageStatus(wsHub *websocket.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := middleware.GetUserID(c)
+
+		reqUUID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request_id"})
+			return
+		}
+
+		var req UpdateMessageStatusReq
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		messageIDs := make([]uuid.UUID, 0, len(req.MessageIDs))
+		for _, idStr := range req.MessageIDs {
+			id, err := uuid.Parse(idStr)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message id: " + idStr})
+				return
+			}
+			messageIDs = append(messageIDs, id)
+		}
+
+		// Get user's active scene
+		var userSceneID uuid.UUID
+		err = config.DB.QueryRow(
+			`SELECT s.id FROM scenes s
+			 JOIN personas p ON s.persona_id = p.id
+			 WHERE p.user_id = $1 AND s.is_active = true AND s.expires_at > NOW()
+			 ORDER BY s.started_at DESC LIMIT 1`,
+			userID,
+		).Scan(&userSceneID)
+
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No active scene found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to get active scene: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get active scene"})
+			return
+		}
+
+		if apiErr := markMessageStatus(wsHub, userSceneID, reqUUID, messageIDs, req.Status); apiErr != nil {
+			apiErr.respond(c)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Status updated"})
+	}
+}
+
+// handleMessageStatus is the WebSocket form of UpdateMessageStatus for one status:
+// {"type": "chat.message.read", "data": {"request_id": "...", "message_ids": ["..."]}}
+func handleMessageStatus(wsHub *websocket.Hub, status string) websocket.CommandHandler {
+	msgType := "chat.message." + status
+	return func(client *websocket.Client, data map[string]interface{}) {
+		requestID, _ := data["request_id"].(string)
+		reqUUID, err := uuid.Parse(requestID)
+		if err != nil {
+			client.Send <- websocket.Message{Type: "error", Data: (&apiError{
+				Status:  http.StatusBadRequest,
+				Message: "Invalid request_id",
+			}).wsData(msgType)}
+			return
+		}
+
+		rawIDs, _ := data["message_ids"].([]interface{})
+		messageIDs := make([]uuid.UUID, 0, len(rawIDs))
+		for _, raw := range rawIDs {
+			idStr, _ := raw.(string)
+			id, err := uuid.Parse(idStr)
+			if err != nil {
+				client.Send <- websocket.Message{Type: "error", Data: (&apiError{
+					Status:  http.StatusBadRequest,
+					Message: "Invalid message id: " + idStr,
+				}).wsData(msgType)}
+				return
+			}
+			messageIDs = append(messageIDs, id)
+		}
+
+		if apiErr := markMessageStatus(wsHub, client.SceneID, reqUUID, messageIDs, status); apiErr != nil {
+			client.Send <- websocket.Message{Type: "error", Data: apiErr.wsData(msgType)}
+		}
+	}
+}
+
+// handleMessageWritten marks a chat message delivered once the recipient's connection
+// has written it
+func handleMessageWritten(wsHub *websocket.Hub) websocket.CommandHandler {
+	return func(client *websocket.Client, data map[string]interface{}) {
+		requestID, _ := data["request_id"].(string)
+		messageID, _ := data["message_id"].(string)
+
+		reqUUID, err := uuid.Parse(requestID)
+		if err != nil {
+			return
+		}
+		msgUUID, err := uuid.Parse(messageID)
+		if err != nil {
+			return
+		}
+
+		if apiErr := markMessageStatus(wsHub, client.SceneID, reqUUID, []uuid.UUID{msgUUID}, messageStatusDelivered); apiErr != nil {
+			log.Printf("Failed to mark message %s delivered: %s", msgUUID, apiErr.Message)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"scene-on/backend/config"
+
+	"github.com/google/uuid"
+)
+
+// e2ePublicKeySize is the size of a scene's X25519 public key. Keys travel base64-encoded.
+const e2ePublicKeySize = 32
+
+// validatePublicKey checks a scene's base64 X25519 public key
+func validatePublicKey(key string) error {
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(raw) != e2ePublicKeySize {
+		return errors.New("public_key must be a base64-encoded 32-byte X25519 key")
+	}
+	return nil
+}
+
+// validateCiphertext checks the opaque ciphertext and nonce of an encrypted message.
+// The server never decrypts them; it only makes sure they are well-formed base64.
+func validateCiphertext(ciphertext, nonce string) error {
+	if ciphertext == "" || nonce == "" {
+		return errors.New("ciphertext and cipher_nonce are required in encrypted chats")
+	}
+	if _, err := base64.StdEncoding.DecodeString(ciphertext); err != nil {
+		return errors.New("ciphertext must be base64-encoded")
+	}
+	if _, err := base64.StdEncoding.DecodeString(nonce); err != nil {
+		return errors.New("cipher_nonce must be base64-encoded")
+	}
+	return nil
+}
+
+// scenePublicKeys returns the public keys of both sides of a chat (nil when a scene has
+// not published one)
+func scenePublicKeys(fromSceneID, toSceneID uuid.UUID) (fromKey, toKey *string, err error) {
+	err = config.DB.QueryRow(
+		`SELECT (SELECT public_key FROM scenes WHERE id = $1),
+		        (SELECT public_key FROM scenes WHERE id = $2)`,
+		fromSceneID, toSceneID,
+	).Scan(&fromKey, &toKey)
+	return fromKey, toKey, err
+}
@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// apiError is a failure with an HTTP status and an optional machine-readable code,
+// for logic shared between REST handlers and WebSocket commands
+type apiError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+// respond writes the error in the usual {"error": ..., "code": ...} shape
+func (e *apiError) respond(c *gin.Context) {
+	body := gin.H{"error": e.Message}
+	if e.Code != "" {
+		body["code"] = e.Code
+	}
+	c.JSON(e.Status, body)
+}
+
+// wsData converts the error into the data of a WebSocket "error" message
+func (e *apiError) wsData(msgType string) map[string]interface{} {
+	data := map[string]interface{}{
+		"type":  msgType,
+		"error": e.Message,
+	}
+	if e.Code != "" {
+		data["code"] = e.Code
+	}
+	return data
+}
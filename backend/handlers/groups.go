@@ -0,0 +1,897 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"scene-on/backend/config"
+	"scene-on/backend/geo"
+	"scene-on/backend/middleware"
+	"scene-on/backend/models"
+	"scene-on/backend/moderation"
+	"scene-on/backend/websocket"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// defaultGroupRoomTTL is how long a group room lives at most (GROUP_ROOM_TTL)
+	defaultGroupRoomTTL = 30 * time.Minute
+	// defaultGroupMaxMembers caps invited + joined scenes per room, creator included (GROUP_MAX_MEMBERS)
+	defaultGroupMaxMembers = 8
+	// defaultGroupInviteRadius is how close an invitee must be to the creator, in meters (GROUP_INVITE_RADIUS)
+	defaultGroupInviteRadius = 5000
+	// groupMessageLimit is how many recent messages GetGroupMessages returns
+	groupMessageLimit = 200
+)
+
+type CreateGroupRoomReq struct {
+	Name     string   `json:"name" binding:"required,max=100"`
+	SceneIDs []string `json:"scene_ids" binding:"required,min=1"`
+}
+
+type InviteToGroupRoomReq struct {
+	SceneIDs []string `json:"scene_ids" binding:"required,min=1"`
+}
+
+type SendGroupMessageReq struct {
+	Content string `json:"content" binding:"required"`
+}
+
+// groupScene is the caller's active scene with its position
+type groupScene struct {
+	ID        uuid.UUID
+	Latitude  float64
+	Longitude float64
+}
+
+func getGroupScene(userID uuid.UUID) (*groupScene, *apiError) {
+	var scene groupScene
+	err := config.DB.QueryRow(
+		`SELECT s.id, s.latitude, s.longitude FROM scenes s
+		 JOIN personas p ON s.persona_id = p.id
+		 WHERE p.user_id = $1 AND s.is_active = true AND s.expires_at > NOW()
+		 ORDER BY s.started_at DESC LIMIT 1`,
+		userID,
+	).Scan(&scene.ID, &scene.Latitude, &scene.Longitude)
+
+	if err == sql.ErrNoRows {
+		return nil, &apiError{Status: http.StatusBadRequest, Message: "No active scene found"}
+	}
+	if err != nil {
+		log.Printf("Failed to get active scene: %v", err)
+		return nil, &apiError{Status: http.StatusInternalServerError, Message: "Failed to get active scene"}
+	}
+	return &scene, nil
+}
+
+// loadGroupRoom loads a running room and the caller's membership status in it
+// ("" when the scene is not a member)
+func loadGroupRoom(roomID, sceneID uuid.UUID) (*models.GroupRoom, string, *apiError) {
+	var room models.GroupRoom
+	var status sql.NullString
+	err := config.DB.QueryRow(
+		`SELECT r.id, r.creator_scene_id, r.name, r.expires_at, r.created_at, m.status
+		 FROM group_rooms r
+		 LEFT JOIN group_room_members m ON m.room_id = r.id AND m.scene_id = $2
+		 WHERE r.id = $1 AND r.expires_at > NOW()`,
+		roomID, sceneID,
+	).Scan(&room.ID, &room.CreatorSceneID, &room.Name, &room.ExpiresAt, &room.CreatedAt, &status)
+
+	if err == sql.ErrNoRows {
+		return nil, "", &apiError{Status: http.StatusNotFound, Message: "Group not found"}
+	}
+	if err != nil {
+		log.Printf("Failed to get group room: %v", err)
+		return nil, "", &apiError{Status: http.StatusInternalServerError, Message: "Failed to get group"}
+	}
+	return &room, status.String, nil
+}
+
+// loadGroupMembers returns the invited and joined members of a room
+func loadGroupMembers(roomID uuid.UUID) ([]models.GroupMember, error) {
+	rows, err := config.DB.Query(
+		`SELECT m.scene_id, p.name, m.status, m.joined_at
+		 FROM group_room_members m
+		 JOIN scenes s ON m.scene_id = s.id
+		 JOIN personas p ON s.persona_id = p.id
+		 WHERE m.room_id = $1
+		 ORDER BY m.invited_at ASC`,
+		roomID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []models.GroupMember{}
+	for rows.Next() {
+		var m models.GroupMember
+		if err := rows.Scan(&m.SceneID, &m.PersonaName, &m.Status, &m.JoinedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+// notifyGroup sends msg to every member of a room with one of the given statuses,
+// except skipSceneID. Members who blocked fromUserID (or were blocked by them) are
+// filtered out by the hub.
+func notifyGroup(wsHub *websocket.Hub, roomID uuid.UUID, msg websocket.Message, skipSceneID, fromUserID uuid.UUID, statuses ...string) {
+	rows, err := config.DB.Query(
+		`SELECT scene_id FROM group_room_members WHERE room_id = $1 AND status = ANY($2::text[])`,
+		roomID, statuses,
+	)
+	if err != nil {
+		log.Printf("Failed to get group members for %s: %v", roomID, err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var sceneID uuid.UUID
+		if err := rows.Scan(&sceneID); err != nil {
+			log.Printf("Failed to scan group member: %v", err)
+			continue
+		}
+		if sceneID == skipSceneID {
+			continue
+		}
+		wsHub.Targeted <- websocket.TargetedMessage{TargetSceneID: sceneID, Message: msg, FromUserID: fromUserID}
+	}
+}
+
+// inviteToGroup checks the invitees are active and close to the inviter, then adds
+// them to the room as 'invited' through q and returns the scenes newly invited. Callers
+// send group.invite with notifyGroupInvites once the members are committed.
+func inviteToGroup(q chatQuerier, room *models.GroupRoom, inviter *groupScene, userID uuid.UUID, sceneIDStrs []string) ([]uuid.UUID, *apiError) {
+	seen := make(map[uuid.UUID]bool)
+	var ids []string
+	for _, idStr := range sceneIDStrs {
+		id, err := uuid.Parse(idStr)
+		if err != nil {
+			return nil, &apiError{Status: http.StatusBadRequest, Message: "Invalid scene id: " + idStr}
+		}
+		if id == inviter.ID {
+			return nil, &apiError{Status: http.StatusBadRequest, Message: "Cannot invite your own scene"}
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id.String())
+		}
+	}
+
+	var memberCount int
+	err := q.QueryRow(
+		`SELECT COUNT(*) FROM group_room_members
+		 WHERE room_id = $1 AND NOT (scene_id = ANY($2::uuid[]))`,
+		room.ID, ids,
+	).Scan(&memberCount)
+	if err != nil {
+		log.Printf("Failed to count group members: %v", err)
+		return nil, &apiError{Status: http.StatusInternalServerError, Message: "Failed to invite"}
+	}
+	maxMembers := config.GetInt("GROUP_MAX_MEMBERS", defaultGroupMaxMembers)
+	if memberCount+len(ids) > maxMembers {
+		return nil, &apiError{
+			Status:  http.StatusBadRequest,
+			Code:    "GROUP_FULL",
+			Message: fmt.Sprintf("A group can have at most %d members", maxMembers),
+		}
+	}
+
+	// Scenes of users blocked in either direction are treated as missing
+	rows, err := q.Query(
+		`SELECT s.id, s.latitude, s.longitude FROM scenes s
+		 JOIN personas p ON s.persona_id = p.id
+		 WHERE s.id = ANY($1::uuid[]) AND s.is_active = true AND s.expires_at > NOW()
+		 AND NOT EXISTS (
+			SELECT 1 FROM user_blocks b
+			WHERE (b.blocker_user_id = $2 AND b.blocked_user_id = p.user_id)
+			   OR (b.blocker_user_id = p.user_id AND b.blocked_user_id = $2)
+		 )`,
+		ids, userID,
+	)
+	if err != nil {
+		log.Printf("Failed to get invited scenes: %v", err)
+		return nil, &apiError{Status: http.StatusInternalServerError, Message: "Failed to invite"}
+	}
+	defer rows.Close()
+
+	radius := float64(config.GetInt("GROUP_INVITE_RADIUS", defaultGroupInviteRadius))
+	var invitees []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		var lat, lon float64
+		if err := rows.Scan(&id, &lat, &lon); err != nil {
+			log.Printf("Failed to scan invited scene: %v", err)
+			return nil, &apiError{Status: http.StatusInternalServerError, Message: "Failed to invite"}
+		}
+		if geo.Distance(inviter.Latitude, inviter.Longitude, lat, lon) > radius {
+			return nil, &apiError{
+				Status:  http.StatusBadRequest,
+				Code:    "TOO_FAR",
+				Message: "Scene " + id.String() + " is too far away to invite",
+			}
+		}
+		invitees = append(invitees, id)
+	}
+	if len(invitees) != len(ids) {
+		return nil, &apiError{Status: http.StatusNotFound, Message: "One or more scenes not found or no longer active"}
+	}
+
+	var invited []uuid.UUID
+	for _, sceneID := range invitees {
+		result, err := q.Exec(
+			`INSERT INTO group_room_members (room_id, scene_id, status)
+			 VALUES ($1, $2, 'invited')
+			 ON CONFLICT (room_id, scene_id) DO NOTHING`,
+			room.ID, sceneID,
+		)
+		if err != nil {
+			log.Printf("Failed to invite scene %s to group %s: %v", sceneID, room.ID, err)
+			return nil, &apiError{Status: http.StatusInternalServerError, Message: "Failed to invite"}
+		}
+		if count, _ := result.RowsAffected(); count == 0 {
+			continue // already a member
+		}
+		invited = append(invited, sceneID)
+	}
+
+	return invited, nil
+}
+
+// notifyGroupInvites sends group.invite to the scenes invited to a room
+func notifyGroupInvites(wsHub *websocket.Hub, room *models.GroupRoom, invited []uuid.UUID) {
+	for _, sceneID := range invited {
+		wsHub.Targeted <- websocket.TargetedMessage{
+			TargetSceneID: sceneID,
+			Message: websocket.Message{
+				Type: "group.invite",
+				Data: map[string]interface{}{
+					"room_id":          room.ID.String(),
+					"name":             room.Name,
+					"creator_scene_id": room.CreatorSceneID.String(),
+					"expires_at":       room.ExpiresAt.Format(time.RFC3339),
+				},
+			},
+		}
+	}
+}
+
+// CreateGroupRoom creates a group room owned by the caller's scene and invites nearby scenes
+func CreateGroupRoom(wsHub *websocket.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := middleware.GetUserID(c)
+
+		var req CreateGroupRoomReq
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		scene, apiErr := getGroupScene(userID)
+		if apiErr != nil {
+			apiErr.respond(c)
+			return
+		}
+
+		room := models.GroupRoom{
+			ID:             uuid.New(),
+			CreatorSceneID: scene.ID,
+			Name:           req.Name,
+			ExpiresAt:      time.Now().Add(config.GetDuration("GROUP_ROOM_TTL", defaultGroupRoomTTL)),
+			CreatedAt:      time.Now(),
+		}
+
+		// The room, its creator and the invites are created together or not at all
+		tx, err := config.DB.Begin()
+		if err != nil {
+			log.Printf("Failed to begin transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create group"})
+			return
+		}
+		defer tx.Rollback()
+
+		_, err = tx.Exec(
+			`INSERT INTO group_rooms (id, creator_scene_id, name, expires_at, created_at)
+			 VALUES ($1, $2, $3, $4, $5)`,
+			room.ID, room.CreatorSceneID, room.Name, room.ExpiresAt, room.CreatedAt,
+		)
+		if err != nil {
+			log.Printf("Failed to create group room: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create group"})
+			return
+		}
+
+		// The creator is always a joined member
+		_, err = tx.Exec(
+			`INSERT INTO group_room_members (room_id, scene_id, status, joined_at)
+			 VALUES ($1, $2, 'joined', NOW())`,
+			room.ID, scene.ID,
+		)
+		if err != nil {
+			log.Printf("Failed to add group creator: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create group"})
+			return
+		}
+
+		invited, apiErr := inviteToGroup(tx, &room, scene, userID, req.SceneIDs)
+		if apiErr != nil {
+			apiErr.respond(c)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			log.Printf("Failed to create group room: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create group"})
+			return
+		}
+		notifyGroupInvites(wsHub, &room, invited)
+
+		room.Members, err = loadGroupMembers(room.ID)
+		if err != nil {
+			log.Printf("Failed to get group members: %v", err)
+		}
+
+		log.Printf("👥 Scene %s created group %s", scene.ID, room.ID)
+		c.JSON(http.StatusCreated, room)
+	}
+}
+
+// InviteToGroupRoom invites more nearby scenes (creator only)
+func InviteToGroupRoom(wsHub *websocket.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := middleware.GetUserID(c)
+
+		roomID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group id"})
+			return
+		}
+
+		var req InviteToGroupRoomReq
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		scene, apiErr := getGroupScene(userID)
+		if apiErr != nil {
+			apiErr.respond(c)
+			return
+		}
+
+		room, _, apiErr := loadGroupRoom(roomID, scene.ID)
+		if apiErr != nil {
+			apiErr.respond(c)
+			return
+		}
+
+		if room.CreatorSceneID != scene.ID {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only the group creator can invite"})
+			return
+		}
+
+		// The room row is locked so concurrent invites count members one at a time
+		tx, err := config.DB.Begin()
+		if err != nil {
+			log.Printf("Failed to begin transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to invite"})
+			return
+		}
+		defer tx.Rollback()
+
+		if _, err := tx.Exec(`SELECT 1 FROM group_rooms WHERE id = $1 FOR UPDATE`, room.ID); err != nil {
+			log.Printf("Failed to lock group room: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to invite"})
+			return
+		}
+
+		invited, apiErr := inviteToGroup(tx, room, scene, userID, req.SceneIDs)
+		if apiErr != nil {
+			apiErr.respond(c)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			log.Printf("Failed to invite to group %s: %v", room.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to invite"})
+			return
+		}
+		notifyGroupInvites(wsHub, room, invited)
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Invites sent",
+			"invited": len(invited),
+		})
+	}
+}
+
+// AcceptGroupInvite joins a room the caller's scene was invited to
+func AcceptGroupInvite(wsHub *websocket.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := middleware.GetUserID(c)
+
+		roomID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group id"})
+			return
+		}
+
+		scene, apiErr := getGroupScene(userID)
+		if apiErr != nil {
+			apiErr.respond(c)
+			return
+		}
+
+		room, status, apiErr := loadGroupRoom(roomID, scene.ID)
+		if apiErr != nil {
+			apiErr.respond(c)
+			return
+		}
+
+		if status != "invited" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No pending invite for this group"})
+			return
+		}
+
+		result, err := config.DB.Exec(
+			`UPDATE group_room_members SET status = 'joined', joined_at = NOW()
+			 WHERE room_id = $1 AND scene_id = $2 AND status = 'invited'`,
+			roomID, scene.ID,
+		)
+		if err != nil {
+			log.Printf("Failed to join group: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to join group"})
+			return
+		}
+		if count, _ := result.RowsAffected(); count == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No pending invite for this group"})
+			return
+		}
+
+		notifyGroup(wsHub, roomID, websocket.Message{
+			Type: "group.member.joined",
+			Data: map[string]interface{}{
+				"room_id":  roomID.String(),
+				"scene_id": scene.ID.String(),
+			},
+		}, scene.ID, userID, "joined")
+
+		room.Members, err = loadGroupMembers(roomID)
+		if err != nil {
+			log.Printf("Failed to get group members: %v", err)
+		}
+
+		c.JSON(http.StatusOK, room)
+	}
+}
+
+// DeclineGroupInvite turns down an invite
+func DeclineGroupInvite(wsHub *websocket.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := middleware.GetUserID(c)
+
+		roomID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group id"})
+			return
+		}
+
+		scene, apiErr := getGroupScene(userID)
+		if apiErr != nil {
+			apiErr.respond(c)
+			return
+		}
+
+		room, status, apiErr := loadGroupRoom(roomID, scene.ID)
+		if apiErr != nil {
+			apiErr.respond(c)
+			return
+		}
+
+		if status != "invited" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No pending invite for this group"})
+			return
+		}
+
+		_, err = config.DB.Exec(
+			`DELETE FROM group_room_members WHERE room_id = $1 AND scene_id = $2 AND status = 'invited'`,
+			roomID, scene.ID,
+		)
+		if err != nil {
+			log.Printf("Failed to decline group invite: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decline invite"})
+			return
+		}
+
+		wsHub.Targeted <- websocket.TargetedMessage{
+			TargetSceneID: room.CreatorSceneID,
+			Message: websocket.Message{
+				Type: "group.invite.declined",
+				Data: map[string]interface{}{
+					"room_id":  roomID.String(),
+					"scene_id": scene.ID.String(),
+				},
+			},
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Invite declined"})
+	}
+}
+
+// LeaveGroupRoom removes the caller from a room. The creator leaving ends the room.
+func LeaveGroupRoom(wsHub *websocket.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := middleware.GetUserID(c)
+
+		roomID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group id"})
+			return
+		}
+
+		scene, apiErr := getGroupScene(userID)
+		if apiErr != nil {
+			apiErr.respond(c)
+			return
+		}
+
+		room, status, apiErr := loadGroupRoom(roomID, scene.ID)
+		if apiErr != nil {
+			apiErr.respond(c)
+			return
+		}
+
+		if status != "joined" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You are not in this group"})
+			return
+		}
+
+		if room.CreatorSceneID == scene.ID {
+			endGroupRoom(wsHub, roomID, "creator_left")
+			c.JSON(http.StatusOK, gin.H{"message": "Group ended"})
+			return
+		}
+
+		leaveGroupRoom(wsHub, roomID, scene.ID)
+		c.JSON(http.StatusOK, gin.H{"message": "Left group"})
+	}
+}
+
+// leaveGroupRoom drops a member and tells the rest of the room
+func leaveGroupRoom(wsHub *websocket.Hub, roomID, sceneID uuid.UUID) {
+	_, err := config.DB.Exec(
+		`DELETE FROM group_room_members WHERE room_id = $1 AND scene_id = $2`,
+		roomID, sceneID,
+	)
+	if err != nil {
+		log.Printf("Failed to leave group %s: %v", roomID, err)
+		return
+	}
+
+	notifyGroup(wsHub, roomID, websocket.Message{
+		Type: "group.member.left",
+		Data: map[string]interface{}{
+			"room_id":  roomID.String(),
+			"scene_id": sceneID.String(),
+		},
+	}, sceneID, uuid.Nil, "joined")
+}
+
+// endGroupRoom deletes a room with its members and messages and tells everyone in it
+func endGroupRoom(wsHub *websocket.Hub, roomID uuid.UUID, reason string) {
+	// Notify before deleting, while the member list still exists
+	notifyGroup(wsHub, roomID, websocket.Message{
+		Type: "group.ended",
+		Data: map[string]interface{}{
+			"room_id": roomID.String(),
+			"reason":  reason,
+		},
+	}, uuid.Nil, uuid.Nil, "invited", "joined")
+
+	_, err := config.DB.Exec(`DELETE FROM group_rooms WHERE id = $1`, roomID)
+	if err != nil {
+		log.Printf("Failed to delete group room %s: %v", roomID, err)
+	}
+}
+
+// endGroupRoomsForScene ends the rooms a stopping scene created and removes it from
+// the rooms it was invited to or joined
+func endGroupRoomsForScene(wsHub *websocket.Hub, sceneID uuid.UUID) {
+	rows, err := config.DB.Query(
+		`SELECT r.id, r.creator_scene_id = $1
+		 FROM group_rooms r
+		 JOIN group_room_members m ON m.room_id = r.id
+		 WHERE m.scene_id = $1`,
+		sceneID,
+	)
+	if err != nil {
+		log.Printf("Failed to get group rooms for scene %s: %v", sceneID, err)
+		return
+	}
+
+	type membership struct {
+		roomID    uuid.UUID
+		isCreator bool
+	}
+	var memberships []membership
+	for rows.Next() {
+		var m membership
+		if err := rows.Scan(&m.roomID, &m.isCreator); err != nil {
+			log.Printf("Failed to scan group room: %v", err)
+			continue
+		}
+		memberships = append(memberships, m)
+	}
+	rows.Close()
+
+	for _, m := range memberships {
+		if m.isCreator {
+			endGroupRoom(wsHub, m.roomID, "creator_left")
+		} else {
+			leaveGroupRoom(wsHub, m.roomID, sceneID)
+		}
+	}
+}
+
+// cleanupExpiredGroupRooms ends rooms past their TTL or whose creator's scene is gone,
+// and drops members whose scenes are no longer active
+func cleanupExpiredGroupRooms(wsHub *websocket.Hub) {
+	rows, err := config.DB.Query(
+		`SELECT r.id, r.expires_at < NOW()
+		 FROM group_rooms r
+		 LEFT JOIN scenes s ON r.creator_scene_id = s.id
+		 WHERE r.expires_at < NOW()
+		 OR s.id IS NULL OR s.is_active = false OR s.expires_at < NOW()`,
+	)
+	if err != nil {
+		log.Printf("Failed to query expired group rooms: %v", err)
+		return
+	}
+
+	type endedRoom struct {
+		id      uuid.UUID
+		expired bool
+	}
+	var ended []endedRoom
+	for rows.Next() {
+		var r endedRoom
+		if err := rows.Scan(&r.id, &r.expired); err != nil {
+			log.Printf("Failed to scan expired group room: %v", err)
+			continue
+		}
+		ended = append(ended, r)
+	}
+	rows.Close()
+
+	for _, r := range ended {
+		reason := "creator_left"
+		if r.expired {
+			reason = "expired"
+		}
+		endGroupRoom(wsHub, r.id, reason)
+	}
+	if len(ended) > 0 {
+		log.Printf("✅ Ended %d group room(s)", len(ended))
+	}
+
+	// Members whose scenes ended without going through StopScene
+	rows, err = config.DB.Query(
+		`SELECT m.room_id, m.scene_id
+		 FROM group_room_members m
+		 JOIN scenes s ON m.scene_id = s.id
+		 WHERE s.is_active = false OR s.expires_at < NOW()`,
+	)
+	if err != nil {
+		log.Printf("Failed to query stale group members: %v", err)
+		return
+	}
+
+	type staleMember struct {
+		roomID, sceneID uuid.UUID
+	}
+	var stale []staleMember
+	for rows.Next() {
+		var m staleMember
+		if err := rows.Scan(&m.roomID, &m.sceneID); err != nil {
+			log.Printf("Failed to scan stale group member: %v", err)
+			continue
+		}
+		stale = append(stale, m)
+	}
+	rows.Close()
+
+	for _, m := range stale {
+		leaveGroupRoom(wsHub, m.roomID, m.sceneID)
+	}
+}
+
+// SendGroupMessage posts a message to a room and fans it out to the other joined members
+func SendGroupMessage(wsHub *websocket.Hub, mod moderation.Moderator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := middleware.GetUserID(c)
+
+		roomID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group id"})
+			return
+		}
+
+		var req SendGroupMessageReq
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		scene, apiErr := getGroupScene(userID)
+		if apiErr != nil {
+			apiErr.respond(c)
+			return
+		}
+
+		_, status, apiErr := loadGroupRoom(roomID, scene.ID)
+		if apiErr != nil {
+			apiErr.respond(c)
+			return
+		}
+
+		if status != "joined" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You are not in this group"})
+			return
+		}
+
+		content, ok := moderateText(c, mod, moderation.ContextGroupMessage, userID, req.Content)
+		if !ok {
+			return
+		}
+
+		message := models.GroupMessage{
+			ID:          uuid.New(),
+			RoomID:      roomID,
+			FromSceneID: scene.ID,
+			Content:     content,
+			CreatedAt:   time.Now(),
+		}
+
+		_, err = config.DB.Exec(
+			`INSERT INTO group_messages (id, room_id, from_scene_id, content, created_at)
+			 VALUES ($1, $2, $3, $4, $5)`,
+			message.ID, message.RoomID, message.FromSceneID, message.Content, message.CreatedAt,
+		)
+		if err != nil {
+			log.Printf("Failed to create group message: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message"})
+			return
+		}
+
+		notifyGroup(wsHub, roomID, websocket.Message{
+			Type: "group.message.received",
+			Data: map[string]interface{}{
+				"message_id":    message.ID.String(),
+				"room_id":       roomID.String(),
+				"from_scene_id": scene.ID.String(),
+				"content":       message.Content,
+				"created_at":    message.CreatedAt.Format(time.RFC3339),
+			},
+		}, scene.ID, userID, "joined")
+
+		c.JSON(http.StatusCreated, message)
+	}
+}
+
+// GetGroupMessages returns the most recent messages of a room the caller has joined
+func GetGroupMessages(c *gin.Context) {
+	userID, _ := middleware.GetUserID(c)
+
+	roomID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group id"})
+		return
+	}
+
+	scene, apiErr := getGroupScene(userID)
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	_, status, apiErr := loadGroupRoom(roomID, scene.ID)
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	if status != "joined" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are not in this group"})
+		return
+	}
+
+	// Messages of users blocked in either direction are left out, as they are live
+	rows, err := config.DB.Query(
+		`SELECT id, room_id, from_scene_id, content, created_at FROM (
+			SELECT gm.id, gm.room_id, gm.from_scene_id, gm.content, gm.created_at
+			FROM group_messages gm
+			JOIN scenes s ON gm.from_scene_id = s.id
+			JOIN personas p ON s.persona_id = p.id
+			WHERE gm.room_id = $1
+			AND NOT EXISTS (
+				SELECT 1 FROM user_blocks b
+				WHERE (b.blocker_user_id = $3 AND b.blocked_user_id = p.user_id)
+				   OR (b.blocker_user_id = p.user_id AND b.blocked_user_id = $3)
+			)
+			ORDER BY gm.created_at DESC
+			LIMIT $2
+		 ) recent
+		 ORDER BY created_at ASC`,
+		roomID, groupMessageLimit, userID,
+	)
+	if err != nil {
+		log.Printf("Failed to get group messages: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get messages"})
+		return
+	}
+	defer rows.Close()
+
+	messages := []models.GroupMessage{}
+	for rows.Next() {
+		var msg models.GroupMessage
+		if err := rows.Scan(&msg.ID, &msg.RoomID, &msg.FromSceneID, &msg.Content, &msg.CreatedAt); err != nil {
+			log.Printf("Failed to scan group message: %v", err)
+			continue
+		}
+		messages = append(messages, msg)
+	}
+
+	c.JSON(http.StatusOK, messages)
+}
+
+// GetGroupRooms lists the running rooms the caller's scene is invited to or has joined
+func GetGroupRooms(c *gin.Context) {
+	userID, _ := middleware.GetUserID(c)
+
+	scene, apiErr := getGroupScene(userID)
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	rows, err := config.DB.Query(
+		`SELECT r.id, r.creator_scene_id, r.name, r.expires_at, r.created_at
+		 FROM group_rooms r
+		 JOIN group_room_members m ON m.room_id = r.id
+		 WHERE m.scene_id = $1 AND r.expires_at > NOW()
+		 ORDER BY r.created_at DESC`,
+		scene.ID,
+	)
+	if err != nil {
+		log.Printf("Failed to get group rooms: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get groups"})
+		return
+	}
+
+	rooms := []models.GroupRoom{}
+	for rows.Next() {
+		var room models.GroupRoom
+		if err := rows.Scan(&room.ID, &room.CreatorSceneID, &room.Name, &room.ExpiresAt, &room.CreatedAt); err != nil {
+			log.Printf("Failed to scan group room: %v", err)
+			continue
+		}
+		rooms = append(rooms, room)
+	}
+	rows.Close()
+
+	for i := range rooms {
+		rooms[i].Members, err = loadGroupMembers(rooms[i].ID)
+		if err != nil {
+			log.Printf("Failed to get group members: %v", err)
+		}
+	}
+
+	c.JSON(http.StatusOK, rooms)
+}
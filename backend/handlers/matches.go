@@ -0,0 +1,432 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"scene-on/backend/config"
+	"scene-on/backend/middleware"
+	"scene-on/backend/websocket"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// LikeSceneReq silently likes a nearby scene
+type LikeSceneReq struct {
+	SceneID string `json:"scene_id" binding:"required"`
+}
+
+// likeTarget resolves the scene being liked. Like chat requests, it must be active, not
+// blocked in either direction, not the caller's own and within CHAT_MAX_DISTANCE.
+func likeTarget(userID, fromSceneID uuid.UUID, sceneIDStr string) (uuid.UUID, *apiError) {
+	toSceneID, err := uuid.Parse(sceneIDStr)
+	if err != nil {
+		return uuid.Nil, &apiError{Status: http.StatusBadRequest, Message: "Invalid scene_id"}
+	}
+
+	if toSceneID == fromSceneID {
+		return uuid.Nil, &apiError{Status: http.StatusBadRequest, Message: "Cannot like your own scene"}
+	}
+
+	var exists bool
+	err = config.DB.QueryRow(
+		`SELECT EXISTS(
+			SELECT 1 FROM scenes s
+			JOIN personas p ON s.persona_id = p.id
+			WHERE s.id = $1 AND s.is_active = true AND s.expires_at > NOW()
+			AND NOT EXISTS (
+				SELECT 1 FROM user_blocks b
+				WHERE (b.blocker_user_id = $2 AND b.blocked_user_id = p.user_id)
+				   OR (b.blocker_user_id = p.user_id AND b.blocked_user_id = $2)
+			)
+		)`,
+		toSceneID, userID,
+	).Scan(&exists)
+	if err != nil || !exists {
+		return uuid.Nil, &apiError{Status: http.StatusNotFound, Message: "Scene not found or inactive"}
+	}
+
+	maxDistance := float64(config.GetInt("CHAT_MAX_DISTANCE", defaultChatMaxDistance))
+	if apiErr := checkSceneDistance(fromSceneID, toSceneID, maxDistance); apiErr != nil {
+		return uuid.Nil, apiErr
+	}
+
+	return toSceneID, nil
+}
+
+// LikeScene records a like on a nearby scene. Likes are invisible to the other side
+// until they like back; then an accepted chat is created and both get chat.match.
+func LikeScene(wsHub *websocket.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := middleware.GetUserID(c)
+
+		var req LikeSceneReq
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Get user's active scene
+		var fromSceneID uuid.UUID
+		err := config.DB.QueryRow(
+			`SELECT s.id FROM scenes s
+			 JOIN personas p ON s.persona_id = p.id
+			 WHERE p.user_id = $1 AND s.is_active = true AND s.expires_at > NOW()
+			 ORDER BY s.started_at DESC LIMIT 1`,
+			userID,
+		).Scan(&fromSceneID)
+
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No active scene found. Start a scene first."})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to get active scene: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get active scene"})
+			return
+		}
+
+		toSceneID, apiErr := likeTarget(userID, fromSceneID, req.SceneID)
+		if apiErr != nil {
+			apiErr.respond(c)
+			return
+		}
+
+		// Liking someone you're already talking to has no effect
+		var chatExists bool
+		err = config.DB.QueryRow(
+			`SELECT EXISTS(SELECT 1 FROM chat_requests
+			 WHERE ((from_scene_id = $1 AND to_scene_id = $2) OR (from_scene_id = $2 AND to_scene_id = $1))
+			 AND status IN ('pending', 'accepted'))`,
+			fromSceneID, toSceneID,
+		).Scan(&chatExists)
+		if err != nil {
+			log.Printf("Failed to check existing chat: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to like scene"})
+			return
+		}
+		if chatExists {
+			c.JSON(http.StatusConflict, gin.H{"error": "Chat request already exists between these scenes"})
+			return
+		}
+
+		match, err := recordLike(fromSceneID, toSceneID)
+		if err != nil {
+			log.Printf("Failed to record like: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to like scene"})
+			return
+		}
+
+		if match == nil {
+			c.JSON(http.StatusCreated, gin.H{"matched": false})
+			return
+		}
+
+		log.Printf("💞 Scenes %s and %s matched", match.FromSceneID, match.ToSceneID)
+		c.JSON(http.StatusCreated, notifyMatch(wsHub, match))
+	}
+}
+
+// chatMatch is the accepted chat created from two mutual likes
+type chatMatch struct {
+	chatRequestRef
+	ExpiresAt time.Time
+	FromKey   *string
+	ToKey     *string
+}
+
+func (m *chatMatch) e2e() bool {
+	return m.FromKey != nil && m.ToKey != nil
+}
+
+// recordLike stores fromSceneID's like on toSceneID. When toSceneID already liked back
+// and both sides have a free chat slot, both likes are consumed and an accepted chat
+// is created for the match; otherwise it returns nil. Both scenes are locked while this
+// runs, so two likes crossing each other produce exactly one match and a match can't
+// take a slot an accept is taking at the same time.
+func recordLike(fromSceneID, toSceneID uuid.UUID) (*chatMatch, error) {
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	fullSceneID, err := lockChatSlots(tx, fromSceneID, toSceneID)
+	if err != nil {
+		return nil, err
+	}
+
+	var reciprocal bool
+	err = tx.QueryRow(
+		`SELECT EXISTS(SELECT 1 FROM scene_likes WHERE from_scene_id = $1 AND to_scene_id = $2)`,
+		toSceneID, fromSceneID,
+	).Scan(&reciprocal)
+	if err != nil {
+		return nil, err
+	}
+
+	// A match waits while either side is busy; completeWaitingMatches completes it once
+	// a slot frees up
+	if !reciprocal || fullSceneID != uuid.Nil {
+		_, err = tx.Exec(
+			`INSERT INTO scene_likes (from_scene_id, to_scene_id) VALUES ($1, $2)
+			 ON CONFLICT (from_scene_id, to_scene_id) DO NOTHING`,
+			fromSceneID, toSceneID,
+		)
+		if err != nil {
+			return nil, err
+		}
+		return nil, tx.Commit()
+	}
+
+	// The scene that liked first is the chat's requester
+	match, err := matchLikesOn(tx, toSceneID, fromSceneID)
+	if err != nil {
+		return nil, err
+	}
+	return match, tx.Commit()
+}
+
+// matchLikesOn consumes two mutual likes within tx and creates the accepted chat for
+// them, with requester as the side that liked first. Callers must hold both scenes'
+// chat slots through lockChatSlots.
+func matchLikesOn(tx *sql.Tx, requester, recipient uuid.UUID) (*chatMatch, error) {
+	_, err := tx.Exec(
+		`DELETE FROM scene_likes
+		 WHERE (from_scene_id = $1 AND to_scene_id = $2) OR (from_scene_id = $2 AND to_scene_id = $1)`,
+		requester, recipient,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	match := &chatMatch{chatRequestRef: chatRequestRef{ID: uuid.New(), FromSceneID: requester, ToSceneID: recipient}}
+	match.FromKey, match.ToKey, err = scenePublicKeys(match.FromSceneID, match.ToSceneID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	match.ExpiresAt = now.Add(config.GetDuration("CHAT_DURATION", defaultChatDuration))
+	_, err = tx.Exec(
+		`INSERT INTO chat_requests (id, from_scene_id, to_scene_id, status, expires_at, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6)`,
+		match.ID, match.FromSceneID, match.ToSceneID, chatStatusPending, match.ExpiresAt, now,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = transitionChatRequestsOn(tx, chatStatusPending, chatStatusAccepted, `id = $3`,
+		`accepted_at = $4, expires_at = $5, e2e = $6`, match.ID, now, match.ExpiresAt, match.e2e())
+	if err != nil {
+		return nil, err
+	}
+
+	countSceneActivity(tx, recapRequestsSent, requester)
+	countSceneActivity(tx, recapRequestsReceived, recipient)
+	countSceneActivity(tx, recapChatsAccepted, requester, recipient)
+	return match, nil
+}
+
+// completeWaitingMatches completes the mutual likes involving the given scenes that
+// were stored while a chat slot was full. It runs whenever a chat of those scenes ends
+// or expires, so a match doesn't wait for either side to like again.
+func completeWaitingMatches(wsHub *websocket.Hub, sceneIDs ...uuid.UUID) {
+	if len(sceneIDs) == 0 {
+		return
+	}
+	ids := make([]string, len(sceneIDs))
+	for i, id := range sceneIDs {
+		ids[i] = id.String()
+	}
+
+	// Each pair once, as the older like, and only while both scenes are still live and
+	// not already talking
+	rows, err := config.DB.Query(
+		`SELECT a.from_scene_id, a.to_scene_id FROM scene_likes a
+		 JOIN scene_likes b ON b.from_scene_id = a.to_scene_id AND b.to_scene_id = a.from_scene_id
+		 JOIN scenes sa ON sa.id = a.from_scene_id
+		 JOIN scenes sb ON sb.id = a.to_scene_id
+		 WHERE (a.from_scene_id = ANY($1::uuid[]) OR a.to_scene_id = ANY($1::uuid[]))
+		 AND (a.created_at, a.from_scene_id) < (b.created_at, b.from_scene_id)
+		 AND sa.is_active = true AND sa.expires_at > NOW()
+		 AND sb.is_active = true AND sb.expires_at > NOW()
+		 AND NOT EXISTS (
+			SELECT 1 FROM chat_requests cr
+			WHERE ((cr.from_scene_id = a.from_scene_id AND cr.to_scene_id = a.to_scene_id)
+			    OR (cr.from_scene_id = a.to_scene_id AND cr.to_scene_id = a.from_scene_id))
+			AND cr.status IN ('pending', 'accepted')
+		 )
+		 ORDER BY a.created_at`,
+		ids,
+	)
+	if err != nil {
+		log.Printf("Failed to query waiting matches: %v", err)
+		return
+	}
+
+	var pairs [][2]uuid.UUID
+	for rows.Next() {
+		var pair [2]uuid.UUID
+		if err := rows.Scan(&pair[0], &pair[1]); err == nil {
+			pairs = append(pairs, pair)
+		}
+	}
+	rows.Close()
+
+	for _, pair := range pairs {
+		match, err := completeWaitingMatch(pair[0], pair[1])
+		if err != nil {
+			log.Printf("Failed to complete match between %s and %s: %v", pair[0], pair[1], err)
+			continue
+		}
+		if match != nil {
+			log.Printf("💞 Scenes %s and %s matched once a chat slot freed up", match.FromSceneID, match.ToSceneID)
+			notifyMatch(wsHub, match)
+		}
+	}
+}
+
+// completeWaitingMatch matches requester and recipient if both still like each other
+// and have a free chat slot, or returns nil
+func completeWaitingMatch(requester, recipient uuid.UUID) (*chatMatch, error) {
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	fullSceneID, err := lockChatSlots(tx, requester, recipient)
+	if err != nil || fullSceneID != uuid.Nil {
+		return nil, err
+	}
+
+	// An earlier pair in the same run, an unlike or a block may have got there first
+	var likes int
+	err = tx.QueryRow(
+		`SELECT COUNT(*) FROM scene_likes
+		 WHERE (from_scene_id = $1 AND to_scene_id = $2) OR (from_scene_id = $2 AND to_scene_id = $1)`,
+		requester, recipient,
+	).Scan(&likes)
+	if err != nil || likes < 2 {
+		return nil, err
+	}
+
+	match, err := matchLikesOn(tx, requester, recipient)
+	if err != nil {
+		return nil, err
+	}
+	return match, tx.Commit()
+}
+
+// notifyMatch sends both sides of a new match chat.match and returns the response body
+func notifyMatch(wsHub *websocket.Hub, match *chatMatch) gin.H {
+	e2e := match.e2e()
+	matchMsg := websocket.Message{
+		Type: "chat.match",
+		Data: map[string]interface{}{
+			"request_id":    match.ID.String(),
+			"expires_at":    match.ExpiresAt.Format(time.RFC3339),
+			"from_scene_id": match.FromSceneID.String(),
+			"to_scene_id":   match.ToSceneID.String(),
+			"e2e":           e2e,
+		},
+	}
+	if e2e {
+		matchMsg.Data["from_public_key"] = *match.FromKey
+		matchMsg.Data["to_public_key"] = *match.ToKey
+	}
+	wsHub.Targeted <- websocket.TargetedMessage{TargetSceneID: match.FromSceneID, Message: matchMsg}
+	wsHub.Targeted <- websocket.TargetedMessage{TargetSceneID: match.ToSceneID, Message: matchMsg}
+
+	resp := gin.H{
+		"matched":    true,
+		"request_id": match.ID.String(),
+		"expires_at": match.ExpiresAt,
+		"e2e":        e2e,
+	}
+	if e2e {
+		resp["from_public_key"] = *match.FromKey
+	}
+	return resp
+}
+
+// UnlikeScene takes back a like that was not reciprocated yet
+func UnlikeScene(c *gin.Context) {
+	userID, _ := middleware.GetUserID(c)
+
+	toSceneID, err := uuid.Parse(c.Param("scene_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid scene_id"})
+		return
+	}
+
+	result, err := config.DB.Exec(
+		`DELETE FROM scene_likes
+		 WHERE to_scene_id = $2 AND from_scene_id IN (
+			SELECT s.id FROM scenes s
+			JOIN personas p ON s.persona_id = p.id
+			WHERE p.user_id = $1
+		 )`,
+		userID, toSceneID,
+	)
+	if err != nil {
+		log.Printf("Failed to unlike scene: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlike scene"})
+		return
+	}
+
+	if count, _ := result.RowsAffected(); count == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Like not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Like removed"})
+}
+
+// GetLikes lists the scenes the caller's active scene liked. Likes received are never listed.
+func GetLikes(c *gin.Context) {
+	userID, _ := middleware.GetUserID(c)
+
+	rows, err := config.DB.Query(
+		`SELECT l.to_scene_id, l.created_at FROM scene_likes l
+		 JOIN scenes s ON l.from_scene_id = s.id
+		 JOIN personas p ON s.persona_id = p.id
+		 WHERE p.user_id = $1 AND s.is_active = true AND s.expires_at > NOW()
+		 ORDER BY l.created_at DESC`,
+		userID,
+	)
+	if err != nil {
+		log.Printf("Failed to get likes: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get likes"})
+		return
+	}
+	defer rows.Close()
+
+	likes := []gin.H{}
+	for rows.Next() {
+		var sceneID uuid.UUID
+		var createdAt time.Time
+		if err := rows.Scan(&sceneID, &createdAt); err != nil {
+			log.Printf("Failed to scan like: %v", err)
+			continue
+		}
+		likes = append(likes, gin.H{"scene_id": sceneID.String(), "created_at": createdAt})
+	}
+
+	c.JSON(http.StatusOK, likes)
+}
+
+// deleteSceneLikes drops every like given or received by a scene that ended
+func deleteSceneLikes(sceneID uuid.UUID) {
+	_, err := config.DB.Exec(
+		`DELETE FROM scene_likes WHERE from_scene_id = $1 OR to_scene_id = $1`,
+		sceneID,
+	)
+	if err != nil {
+		log.Printf("Failed to delete likes of scene %s: %v", sceneID, err)
+	}
+}
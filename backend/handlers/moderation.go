@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"net/http"
+	"scene-on/backend/moderation"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// moderateText runs user text through the moderator. On rejection it responds with
+// 422 and the moderator's code and returns false; otherwise it returns the text to
+// store, which may have been masked.
+func moderateText(c *gin.Context, mod moderation.Moderator, ctx moderation.Context, userID uuid.UUID, text string) (string, bool) {
+	if text == "" {
+		return text, true
+	}
+
+	result := mod.Moderate(moderation.Input{Context: ctx, Text: text, UserID: userID})
+	if result.Action == moderation.Reject {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error": result.Reason,
+			"code":  result.Code,
+		})
+		return "", false
+	}
+	return result.Text, true
+}
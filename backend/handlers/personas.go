@@ -7,9 +7,13 @@ import (
 	"scene-on/backend/config"
 	"scene-on/backend/middleware"
 	"scene-on/backend/models"
+	"scene-on/backend/moderation"
+	"scene-on/backend/storage"
+	"scene-on/backend/websocket"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 )
 
 type CreatePersonaRequest struct {
@@ -18,83 +22,159 @@ type CreatePersonaRequest struct {
 	Description string `json:"description"`
 }
 
-// GetOrCreatePersona gets or creates a persona for the user
-func GetOrCreatePersona(c *gin.Context) {
-	log.Println("📥 GetOrCreatePersona hit")
-	userID, _ := middleware.GetUserID(c)
+// UpdatePersonaRequest is a partial update; fields left out keep their value
+type UpdatePersonaRequest struct {
+	Name        *string `json:"name"`
+	AvatarURL   *string `json:"avatar_url"`
+	Description *string `json:"description"`
+}
 
-	var req CreatePersonaRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		log.Printf("❌ Failed to bind persona request: %v", err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-	log.Printf("👤 Persona request for name: %s (UserID: %v)", req.Name, userID)
+// defaultMaxPersonas caps how many personas a user may keep (MAX_PERSONAS)
+const defaultMaxPersonas = 5
 
-	// CRITICAL: Check if user actually exists in the users table
-	// This prevents the foreign key constraint violation (500 error)
-	var userExists bool
-	err := config.DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE id = $1)`, userID).Scan(&userExists)
-	if err != nil {
-		log.Printf("❌ Failed to check user existence: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error checking user"})
-		return
-	}
+// personaNameTaken reports whether another persona, of any user, already uses name.
+// We use ILIKE for case-insensitive uniqueness to avoid "Neo" vs "neo" confusion.
+func personaNameTaken(name string, excludeID uuid.UUID) (bool, error) {
+	var taken bool
+	err := config.DB.QueryRow(
+		`SELECT EXISTS(SELECT 1 FROM personas WHERE name ILIKE $1 AND id != $2)`,
+		name, excludeID,
+	).Scan(&taken)
+	return taken, err
+}
 
-	if !userExists {
-		log.Printf("⚠️ UserID %v from token does not exist in database! (Stale token?)", userID)
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"error": "User record not found. Please log out and log in again to refresh your account.",
-			"code":  "USER_NOT_FOUND",
-		})
-		return
+// moderatePersona screens a persona's name and description. Names are rejected outright
+// when they fail moderation; descriptions may be masked.
+func moderatePersona(c *gin.Context, mod moderation.Moderator, userID uuid.UUID, req *CreatePersonaRequest) bool {
+	var ok bool
+	if req.Name, ok = moderateText(c, mod, moderation.ContextPersonaName, userID, req.Name); !ok {
+		return false
 	}
+	if req.Description, ok = moderateText(c, mod, moderation.ContextPersonaBio, userID, req.Description); !ok {
+		return false
+	}
+	return true
+}
 
-	// Check uniqueness of persona name across ALL users (ignore case maybe? let's stick to exact for now or ILIKE)
-	// We use ILIKE for case-insensitive uniqueness to avoid "Neo" vs "neo" confusion
-	var nameTaken bool
-	err = config.DB.QueryRow(
-		`SELECT EXISTS(SELECT 1 FROM personas WHERE name ILIKE $1 AND id != $2)`,
-		req.Name, userID,
-	).Scan(&nameTaken)
-
+// respondNameTaken checks name uniqueness and writes the error response when it fails
+func respondNameTaken(c *gin.Context, name string, excludeID uuid.UUID) bool {
+	taken, err := personaNameTaken(name, excludeID)
 	if err != nil {
 		log.Printf("❌ Failed to check name uniqueness: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error checking name"})
-		return
+		return true
 	}
-
-	if nameTaken {
+	if taken {
 		c.JSON(http.StatusConflict, gin.H{
-			"error": "Persona name '" + req.Name + "' is already taken. Please choose another.",
+			"error": "Persona name '" + name + "' is already taken. Please choose another.",
 			"code":  "NAME_TAKEN",
 		})
-		return
+		return true
 	}
+	return false
+}
 
-	// Check if user already has a persona (using userID as personaID)
+// loadOwnedPersona loads the persona in the :id param, answering 404 unless it belongs to userID
+func loadOwnedPersona(c *gin.Context, userID uuid.UUID) (models.Persona, bool) {
 	var persona models.Persona
+	personaID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid persona id"})
+		return persona, false
+	}
+
 	err = config.DB.QueryRow(
 		`SELECT id, user_id, name, avatar_url, description, stats, is_active, created_at, updated_at
 		 FROM personas
-		 WHERE id = $1
-		 LIMIT 1`,
-		userID,
+		 WHERE id = $1 AND user_id = $2`,
+		personaID, userID,
 	).Scan(&persona.ID, &persona.UserID, &persona.Name, &persona.AvatarURL, &persona.Description,
 		&persona.Stats, &persona.IsActive, &persona.CreatedAt, &persona.UpdatedAt)
 
 	if err == sql.ErrNoRows {
-		// Create new persona with ID = userID
-		persona = models.Persona{
-			ID:          userID,
+		c.JSON(http.StatusNotFound, gin.H{"error": "Persona not found"})
+		return persona, false
+	}
+	if err != nil {
+		log.Printf("Failed to get persona: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get persona"})
+		return persona, false
+	}
+	return persona, true
+}
+
+// CreatePersona adds a persona for the user, up to MAX_PERSONAS
+func CreatePersona(mod moderation.Moderator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := middleware.GetUserID(c)
+
+		var req CreatePersonaRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			log.Printf("❌ Failed to bind persona request: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("👤 Persona request for name: %s (UserID: %v)", req.Name, userID)
+
+		if !moderatePersona(c, mod, userID, &req) {
+			return
+		}
+		if apiErr := validateAvatarURL(req.AvatarURL, uuid.Nil); apiErr != nil {
+			apiErr.respond(c)
+			return
+		}
+
+		// CRITICAL: Check if user actually exists in the users table
+		// This prevents the foreign key constraint violation (500 error)
+		var userExists bool
+		err := config.DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE id = $1)`, userID).Scan(&userExists)
+		if err != nil {
+			log.Printf("❌ Failed to check user existence: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error checking user"})
+			return
+		}
+
+		if !userExists {
+			log.Printf("⚠️ UserID %v from token does not exist in database! (Stale token?)", userID)
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "User record not found. Please log out and log in again to refresh your account.",
+				"code":  "USER_NOT_FOUND",
+			})
+			return
+		}
+
+		if maxPersonas := config.GetInt("MAX_PERSONAS", defaultMaxPersonas); maxPersonas > 0 {
+			var count int
+			err = config.DB.QueryRow(`SELECT COUNT(*) FROM personas WHERE user_id = $1`, userID).Scan(&count)
+			if err != nil {
+				log.Printf("❌ Failed to count personas: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create persona"})
+				return
+			}
+			if count >= maxPersonas {
+				c.JSON(http.StatusConflict, gin.H{
+					"error": "You already have the maximum number of personas. Delete one first.",
+					"code":  "PERSONA_LIMIT_REACHED",
+				})
+				return
+			}
+		}
+
+		if respondNameTaken(c, req.Name, uuid.Nil) {
+			return
+		}
+
+		now := time.Now()
+		persona := models.Persona{
+			ID:          uuid.New(),
 			UserID:      userID,
 			Name:        req.Name,
 			AvatarURL:   req.AvatarURL,
 			Description: req.Description,
 			Stats:       models.JSONB{},
 			IsActive:    true,
-			CreatedAt:   time.Now(),
-			UpdatedAt:   time.Now(),
+			CreatedAt:   now,
+			UpdatedAt:   now,
 		}
 
 		_, err = config.DB.Exec(
@@ -103,7 +183,6 @@ func GetOrCreatePersona(c *gin.Context) {
 			persona.ID, persona.UserID, persona.Name, persona.AvatarURL, persona.Description,
 			persona.Stats, persona.IsActive, persona.CreatedAt, persona.UpdatedAt,
 		)
-
 		if err != nil {
 			log.Printf("Failed to create persona: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create persona"})
@@ -111,33 +190,255 @@ func GetOrCreatePersona(c *gin.Context) {
 		}
 
 		log.Printf("✓ Created persona %s for user %s", persona.Name, userID)
-	} else if err == nil {
-		// Update existing persona
-		persona.Name = req.Name
-		persona.AvatarURL = req.AvatarURL
-		persona.Description = req.Description
-		persona.UpdatedAt = time.Now()
+		c.JSON(http.StatusCreated, persona)
+	}
+}
 
-		_, err = config.DB.Exec(
-			`UPDATE personas SET name = $1, avatar_url = $2, description = $3, updated_at = $4 WHERE id = $5`,
-			persona.Name, persona.AvatarURL, persona.Description, persona.UpdatedAt, persona.ID,
-		)
+// UpdatePersona edits one of the user's personas; only the fields sent are changed. If
+// it is on the active scene, chat partners see the change through scene.persona_changed.
+func UpdatePersona(wsHub *websocket.Hub, blobs storage.BlobStore, mod moderation.Moderator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := middleware.GetUserID(c)
+
+		persona, ok := loadOwnedPersona(c, userID)
+		if !ok {
+			return
+		}
 
+		var req UpdatePersonaRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if req.Name != nil {
+			if *req.Name == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "name cannot be empty"})
+				return
+			}
+			if persona.Name, ok = moderateText(c, mod, moderation.ContextPersonaName, userID, *req.Name); !ok {
+				return
+			}
+			if respondNameTaken(c, persona.Name, persona.ID) {
+				return
+			}
+		}
+		if req.Description != nil {
+			if persona.Description, ok = moderateText(c, mod, moderation.ContextPersonaBio, userID, *req.Description); !ok {
+				return
+			}
+		}
+		avatarChanged := req.AvatarURL != nil && *req.AvatarURL != persona.AvatarURL
+		if avatarChanged {
+			if apiErr := validateAvatarURL(*req.AvatarURL, persona.ID); apiErr != nil {
+				apiErr.respond(c)
+				return
+			}
+			persona.AvatarURL = *req.AvatarURL
+		}
+		persona.UpdatedAt = time.Now()
+
+		// Columns that weren't sent are left alone, so this can't undo a concurrent change
+		// such as an avatar upload
+		var name, avatar, description *string
+		if req.Name != nil {
+			name = &persona.Name
+		}
+		if avatarChanged {
+			avatar = &persona.AvatarURL
+		}
+		if req.Description != nil {
+			description = &persona.Description
+		}
+		oldKeys, err := updatePersona(&persona, name, avatar, description)
 		if err != nil {
 			log.Printf("Failed to update persona: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update persona"})
 			return
 		}
 
+		deleteAvatarBlobs(blobs, oldKeys)
+		notifyActivePersonaChanged(wsHub, persona)
+
 		log.Printf("✓ Updated persona %s for user %s", persona.Name, userID)
-	} else {
-		log.Printf("Failed to query persona: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get persona"})
+		c.JSON(http.StatusOK, persona)
+	}
+}
+
+// updatePersona writes the non-nil fields and reloads the stored ones into persona.
+// When the avatar changes, uploaded avatars it no longer shows are deleted while the
+// row is still locked (see setPersonaAvatar) and their blob keys are returned.
+func updatePersona(persona *models.Persona, name, avatar, description *string) ([]string, error) {
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(
+		`UPDATE personas
+		 SET name = COALESCE($1, name), avatar_url = COALESCE($2, avatar_url),
+		     description = COALESCE($3, description), updated_at = $4
+		 WHERE id = $5
+		 RETURNING name, avatar_url, description`,
+		name, avatar, description, persona.UpdatedAt, persona.ID,
+	).Scan(&persona.Name, &persona.AvatarURL, &persona.Description)
+	if err != nil {
+		return nil, err
+	}
+
+	var oldKeys []string
+	if avatar != nil {
+		if oldKeys, err = deletePersonaAvatars(tx, persona.ID, avatarIDFromURL(persona.AvatarURL)); err != nil {
+			return nil, err
+		}
+	}
+	return oldKeys, tx.Commit()
+}
+
+// DeletePersona removes one of the user's personas along with its past scenes. The
+// persona of a running scene can't be deleted; switch or stop the scene first.
+func DeletePersona(blobs storage.BlobStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := middleware.GetUserID(c)
+
+		persona, ok := loadOwnedPersona(c, userID)
+		if !ok {
+			return
+		}
+
+		var inUse bool
+		err := config.DB.QueryRow(
+			`SELECT EXISTS(SELECT 1 FROM scenes WHERE persona_id = $1 AND is_active = true AND expires_at > NOW())`,
+			persona.ID,
+		).Scan(&inUse)
+		if err != nil {
+			log.Printf("Failed to check persona scenes: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete persona"})
+			return
+		}
+		if inUse {
+			c.JSON(http.StatusConflict, gin.H{
+				"error": "This persona is on your active scene. Switch persona or stop the scene first.",
+				"code":  "PERSONA_IN_USE",
+			})
+			return
+		}
+
+		// Deleting the persona deletes its scenes' chats, which the other side may still be
+		// exporting
+		var exportable bool
+		err = config.DB.QueryRow(
+			`SELECT EXISTS(SELECT 1 FROM chat_requests cr
+			 JOIN scenes s ON s.id = cr.from_scene_id OR s.id = cr.to_scene_id
+			 WHERE s.persona_id = $1
+			 AND cr.status IN ('accepted', 'expired', 'ended')
+			 AND cr.export_consent_from AND cr.export_consent_to
+			 AND cr.expires_at > $2)`,
+			persona.ID, time.Now().Add(-chatExportGrace()),
+		).Scan(&exportable)
+		if err != nil {
+			log.Printf("Failed to check persona chats: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete persona"})
+			return
+		}
+		if exportable {
+			c.JSON(http.StatusConflict, gin.H{
+				"error": "A chat of this persona can still be exported. Try again in a few minutes.",
+				"code":  "PERSONA_CHATS_EXPORTABLE",
+			})
+			return
+		}
+
+		// The chats cascade away with the persona's scenes, so remove attachment blobs first
+		purgeChatAttachments(blobs,
+			`chat_request_id IN (
+				SELECT cr.id FROM chat_requests cr
+				JOIN scenes s ON s.id = cr.from_scene_id OR s.id = cr.to_scene_id
+				WHERE s.persona_id = $1
+			 )`,
+			persona.ID,
+		)
+
+		// A blob that couldn't be deleted keeps its row; deleting now would orphan it
+		var leftover bool
+		err = config.DB.QueryRow(
+			`SELECT EXISTS(SELECT 1 FROM chat_attachments ca
+			 JOIN chat_requests cr ON ca.chat_request_id = cr.id
+			 JOIN scenes s ON s.id = cr.from_scene_id OR s.id = cr.to_scene_id
+			 WHERE s.persona_id = $1)`,
+			persona.ID,
+		).Scan(&leftover)
+		if err != nil || leftover {
+			log.Printf("Failed to remove chat attachments of persona %s: %v", persona.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete persona"})
+			return
+		}
+
+		purgePersonaAvatars(blobs, persona.ID, uuid.Nil)
+
+		if _, err := config.DB.Exec(`DELETE FROM personas WHERE id = $1`, persona.ID); err != nil {
+			log.Printf("Failed to delete persona: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete persona"})
+			return
+		}
+
+		log.Printf("🗑️ Deleted persona %s for user %s", persona.Name, userID)
+		c.JSON(http.StatusOK, gin.H{"message": "Persona deleted"})
+	}
+}
+
+// notifyActivePersonaChanged sends scene.persona_changed for the active scene showing
+// persona, if there is one
+func notifyActivePersonaChanged(wsHub *websocket.Hub, persona models.Persona) {
+	var sceneID uuid.UUID
+	err := config.DB.QueryRow(
+		`SELECT id FROM scenes
+		 WHERE persona_id = $1 AND is_active = true AND expires_at > NOW()
+		 ORDER BY started_at DESC LIMIT 1`,
+		persona.ID,
+	).Scan(&sceneID)
+	if err == nil {
+		notifyPersonaChanged(wsHub, sceneID, persona)
+	}
+}
+
+// notifyPersonaChanged tells a scene's own clients and its accepted chat partners which
+// persona the scene now shows
+func notifyPersonaChanged(wsHub *websocket.Hub, sceneID uuid.UUID, persona models.Persona) {
+	changedMsg := websocket.Message{
+		Type: "scene.persona_changed",
+		Data: map[string]interface{}{
+			"scene_id":            sceneID.String(),
+			"persona_id":          persona.ID.String(),
+			"persona_name":        persona.Name,
+			"persona_avatar":      persona.AvatarURL,
+			"persona_description": persona.Description,
+		},
+	}
+	wsHub.Targeted <- websocket.TargetedMessage{TargetSceneID: sceneID, Message: changedMsg}
+
+	rows, err := config.DB.Query(
+		`SELECT CASE WHEN from_scene_id = $1 THEN to_scene_id ELSE from_scene_id END
+		 FROM chat_requests
+		 WHERE (from_scene_id = $1 OR to_scene_id = $1)
+		 AND status = 'accepted' AND expires_at > NOW()`,
+		sceneID,
+	)
+	if err != nil {
+		log.Printf("Failed to get chat partners of scene %s: %v", sceneID, err)
 		return
 	}
+	defer rows.Close()
 
-	log.Printf("📤 Returning persona: %+v", persona)
-	c.JSON(http.StatusOK, persona)
+	for rows.Next() {
+		var partnerSceneID uuid.UUID
+		if err := rows.Scan(&partnerSceneID); err != nil {
+			log.Printf("Failed to scan chat partner: %v", err)
+			continue
+		}
+		wsHub.Targeted <- websocket.TargetedMessage{TargetSceneID: partnerSceneID, Message: changedMsg}
+	}
 }
 
 // GetUserPersonas returns all personas for the current user
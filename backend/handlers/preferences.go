@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"scene-on/backend/config"
+	"scene-on/backend/middleware"
+	"scene-on/backend/models"
+	"scene-on/backend/websocket"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type UpdateNotificationPreferencesRequest struct {
+	RadiusMeters    int      `json:"radius_meters" binding:"required,min=100,max=50000"`
+	MutedEvents     []string `json:"muted_events"`
+	QuietHoursStart *string  `json:"quiet_hours_start"`
+	QuietHoursEnd   *string  `json:"quiet_hours_end"`
+	Timezone        string   `json:"timezone"`
+}
+
+// GetNotificationPreferences returns the user's notification preferences (defaults if unset)
+func GetNotificationPreferences(c *gin.Context) {
+	userID, _ := middleware.GetUserID(c)
+
+	prefs := models.NotificationPreferences{
+		UserID:       userID,
+		RadiusMeters: websocket.DefaultNotificationRadius,
+		MutedEvents:  []string{},
+		Timezone:     "UTC",
+	}
+
+	var mutedRaw []byte
+	err := config.DB.QueryRow(
+		`SELECT radius_meters, muted_events, quiet_hours_start, quiet_hours_end, timezone, updated_at
+		 FROM notification_preferences WHERE user_id = $1`,
+		userID,
+	).Scan(&prefs.RadiusMeters, &mutedRaw, &prefs.QuietHoursStart, &prefs.QuietHoursEnd,
+		&prefs.Timezone, &prefs.UpdatedAt)
+
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusOK, prefs)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to get notification preferences: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get notification preferences"})
+		return
+	}
+
+	if err := json.Unmarshal(mutedRaw, &prefs.MutedEvents); err != nil || prefs.MutedEvents == nil {
+		prefs.MutedEvents = []string{}
+	}
+
+	c.JSON(http.StatusOK, prefs)
+}
+
+// UpdateNotificationPreferences stores the user's notification preferences and applies
+// them to any live WebSocket connections of the user's active scene
+func UpdateNotificationPreferences(wsHub *websocket.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := middleware.GetUserID(c)
+
+		var req UpdateNotificationPreferencesRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if req.MutedEvents == nil {
+			req.MutedEvents = []string{}
+		}
+		for _, event := range req.MutedEvents {
+			if !websocket.MutableEvents[event] {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Event type '" + event + "' cannot be muted"})
+				return
+			}
+		}
+
+		if (req.QuietHoursStart == nil) != (req.QuietHoursEnd == nil) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "quiet_hours_start and quiet_hours_end must be set together"})
+			return
+		}
+		for _, clock := range []*string{req.QuietHoursStart, req.QuietHoursEnd} {
+			if clock == nil {
+				continue
+			}
+			if _, err := websocket.ParseClock(*clock); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		if req.Timezone == "" {
+			req.Timezone = "UTC"
+		}
+		if _, err := time.LoadLocation(req.Timezone); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid timezone"})
+			return
+		}
+
+		mutedJSON, _ := json.Marshal(req.MutedEvents)
+		now := time.Now().UTC()
+
+		_, err := config.DB.Exec(
+			`INSERT INTO notification_preferences
+			     (user_id, radius_meters, muted_events, quiet_hours_start, quiet_hours_end, timezone, updated_at)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7)
+			 ON CONFLICT (user_id) DO UPDATE SET
+			     radius_meters = EXCLUDED.radius_meters,
+			     muted_events = EXCLUDED.muted_events,
+			     quiet_hours_start = EXCLUDED.quiet_hours_start,
+			     quiet_hours_end = EXCLUDED.quiet_hours_end,
+			     timezone = EXCLUDED.timezone,
+			     updated_at = EXCLUDED.updated_at`,
+			userID, req.RadiusMeters, string(mutedJSON), req.QuietHoursStart, req.QuietHoursEnd,
+			req.Timezone, now,
+		)
+		if err != nil {
+			log.Printf("Failed to update notification preferences: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update notification preferences"})
+			return
+		}
+
+		// Refresh preferences on connected clients of the user's active scene
+		var sceneID uuid.UUID
+		err = config.DB.QueryRow(
+			`SELECT s.id FROM scenes s
+			 JOIN personas p ON s.persona_id = p.id
+			 WHERE p.user_id = $1 AND s.is_active = true AND s.expires_at > NOW()
+			 ORDER BY s.started_at DESC LIMIT 1`,
+			userID,
+		).Scan(&sceneID)
+		if err == nil {
+			wsHub.SetScenePreferences(sceneID, websocket.LoadScenePreferences(sceneID))
+		}
+
+		c.JSON(http.StatusOK, models.NotificationPreferences{
+			UserID:          userID,
+			RadiusMeters:    req.RadiusMeters,
+			MutedEvents:     req.MutedEvents,
+			QuietHoursStart: req.QuietHoursStart,
+			QuietHoursEnd:   req.QuietHoursEnd,
+			Timezone:        req.Timezone,
+			UpdatedAt:       now,
+		})
+	}
+}
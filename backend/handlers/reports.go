@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"scene-on/backend/config"
+	"scene-on/backend/middleware"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// reportReasons are the accepted values of CreateReportReq.Reason
+var reportReasons = map[string]bool{
+	"spam":          true,
+	"harassment":    true,
+	"inappropriate": true,
+	"impersonation": true,
+	"other":         true,
+}
+
+// CreateReportReq reports a persona, either directly or through a chat with it.
+// Content is the reporter's own snapshot of the offending content.
+type CreateReportReq struct {
+	Reason     string   `json:"reason" binding:"required"`
+	PersonaID  string   `json:"persona_id,omitempty"`
+	RequestID  string   `json:"request_id,omitempty"`
+	MessageIDs []string `json:"message_ids,omitempty"`
+	Content    string   `json:"content,omitempty"`
+	Details    string   `json:"details,omitempty"`
+}
+
+type reportedMessage struct {
+	ID          uuid.UUID `json:"id"`
+	FromSceneID uuid.UUID `json:"from_scene_id"`
+	Content     string    `json:"content"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// CreateReport files an abuse report against a persona's user
+func CreateReport(c *gin.Context) {
+	userID, _ := middleware.GetUserID(c)
+
+	var req CreateReportReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !reportReasons[req.Reason] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid reason", "code": "INVALID_REASON"})
+		return
+	}
+
+	if req.PersonaID == "" && req.RequestID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "persona_id or request_id is required"})
+		return
+	}
+
+	messageIDs := make([]uuid.UUID, 0, len(req.MessageIDs))
+	for _, idStr := range req.MessageIDs {
+		id, err := uuid.Parse(idStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message id: " + idStr})
+			return
+		}
+		messageIDs = append(messageIDs, id)
+	}
+
+	var reportedUserID uuid.UUID
+	var chatRequestID *uuid.UUID
+
+	if req.RequestID != "" {
+		reqUUID, err := uuid.Parse(req.RequestID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request_id"})
+			return
+		}
+
+		// The reporter must be on one side of the chat; the other side is reported
+		var fromUserID, toUserID uuid.UUID
+		err = config.DB.QueryRow(
+			`SELECT fp.user_id, tp.user_id
+			 FROM chat_requests cr
+			 JOIN scenes fs ON cr.from_scene_id = fs.id
+			 JOIN personas fp ON fs.persona_id = fp.id
+			 JOIN scenes ts ON cr.to_scene_id = ts.id
+			 JOIN personas tp ON ts.persona_id = tp.id
+			 WHERE cr.id = $1`,
+			reqUUID,
+		).Scan(&fromUserID, &toUserID)
+
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Chat not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to get chat request: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get chat"})
+			return
+		}
+
+		switch userID {
+		case fromUserID:
+			reportedUserID = toUserID
+		case toUserID:
+			reportedUserID = fromUserID
+		default:
+			c.JSON(http.StatusForbidden, gin.H{"error": "You are not part of this chat"})
+			return
+		}
+		chatRequestID = &reqUUID
+	} else {
+		personaID, err := uuid.Parse(req.PersonaID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid persona_id"})
+			return
+		}
+
+		err = config.DB.QueryRow(`SELECT user_id FROM personas WHERE id = $1`, personaID).Scan(&reportedUserID)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Persona not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to get persona: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get persona"})
+			return
+		}
+	}
+
+	if reportedUserID == userID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot report yourself"})
+		return
+	}
+
+	// Keep a server-side copy of the reported messages, since chats are deleted when they end
+	messages := []reportedMessage{}
+	if chatRequestID != nil && len(messageIDs) > 0 {
+		ids := make([]string, len(messageIDs))
+		for i, id := range messageIDs {
+			ids[i] = id.String()
+		}
+
+		rows, err := config.DB.Query(
+			`SELECT id, from_scene_id, content, created_at
+			 FROM chat_messages
+			 WHERE chat_request_id = $1 AND id = ANY($2::uuid[])
+			 ORDER BY created_at ASC`,
+			*chatRequestID, ids,
+		)
+		if err != nil {
+			log.Printf("Failed to snapshot reported messages: %v", err)
+		} else {
+			for rows.Next() {
+				var msg reportedMessage
+				if err := rows.Scan(&msg.ID, &msg.FromSceneID, &msg.Content, &msg.CreatedAt); err != nil {
+					log.Printf("Failed to scan reported message: %v", err)
+					continue
+				}
+				messages = append(messages, msg)
+			}
+			rows.Close()
+		}
+	}
+
+	snapshot, _ := json.Marshal(map[string]interface{}{
+		"reporter_content": req.Content,
+		"messages":         messages,
+	})
+	messageIDsJSON, _ := json.Marshal(messageIDs)
+
+	var reportID uuid.UUID
+	err := config.DB.QueryRow(
+		`INSERT INTO reports (reporter_user_id, reported_user_id, reason, details, chat_request_id, message_ids, content_snapshot)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)
+		 RETURNING id`,
+		userID, reportedUserID, req.Reason, req.Details, chatRequestID, string(messageIDsJSON), string(snapshot),
+	).Scan(&reportID)
+	if err != nil {
+		log.Printf("Failed to create report: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to submit report"})
+		return
+	}
+
+	log.Printf("🚩 User %s reported user %s (%s)", userID, reportedUserID, req.Reason)
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Report submitted",
+		"id":      reportID,
+	})
+}
@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"scene-on/backend/config"
+	"scene-on/backend/geo"
+	"scene-on/backend/middleware"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// defaultChatMaxDistance is how far apart two scenes may be for a chat request, in
+	// meters (CHAT_MAX_DISTANCE). It matches the default radius of the nearby list.
+	defaultChatMaxDistance = 50000
+	// defaultInviteMaxDistance replaces it for requests made through an invite link,
+	// in meters; 0 means no limit (CHAT_INVITE_MAX_DISTANCE)
+	defaultInviteMaxDistance = 0
+	// defaultSceneInviteTTL is how long an invite link stays valid (SCENE_INVITE_TTL)
+	defaultSceneInviteTTL = time.Hour
+)
+
+var errInvalidInvite = errors.New("invalid or expired invite")
+
+// sceneInviteSecret signs invite tokens (SCENE_INVITE_SECRET, falling back to JWT_SECRET)
+func sceneInviteSecret() []byte {
+	if secret := os.Getenv("SCENE_INVITE_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+	return []byte(os.Getenv("JWT_SECRET"))
+}
+
+func sceneInviteSignature(sceneID string, expires int64) string {
+	mac := hmac.New(sha256.New, sceneInviteSecret())
+	fmt.Fprintf(mac, "invite:%s:%d", sceneID, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signSceneInvite returns an invite token for a scene: "<scene id>.<expires>.<signature>"
+func signSceneInvite(sceneID uuid.UUID, expiresAt time.Time) string {
+	expires := expiresAt.Unix()
+	return fmt.Sprintf("%s.%d.%s", sceneID, expires, sceneInviteSignature(sceneID.String(), expires))
+}
+
+// verifySceneInvite returns the scene an unexpired invite token was issued for
+func verifySceneInvite(token string) (uuid.UUID, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return uuid.Nil, errInvalidInvite
+	}
+
+	sceneID, err := uuid.Parse(parts[0])
+	if err != nil {
+		return uuid.Nil, errInvalidInvite
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().After(time.Unix(expires, 0)) {
+		return uuid.Nil, errInvalidInvite
+	}
+	if !hmac.Equal([]byte(sceneInviteSignature(sceneID.String(), expires)), []byte(parts[2])) {
+		return uuid.Nil, errInvalidInvite
+	}
+
+	return sceneID, nil
+}
+
+// chatRequestMaxDistance returns how far apart the scenes of a chat request may be, in
+// meters (0 = unlimited). A valid invite link for the target scene lifts the usual limit.
+func chatRequestMaxDistance(toSceneID uuid.UUID, inviteToken string) (float64, *apiError) {
+	if inviteToken == "" {
+		return float64(config.GetInt("CHAT_MAX_DISTANCE", defaultChatMaxDistance)), nil
+	}
+
+	invitedSceneID, err := verifySceneInvite(inviteToken)
+	if err != nil || invitedSceneID != toSceneID {
+		return 0, &apiError{Status: http.StatusBadRequest, Code: "INVALID_INVITE", Message: "Invite link is invalid or expired"}
+	}
+	return float64(config.GetInt("CHAT_INVITE_MAX_DISTANCE", defaultInviteMaxDistance)), nil
+}
+
+// sceneLocationSQL is where a scene's owner is now: their last reported location, or the
+// scene's start location if they haven't reported one since the scene started
+const sceneLocationSQL = `
+	SELECT s.id,
+	       CASE WHEN u.last_location_updated_at > s.started_at THEN u.last_latitude ELSE s.latitude END AS latitude,
+	       CASE WHEN u.last_location_updated_at > s.started_at THEN u.last_longitude ELSE s.longitude END AS longitude
+	FROM scenes s
+	JOIN personas p ON s.persona_id = p.id
+	JOIN users u ON p.user_id = u.id`
+
+// checkSceneDistance rejects a chat request between scenes whose owners are further
+// apart than maxDistance
+func checkSceneDistance(fromSceneID, toSceneID uuid.UUID, maxDistance float64) *apiError {
+	if maxDistance <= 0 {
+		return nil
+	}
+
+	var fromLat, fromLon, toLat, toLon float64
+	err := config.DB.QueryRow(
+		`WITH loc AS (`+sceneLocationSQL+` WHERE s.id IN ($1, $2))
+		 SELECT f.latitude, f.longitude, t.latitude, t.longitude
+		 FROM loc f, loc t
+		 WHERE f.id = $1 AND t.id = $2`,
+		fromSceneID, toSceneID,
+	).Scan(&fromLat, &fromLon, &toLat, &toLon)
+	if err != nil {
+		log.Printf("Failed to get scene locations: %v", err)
+		return &apiError{Status: http.StatusInternalServerError, Message: "Failed to create chat request"}
+	}
+
+	if geo.Distance(fromLat, fromLon, toLat, toLon) > maxDistance {
+		return &apiError{
+			Status:  http.StatusBadRequest,
+			Code:    "TOO_FAR",
+			Message: fmt.Sprintf("This scene is more than %.0f meters away", maxDistance),
+		}
+	}
+	return nil
+}
+
+// CreateSceneInvite returns an invite link token for the caller's active scene. A chat
+// request sent with it may come from further away than CHAT_MAX_DISTANCE.
+func CreateSceneInvite(c *gin.Context) {
+	userID, _ := middleware.GetUserID(c)
+
+	var sceneID uuid.UUID
+	var sceneExpiresAt time.Time
+	err := config.DB.QueryRow(
+		`SELECT s.id, s.expires_at FROM scenes s
+		 JOIN personas p ON s.persona_id = p.id
+		 WHERE p.user_id = $1 AND s.is_active = true AND s.expires_at > NOW()
+		 ORDER BY s.started_at DESC LIMIT 1`,
+		userID,
+	).Scan(&sceneID, &sceneExpiresAt)
+
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No active scene found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to get active scene: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get active scene"})
+		return
+	}
+
+	// The link is useless once the scene ends, so it never outlives it
+	expiresAt := time.Now().Add(config.GetDuration("SCENE_INVITE_TTL", defaultSceneInviteTTL))
+	if sceneExpiresAt.Before(expiresAt) {
+		expiresAt = sceneExpiresAt
+	}
+	expiresAt = time.Unix(expiresAt.Unix(), 0).UTC()
+
+	c.JSON(http.StatusCreated, gin.H{
+		"scene_id":   sceneID.String(),
+		"token":      signSceneInvite(sceneID, expiresAt),
+		"expires_at": expiresAt,
+	})
+}
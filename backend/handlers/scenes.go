@@ -6,19 +6,28 @@ import (
 	"log"
 	"net/http"
 	"scene-on/backend/config"
+	"scene-on/backend/geo"
 	"scene-on/backend/middleware"
 	"scene-on/backend/models"
+	"scene-on/backend/storage"
 	"scene-on/backend/websocket"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
 
+// defaultRecapNearbyRadius is the radius nearby scenes are counted in for the recap's
+// peak (RECAP_NEARBY_RADIUS, meters)
+const defaultRecapNearbyRadius = websocket.DefaultNotificationRadius
+
 type StartSceneRequest struct {
 	PersonaID string  `json:"persona_id" binding:"required"`
 	Latitude  float64 `json:"latitude" binding:"required"`
 	Longitude float64 `json:"longitude" binding:"required"`
+	// PublicKey opts the scene into end-to-end encrypted chats (base64 X25519 key)
+	PublicKey string `json:"public_key,omitempty"`
 }
 
 type SceneWithPersona struct {
@@ -28,7 +37,7 @@ type SceneWithPersona struct {
 	PersonaDescription string `json:"persona_description"`
 }
 
-func StartScene(wsHub *websocket.Hub) gin.HandlerFunc {
+func StartScene(wsHub *websocket.Hub, geoIndex geo.GeoIndex) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID, _ := middleware.GetUserID(c)
 
@@ -44,46 +53,65 @@ func StartScene(wsHub *websocket.Hub) gin.HandlerFunc {
 			return
 		}
 
-		// Verify persona belongs to user (and personaID should be userID in our simplified model)
-		if personaID != userID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Persona ID must match User ID"})
-			return
+		var publicKey *string
+		if req.PublicKey != "" {
+			if err := validatePublicKey(req.PublicKey); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": "INVALID_PUBLIC_KEY"})
+				return
+			}
+			publicKey = &req.PublicKey
 		}
 
 
-		// Check if persona exists (it might have been deleted by ephemeral cleanup)
+		// Check the persona exists and belongs to the user (it might have been deleted)
 		var exists bool
-		err = config.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM personas WHERE id = $1)", personaID).Scan(&exists)
+		err = config.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM personas WHERE id = $1 AND user_id = $2)", personaID, userID).Scan(&exists)
 		if err != nil || !exists {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Persona not found. Please recreate your identity.", "code": "PERSONA_NOT_FOUND"})
 			return
 		}
 
-		// Check if user already has an active scene
+		// Check if user already has an active scene, under any of their personas
 		var scene models.Scene
 		err = config.DB.QueryRow(
-			`SELECT id, persona_id, latitude, longitude, is_active, started_at, expires_at, created_at
-			 FROM scenes 
-			 WHERE persona_id = $1 AND is_active = true AND expires_at > NOW()
-			 ORDER BY started_at DESC LIMIT 1`,
-			personaID,
-		).Scan(&scene.ID, &scene.PersonaID, &scene.Latitude, &scene.Longitude,
+			`SELECT s.id, s.persona_id, s.latitude, s.longitude, s.venue_id, s.public_key, s.is_active, s.started_at, s.expires_at, s.created_at
+			 FROM scenes s
+			 JOIN personas p ON s.persona_id = p.id
+			 WHERE p.user_id = $1 AND s.is_active = true AND s.expires_at > NOW()
+			 ORDER BY s.started_at DESC LIMIT 1`,
+			userID,
+		).Scan(&scene.ID, &scene.PersonaID, &scene.Latitude, &scene.Longitude, &scene.VenueID, &scene.PublicKey,
 			&scene.IsActive, &scene.StartedAt, &scene.ExpiresAt, &scene.CreatedAt)
 
+		// Tag the scene with the venue it falls inside, if any
+		venueID := findVenueAt(geoIndex, req.Latitude, req.Longitude)
+
 		if err == nil {
-			// Update existing scene (Upsert behavior)
+			// Update existing scene (Upsert behavior); a different persona switches it
+			personaChanged := scene.PersonaID != personaID
+			scene.PersonaID = personaID
 			scene.Latitude = req.Latitude
 			scene.Longitude = req.Longitude
+			scene.VenueID = venueID
 			scene.ExpiresAt = time.Now().UTC().Add(4 * time.Hour) // Extend TTL
+			// Every (re)start states the key afresh: a client that lost its private key, or
+			// opts out, must not have new chats encrypted to the old one. Changing the key
+			// only affects chats accepted from now on.
+			scene.PublicKey = publicKey
 
 			_, err = config.DB.Exec(
-				`UPDATE scenes SET latitude = $1, longitude = $2, expires_at = $3 WHERE id = $4`,
-				scene.Latitude, scene.Longitude, scene.ExpiresAt, scene.ID,
+				`UPDATE scenes SET persona_id = $1, latitude = $2, longitude = $3, venue_id = $4, expires_at = $5, public_key = $6 WHERE id = $7`,
+				scene.PersonaID, scene.Latitude, scene.Longitude, scene.VenueID, scene.ExpiresAt, scene.PublicKey, scene.ID,
 			)
 			if err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update existing scene"})
 				return
 			}
+			if personaChanged {
+				if persona, err := loadPersona(personaID); err == nil {
+					notifyPersonaChanged(wsHub, scene.ID, persona)
+				}
+			}
 			log.Printf("✓ Updated existing scene %s for persona %s", scene.ID, personaID)
 		} else if err == sql.ErrNoRows {
 			// Create new scene
@@ -93,6 +121,8 @@ func StartScene(wsHub *websocket.Hub) gin.HandlerFunc {
 				PersonaID: personaID,
 				Latitude:  req.Latitude,
 				Longitude: req.Longitude,
+				VenueID:   venueID,
+				PublicKey: publicKey,
 				IsActive:  true,
 				StartedAt: now,
 				ExpiresAt: now.Add(4 * time.Hour),
@@ -100,9 +130,9 @@ func StartScene(wsHub *websocket.Hub) gin.HandlerFunc {
 			}
 
 			_, err = config.DB.Exec(
-				`INSERT INTO scenes (id, persona_id, latitude, longitude, is_active, started_at, expires_at, created_at)
-				 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
-				scene.ID, scene.PersonaID, scene.Latitude, scene.Longitude,
+				`INSERT INTO scenes (id, persona_id, latitude, longitude, venue_id, public_key, is_active, started_at, expires_at, created_at)
+				 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+				scene.ID, scene.PersonaID, scene.Latitude, scene.Longitude, scene.VenueID, scene.PublicKey,
 				scene.IsActive, scene.StartedAt, scene.ExpiresAt, scene.CreatedAt,
 			)
 			if err != nil {
@@ -127,48 +157,143 @@ func StartScene(wsHub *websocket.Hub) gin.HandlerFunc {
 			},
 			scene.Latitude,
 			scene.Longitude,
-			5000, // 5km radius in meters
+			websocket.DefaultNotificationRadius, // recipients may override with their own radius
 			scene.ID,
+			userID,
 		)
 
+		sampleNearbyCount(geoIndex, scene.ID, userID, scene.Latitude, scene.Longitude)
+
 		c.JSON(http.StatusCreated, scene)
 	}
 }
 
-func StopScene(wsHub *websocket.Hub) gin.HandlerFunc {
+// SwitchPersonaRequest picks another of the user's personas for the running scene
+type SwitchPersonaRequest struct {
+	PersonaID string `json:"persona_id" binding:"required"`
+}
+
+// loadPersona loads a persona by id
+func loadPersona(personaID uuid.UUID) (models.Persona, error) {
+	var persona models.Persona
+	err := config.DB.QueryRow(
+		`SELECT id, user_id, name, avatar_url, description, stats, is_active, created_at, updated_at
+		 FROM personas WHERE id = $1`,
+		personaID,
+	).Scan(&persona.ID, &persona.UserID, &persona.Name, &persona.AvatarURL, &persona.Description,
+		&persona.Stats, &persona.IsActive, &persona.CreatedAt, &persona.UpdatedAt)
+	return persona, err
+}
+
+// SwitchScenePersona shows another of the user's personas on their active scene, keeping
+// its location, chats and rooms. Chat partners get scene.persona_changed.
+func SwitchScenePersona(wsHub *websocket.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := middleware.GetUserID(c)
+
+		var req SwitchPersonaRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		personaID, err := uuid.Parse(req.PersonaID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid persona_id"})
+			return
+		}
+
+		persona, err := loadPersona(personaID)
+		if err == sql.ErrNoRows || (err == nil && persona.UserID != userID) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Persona not found", "code": "PERSONA_NOT_FOUND"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to get persona: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get persona"})
+			return
+		}
+
+		// Get user's active scene
+		var sceneID, currentPersonaID uuid.UUID
+		err = config.DB.QueryRow(
+			`SELECT s.id, s.persona_id FROM scenes s
+			 JOIN personas p ON s.persona_id = p.id
+			 WHERE p.user_id = $1 AND s.is_active = true AND s.expires_at > NOW()
+			 ORDER BY s.started_at DESC LIMIT 1`,
+			userID,
+		).Scan(&sceneID, &currentPersonaID)
+
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No active scene found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to get active scene: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get active scene"})
+			return
+		}
+
+		if currentPersonaID != personaID {
+			if _, err := config.DB.Exec(`UPDATE scenes SET persona_id = $1 WHERE id = $2`, personaID, sceneID); err != nil {
+				log.Printf("Failed to switch scene persona: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to switch persona"})
+				return
+			}
+			notifyPersonaChanged(wsHub, sceneID, persona)
+			log.Printf("🎭 Scene %s switched to persona %s", sceneID, persona.Name)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"scene_id": sceneID.String(),
+			"persona":  persona,
+		})
+	}
+}
+
+func StopScene(wsHub *websocket.Hub, blobs storage.BlobStore) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID, _ := middleware.GetUserID(c)
 
 		// Find active scene for any of user's personas
 		var sceneID uuid.UUID
+		var startedAt time.Time
 		err := config.DB.QueryRow(
-			`SELECT s.id FROM scenes s
+			`SELECT s.id, s.started_at FROM scenes s
 			 JOIN personas p ON s.persona_id = p.id
 			 WHERE p.user_id = $1 AND s.is_active = true AND s.expires_at > NOW()
 			 ORDER BY s.started_at DESC LIMIT 1`,
 			userID,
-		).Scan(&sceneID)
+		).Scan(&sceneID, &startedAt)
 
 		if err != nil {
 			c.JSON(http.StatusNotFound, gin.H{"error": "No active scene found"})
 			return
 		}
 
-		// Hard delete associated data (yells, chat requests)
-		// Chat messages will be deleted via cascade (if defined in migration) or we can manually delete
+		// Compute the recap before the scene's data is wiped
+		recap := buildSceneRecap(sceneID, startedAt)
+
+		// Hard delete the scene's yells
 		_, err = config.DB.Exec(`DELETE FROM yells WHERE scene_id = $1`, sceneID)
 		if err != nil {
 			log.Printf("Warning: Failed to delete yells for scene %s: %v", sceneID, err)
 		}
 
-		_, err = config.DB.Exec(`DELETE FROM chat_requests WHERE from_scene_id = $1 OR to_scene_id = $1`, sceneID)
-		if err != nil {
-			log.Printf("Warning: Failed to delete chat requests for scene %s: %v", sceneID, err)
-		}
+		// Close the scene's chats through the state machine; the cleanup deletes them once
+		// they are final. Messages go right away unless both sides agreed to an export.
+		freed := closeSceneChats(wsHub, sceneID)
+		purgeFinishedChats(blobs)
+
+		// Unreciprocated likes vanish with the scene
+		deleteSceneLikes(sceneID)
 
-		// Deactivate scene
+		// End the scene's group rooms and leave the ones it joined
+		endGroupRoomsForScene(wsHub, sceneID)
+
+		// Deactivate scene and destroy its E2E key material
 		_, err = config.DB.Exec(
-			`UPDATE scenes SET is_active = false WHERE id = $1`,
+			`UPDATE scenes SET is_active = false, public_key = NULL WHERE id = $1`,
 			sceneID,
 		)
 		if err != nil {
@@ -176,6 +301,20 @@ func StopScene(wsHub *websocket.Hub) gin.HandlerFunc {
 			return
 		}
 
+		// Partners whose chat just ended may have a match waiting for the slot
+		completeWaitingMatches(wsHub, freed...)
+
+		// Deliver the recap to the scene's own clients as the final event
+		wsHub.Targeted <- websocket.TargetedMessage{
+			TargetSceneID: sceneID,
+			Message: websocket.Message{
+				Type: "scene.recap",
+				Data: map[string]interface{}{
+					"recap": recap,
+				},
+			},
+		}
+
 		// Broadcast scene ended event
 		log.Printf("📢 Broadcasting scene.ended for scene %s", sceneID)
 		wsHub.Broadcast <- websocket.BroadcastMessage{
@@ -185,98 +324,241 @@ func StopScene(wsHub *websocket.Hub) gin.HandlerFunc {
 					"scene_id":  sceneID.String(),
 				},
 			},
+			FromUserID: userID,
 		}
 
-		c.JSON(http.StatusOK, gin.H{"message": "Scene stopped"})
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Scene stopped",
+			"recap":   recap,
+		})
 	}
 }
 
-// CleanupActiveScenes marks all scenes as inactive on startup
-func CleanupActiveScenes() {
-	log.Println("🧹 Cleaning up active scenes on startup...")
-	
-	// Mark all scenes inactive
-	res, err := config.DB.Exec(`UPDATE scenes SET is_active = false WHERE is_active = true`)
+// sampleNearbyCount counts the scenes within RECAP_NEARBY_RADIUS of a scene and raises
+// its peak_nearby_count for the recap. The radius is fixed on the server, so the peak
+// doesn't depend on how far or how often the client searched.
+func sampleNearbyCount(geoIndex geo.GeoIndex, sceneID, userID uuid.UUID, lat, lon float64) {
+	hits, err := geoIndex.NearbyScenes(geo.NearbyQuery{
+		Latitude:       lat,
+		Longitude:      lon,
+		RadiusMeters:   float64(config.GetInt("RECAP_NEARBY_RADIUS", defaultRecapNearbyRadius)),
+		ExcludeUserID:  userID,
+		HideBlockedFor: userID,
+	})
 	if err != nil {
-		log.Printf("❌ Failed to cleanup database scenes: %v", err)
-	} else {
-		count, _ := res.RowsAffected()
-		log.Printf("✓ Marked %d scenes as inactive", count)
+		log.Printf("Warning: Failed to count scenes near scene %s: %v", sceneID, err)
+		return
 	}
 
-	log.Println("✓ Startup cleanup complete")
+	_, err = config.DB.Exec(
+		`UPDATE scenes SET peak_nearby_count = GREATEST(COALESCE(peak_nearby_count, 0), $1) WHERE id = $2`,
+		len(hits), sceneID,
+	)
+	if err != nil {
+		log.Printf("Warning: Failed to update peak nearby count for scene %s: %v", sceneID, err)
+	}
 }
 
-func GetNearbyScenes(c *gin.Context) {
-	lat := c.Query("latitude")
-	lon := c.Query("longitude")
-	radiusStr := c.DefaultQuery("radius", "50") // Default 50km if not provided
-	
-	if lat == "" || lon == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "latitude and longitude required"})
+// sampleActiveScenesNearby runs sampleNearbyCount for every active scene
+func sampleActiveScenesNearby(geoIndex geo.GeoIndex) {
+	rows, err := config.DB.Query(
+		`SELECT s.id, p.user_id, s.latitude, s.longitude FROM scenes s
+		 JOIN personas p ON s.persona_id = p.id
+		 WHERE s.is_active = true AND s.expires_at > NOW()`,
+	)
+	if err != nil {
+		log.Printf("Failed to query active scenes: %v", err)
 		return
 	}
 
-	// Parse radius (in kilometers)
-	var radiusKm float64
-	if _, err := fmt.Sscanf(radiusStr, "%f", &radiusKm); err != nil || radiusKm <= 0 || radiusKm > 3000 {
-		radiusKm = 50 // Default to 50km if invalid
+	type sample struct {
+		sceneID, userID uuid.UUID
+		lat, lon        float64
+	}
+	var samples []sample
+	for rows.Next() {
+		var s sample
+		if err := rows.Scan(&s.sceneID, &s.userID, &s.lat, &s.lon); err == nil {
+			samples = append(samples, s)
+		}
 	}
+	rows.Close()
 
-	// Convert km to meters for ST_DWithin
-	radiusMeters := radiusKm * 1000
+	for _, s := range samples {
+		sampleNearbyCount(geoIndex, s.sceneID, s.userID, s.lat, s.lon)
+	}
+}
 
-	// Get current user's ID to exclude their own scenes
-	userID, _ := middleware.GetUserID(c)
+// Recap counters on scenes, raised by countSceneActivity
+const (
+	recapRequestsSent     = "requests_sent_count"
+	recapRequestsReceived = "requests_received_count"
+	recapChatsAccepted    = "chats_accepted_count"
+	recapMessages         = "messages_count"
+)
 
-	// Use PostGIS ST_DWithin for efficient distance filtering
-	// Optimized query with pre-computed geography casting
-	rows, err := config.DB.Query(
-		`SELECT s.id, s.persona_id, s.latitude, s.longitude, s.is_active, s.started_at, s.expires_at, s.created_at,
-		        p.name as persona_name, p.avatar_url as persona_avatar, p.description as persona_description
-		 FROM scenes s
-		 INNER JOIN personas p ON s.persona_id = p.id
-		 WHERE s.is_active = true 
-		   AND s.expires_at > NOW()
-		   AND p.user_id != $1
-		   AND ST_DWithin(
-		       ST_SetSRID(ST_MakePoint(s.longitude, s.latitude), 4326)::geography,
-		       ST_SetSRID(ST_MakePoint($2, $3), 4326)::geography,
-		       $4
-		   )
-		 ORDER BY ST_Distance(
-		     ST_SetSRID(ST_MakePoint(s.longitude, s.latitude), 4326)::geography,
-		     ST_SetSRID(ST_MakePoint($2, $3), 4326)::geography
-		 )
-		 LIMIT 100`,
-		userID, lon, lat, radiusMeters,
-	)
+// countSceneActivity adds one to a recap counter of each of the given scenes
+func countSceneActivity(q chatQuerier, counter string, sceneIDs ...uuid.UUID) {
+	ids := make([]string, len(sceneIDs))
+	for i, id := range sceneIDs {
+		ids[i] = id.String()
+	}
+	_, err := q.Exec(`UPDATE scenes SET `+counter+` = `+counter+` + 1 WHERE id = ANY($1::uuid[])`, ids)
 	if err != nil {
-		log.Printf("❌ Failed to fetch scenes: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch scenes"})
-		return
+		log.Printf("Warning: Failed to count %s for scenes %v: %v", counter, sceneIDs, err)
 	}
-	defer rows.Close()
+}
 
-	// Pre-allocate slice with estimated capacity for better performance
-	scenes := make([]SceneWithPersona, 0, 20)
-	for rows.Next() {
-		var scene SceneWithPersona
-		err := rows.Scan(
-			&scene.ID, &scene.PersonaID, &scene.Latitude, &scene.Longitude,
-			&scene.IsActive, &scene.StartedAt, &scene.ExpiresAt, &scene.CreatedAt,
-			&scene.PersonaName, &scene.PersonaAvatar, &scene.PersonaDescription,
-		)
+// buildSceneRecap counts what happened during a scene. Only aggregate numbers are
+// returned; no content or counterpart ids leave this function.
+func buildSceneRecap(sceneID uuid.UUID, startedAt time.Time) models.SceneRecap {
+	recap := models.SceneRecap{
+		SceneID:         sceneID,
+		DurationSeconds: int64(time.Since(startedAt).Seconds()),
+	}
+
+	// Chats and their messages are deleted as they end, so they are counted as they happen
+	err := config.DB.QueryRow(
+		`SELECT requests_sent_count, requests_received_count, chats_accepted_count, messages_count,
+		        COALESCE(peak_nearby_count, 0)
+		 FROM scenes WHERE id = $1`,
+		sceneID,
+	).Scan(&recap.RequestsSent, &recap.RequestsReceived, &recap.ChatsAccepted, &recap.MessagesExchanged,
+		&recap.PeakNearbyScenes)
+	if err != nil {
+		log.Printf("Warning: Failed to read recap counters for scene %s: %v", sceneID, err)
+	}
+
+	err = config.DB.QueryRow(
+		`SELECT COUNT(*) FROM yells WHERE scene_id = $1`,
+		sceneID,
+	).Scan(&recap.YellsPosted)
+	if err != nil {
+		log.Printf("Warning: Failed to count yells for recap of scene %s: %v", sceneID, err)
+	}
+
+	return recap
+}
+
+// SceneOwnedBy reports whether the scene belongs to one of the user's personas
+func SceneOwnedBy(sceneID, userID uuid.UUID) bool {
+	var owned bool
+	err := config.DB.QueryRow(
+		`SELECT EXISTS(SELECT 1 FROM scenes s
+		 JOIN personas p ON s.persona_id = p.id
+		 WHERE s.id = $1 AND p.user_id = $2)`,
+		sceneID, userID,
+	).Scan(&owned)
+	return err == nil && owned
+}
+
+func GetNearbyScenes(geoIndex geo.GeoIndex) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		latStr := c.Query("latitude")
+		lonStr := c.Query("longitude")
+		radiusStr := c.DefaultQuery("radius", "50") // Default 50km if not provided
+
+		if latStr == "" || lonStr == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "latitude and longitude required"})
+			return
+		}
+
+		lat, errLat := strconv.ParseFloat(latStr, 64)
+		lon, errLon := strconv.ParseFloat(lonStr, 64)
+		if errLat != nil || errLon != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid latitude or longitude"})
+			return
+		}
+
+		// Parse radius (in kilometers)
+		var radiusKm float64
+		if _, err := fmt.Sscanf(radiusStr, "%f", &radiusKm); err != nil || radiusKm <= 0 || radiusKm > 3000 {
+			radiusKm = 50 // Default to 50km if invalid
+		}
+
+		// Convert km to meters
+		radiusMeters := radiusKm * 1000
+
+		// Optional venue filter
+		var venueID *uuid.UUID
+		if venueStr := c.Query("venue_id"); venueStr != "" {
+			parsed, err := uuid.Parse(venueStr)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid venue_id"})
+				return
+			}
+			venueID = &parsed
+		}
+
+		// Get current user's ID to exclude their own scenes
+		userID, _ := middleware.GetUserID(c)
+
+		// Find nearby scene ids through the configured geo backend, ordered by distance
+		hits, err := geoIndex.NearbyScenes(geo.NearbyQuery{
+			Latitude:       lat,
+			Longitude:      lon,
+			RadiusMeters:   radiusMeters,
+			ExcludeUserID:  userID,
+			VenueID:        venueID,
+			HideBlockedFor: userID, // in the query, so blocked users don't take up the limit
+			Limit:          100,
+		})
 		if err != nil {
-			log.Printf("❌ Failed to scan scene: %v", err)
-			continue
+			log.Printf("❌ Failed to fetch scenes: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch scenes"})
+			return
 		}
-		scenes = append(scenes, scene)
-	}
 
-	log.Printf("📍 Found %d scenes within %.0fkm for user %s", len(scenes), radiusKm, userID)
+		// Pre-allocate slice with estimated capacity for better performance
+		scenes := make([]SceneWithPersona, 0, len(hits))
+		if len(hits) > 0 {
+			sceneIDs := make([]string, len(hits))
+			for i, hit := range hits {
+				sceneIDs[i] = hit.SceneID.String()
+			}
 
-	c.JSON(http.StatusOK, scenes)
+			rows, err := config.DB.Query(
+				`SELECT s.id, s.persona_id, s.latitude, s.longitude, s.venue_id, s.is_active, s.started_at, s.expires_at, s.created_at,
+				        p.name as persona_name, p.avatar_url as persona_avatar, p.description as persona_description
+				 FROM scenes s
+				 INNER JOIN personas p ON s.persona_id = p.id
+				 WHERE s.id = ANY($1::uuid[])`,
+				sceneIDs,
+			)
+			if err != nil {
+				log.Printf("❌ Failed to fetch scenes: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch scenes"})
+				return
+			}
+			defer rows.Close()
+
+			byID := make(map[uuid.UUID]SceneWithPersona, len(hits))
+			for rows.Next() {
+				var scene SceneWithPersona
+				err := rows.Scan(
+					&scene.ID, &scene.PersonaID, &scene.Latitude, &scene.Longitude, &scene.VenueID,
+					&scene.IsActive, &scene.StartedAt, &scene.ExpiresAt, &scene.CreatedAt,
+					&scene.PersonaName, &scene.PersonaAvatar, &scene.PersonaDescription,
+				)
+				if err != nil {
+					log.Printf("❌ Failed to scan scene: %v", err)
+					continue
+				}
+				byID[scene.ID] = scene
+			}
+
+			// Keep the distance ordering from the geo backend
+			for _, hit := range hits {
+				if scene, ok := byID[hit.SceneID]; ok {
+					scenes = append(scenes, scene)
+				}
+			}
+		}
+
+		log.Printf("📍 Found %d scenes within %.0fkm for user %s", len(scenes), radiusKm, userID)
+
+		c.JSON(http.StatusOK, scenes)
+	}
 }
 
 func GetActiveScene(c *gin.Context) {
@@ -284,13 +566,13 @@ func GetActiveScene(c *gin.Context) {
 
 	var scene models.Scene
 	err := config.DB.QueryRow(
-		`SELECT s.id, s.persona_id, s.latitude, s.longitude, s.is_active, s.started_at, s.expires_at, s.created_at
+		`SELECT s.id, s.persona_id, s.latitude, s.longitude, s.venue_id, s.is_active, s.started_at, s.expires_at, s.created_at
 		 FROM scenes s
 		 JOIN personas p ON s.persona_id = p.id
 		 WHERE p.user_id = $1 AND s.is_active = true AND s.expires_at > NOW()
 		 ORDER BY s.started_at DESC LIMIT 1`,
 		userID,
-	).Scan(&scene.ID, &scene.PersonaID, &scene.Latitude, &scene.Longitude,
+	).Scan(&scene.ID, &scene.PersonaID, &scene.Latitude, &scene.Longitude, &scene.VenueID,
 		&scene.IsActive, &scene.StartedAt, &scene.ExpiresAt, &scene.CreatedAt)
 
 	if err == sql.ErrNoRows {
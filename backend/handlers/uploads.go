@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"scene-on/backend/media"
+
+	"github.com/gin-gonic/gin"
+)
+
+// readImageUpload reads the "image" multipart field, refusing anything over maxBytes
+// with a 413 carrying tooLargeCode. It writes the error response itself.
+func readImageUpload(c *gin.Context, maxBytes int, tooLargeCode string) ([]byte, bool) {
+	tooLarge := func() {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error": fmt.Sprintf("Image must be at most %d bytes", maxBytes),
+			"code":  tooLargeCode,
+		})
+	}
+
+	// Leave room for the multipart envelope around the file itself
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, int64(maxBytes)+64<<10)
+	file, header, err := c.Request.FormFile("image")
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			tooLarge()
+			return nil, false
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing image file"})
+		return nil, false
+	}
+	defer file.Close()
+
+	if header.Size > int64(maxBytes) {
+		tooLarge()
+		return nil, false
+	}
+
+	data, err := io.ReadAll(io.LimitReader(file, int64(maxBytes)+1))
+	if err != nil {
+		log.Printf("Failed to read image upload: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read image"})
+		return nil, false
+	}
+	if len(data) > maxBytes {
+		tooLarge()
+		return nil, false
+	}
+
+	return data, true
+}
+
+// respondImageError maps an error from the media package to a response
+func respondImageError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, media.ErrUnsupportedType):
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Only JPEG and PNG images are allowed", "code": "UNSUPPORTED_MEDIA_TYPE"})
+	case errors.Is(err, media.ErrTooManyPixels):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Image dimensions are too large", "code": "IMAGE_TOO_LARGE"})
+	case errors.Is(err, media.ErrInvalidImage):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image", "code": "INVALID_IMAGE"})
+	default:
+		log.Printf("Failed to process image: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process image"})
+	}
+}
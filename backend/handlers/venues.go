@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"scene-on/backend/config"
+	"scene-on/backend/geo"
+	"scene-on/backend/models"
+	"scene-on/backend/websocket"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type CreateVenueRequest struct {
+	Name     string          `json:"name" binding:"required"`
+	Boundary json.RawMessage `json:"boundary" binding:"required"` // GeoJSON Polygon
+}
+
+type VenueBroadcastRequest struct {
+	Message string `json:"message" binding:"required"`
+}
+
+// findVenueAt returns the smallest venue containing the given point, or nil
+func findVenueAt(geoIndex geo.GeoIndex, lat, lon float64) *uuid.UUID {
+	venueID, err := geoIndex.VenueAt(lat, lon)
+	if err != nil {
+		log.Printf("Warning: Failed to look up venue for (%f, %f): %v", lat, lon, err)
+		return nil
+	}
+	return venueID
+}
+
+// ListVenues returns all venues
+func ListVenues(c *gin.Context) {
+	rows, err := config.DB.Query(
+		`SELECT id, name, boundary_geojson, created_at
+		 FROM venues
+		 ORDER BY name ASC`,
+	)
+	if err != nil {
+		log.Printf("Failed to get venues: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get venues"})
+		return
+	}
+	defer rows.Close()
+
+	var venues []models.Venue
+	for rows.Next() {
+		var v models.Venue
+		var boundary []byte
+		if err := rows.Scan(&v.ID, &v.Name, &boundary, &v.CreatedAt); err != nil {
+			log.Printf("Failed to scan venue: %v", err)
+			continue
+		}
+		v.Boundary = json.RawMessage(boundary)
+		venues = append(venues, v)
+	}
+
+	if venues == nil {
+		venues = []models.Venue{}
+	}
+
+	c.JSON(http.StatusOK, venues)
+}
+
+// CreateVenue creates a venue from a GeoJSON polygon (admin only)
+func CreateVenue(geoIndex geo.GeoIndex) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req CreateVenueRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		polygon, err := geo.ParsePolygon(req.Boundary)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid venue boundary (expected a GeoJSON Polygon)"})
+			return
+		}
+
+		venue, err := insertVenue(req.Name, polygon)
+		if err != nil {
+			log.Printf("Failed to create venue: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create venue"})
+			return
+		}
+
+		// Tag active scenes that already sit inside the new venue
+		sceneIDs, err := geoIndex.VenueScenes(venue.ID, uuid.Nil)
+		if err == nil && len(sceneIDs) > 0 {
+			ids := make([]string, len(sceneIDs))
+			for i, id := range sceneIDs {
+				ids[i] = id.String()
+			}
+			_, err = config.DB.Exec(
+				`UPDATE scenes SET venue_id = $1 WHERE id = ANY($2::uuid[]) AND venue_id IS NULL`,
+				venue.ID, ids,
+			)
+		}
+		if err != nil {
+			log.Printf("Warning: Failed to tag existing scenes for venue %s: %v", venue.ID, err)
+		}
+
+		log.Printf("✓ Created venue %s (%s)", venue.Name, venue.ID)
+		c.JSON(http.StatusCreated, venue)
+	}
+}
+
+// insertVenue stores a venue's boundary as GeoJSON, which every geo backend reads, and
+// as a PostGIS geometry when that backend is in use
+func insertVenue(name string, polygon geo.Polygon) (models.Venue, error) {
+	venue := models.Venue{Name: name, Boundary: json.RawMessage(polygon.GeoJSON())}
+
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return venue, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(
+		`INSERT INTO venues (name, boundary_geojson) VALUES ($1, $2)
+		 RETURNING id, created_at`,
+		venue.Name, string(venue.Boundary),
+	).Scan(&venue.ID, &venue.CreatedAt)
+	if err != nil {
+		return venue, err
+	}
+
+	if config.GeoBackend() == config.GeoBackendPostGIS {
+		_, err = tx.Exec(
+			`UPDATE venues SET boundary = ST_SetSRID(ST_GeomFromGeoJSON($2), 4326) WHERE id = $1`,
+			venue.ID, string(venue.Boundary),
+		)
+		if err != nil {
+			return venue, err
+		}
+	}
+
+	return venue, tx.Commit()
+}
+
+// DeleteVenue removes a venue (admin only). Tagged scenes fall back to no venue.
+func DeleteVenue(c *gin.Context) {
+	venueID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid venue id"})
+		return
+	}
+
+	result, err := config.DB.Exec(`DELETE FROM venues WHERE id = $1`, venueID)
+	if err != nil {
+		log.Printf("Failed to delete venue: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete venue"})
+		return
+	}
+
+	if count, _ := result.RowsAffected(); count == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Venue not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Venue deleted"})
+}
+
+// BroadcastToVenue sends an announcement to every scene inside the venue (admin only)
+func BroadcastToVenue(wsHub *websocket.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		venueID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid venue id"})
+			return
+		}
+
+		var req VenueBroadcastRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var venueName string
+		err = config.DB.QueryRow(`SELECT name FROM venues WHERE id = $1`, venueID).Scan(&venueName)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Venue not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to get venue: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get venue"})
+			return
+		}
+
+		wsHub.BroadcastToVenue(
+			websocket.Message{
+				Type: "venue.announcement",
+				Data: map[string]interface{}{
+					"venue_id":   venueID.String(),
+					"venue_name": venueName,
+					"message":    req.Message,
+				},
+			},
+			venueID,
+			uuid.Nil,
+		)
+
+		c.JSON(http.StatusOK, gin.H{"message": "Announcement sent"})
+	}
+}
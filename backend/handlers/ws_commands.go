@@ -0,0 +1,16 @@
+package handlers
+
+import (
+	"scene-on/backend/websocket"
+)
+
+// RegisterWebSocketCommands wires client-sent WebSocket message types to their handlers,
+// and delivery hooks to server-sent ones
+func RegisterWebSocketCommands(wsHub *websocket.Hub) {
+	wsHub.HandleCommand("chat.extend.accept", handleChatExtendAccept(wsHub))
+	wsHub.HandleCommand("chat.message.delivered", handleMessageStatus(wsHub, messageStatusDelivered))
+	wsHub.HandleCommand("chat.message.read", handleMessageStatus(wsHub, messageStatusRead))
+
+	// A message written to the recipient's connection counts as delivered
+	wsHub.HandleDelivered("chat.message.received", handleMessageWritten(wsHub))
+}
@@ -7,8 +7,11 @@ import (
 	"strings"
 
 	"scene-on/backend/config"
+	"scene-on/backend/geo"
 	"scene-on/backend/handlers"
+	"scene-on/backend/moderation"
 	"scene-on/backend/routes"
+	"scene-on/backend/storage"
 	"scene-on/backend/websocket"
 
 	"github.com/gin-contrib/cors"
@@ -54,12 +57,22 @@ func main() {
 	// ---- OAUTH ----
 	handlers.InitGoogleOAuth()
 
+	// ---- GEO BACKEND ----
+	geoIndex := geo.New(config.GeoBackend())
+
+	// ---- BLOB STORE ----
+	blobs := storage.New(os.Getenv("BLOB_STORE"))
+
+	// ---- CONTENT MODERATION ----
+	moderator := moderation.NewDefault()
+
 	// ---- WEBSOCKETS ----
-	wsHub = websocket.NewHub()
+	wsHub = websocket.NewHub(geoIndex)
 	go wsHub.Run()
 
-	// Run cleanup once at boot
-	handlers.RunBootCleanup(wsHub)
+	// Run cleanup once at boot, then keep expiring requests/chats on a schedule
+	handlers.RunBootCleanup(wsHub, blobs)
+	handlers.StartCleanupScheduler(wsHub, blobs, geoIndex)
 
 	// ---- GIN MODE ----
 	ginMode := os.Getenv("GIN_MODE")
@@ -99,7 +112,7 @@ func main() {
 	})
 
 	// ---- ROUTES ----
-	routes.SetupRoutes(router, wsHub)
+	routes.SetupRoutes(router, wsHub, geoIndex, blobs, moderator)
 
 	// ---- START SERVER ----
 	log.Printf("🚀 Scene-On API running on port %s", port)
@@ -0,0 +1,112 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+var (
+	ErrUnsupportedType = errors.New("unsupported image type")
+	ErrTooManyPixels   = errors.New("image dimensions too large")
+	ErrInvalidImage    = errors.New("invalid image")
+)
+
+// AllowedTypes are the image MIME types accepted for upload
+var AllowedTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+}
+
+// Image is a decoded, metadata-free image ready to be stored
+type Image struct {
+	Data     []byte
+	MimeType string
+	Width    int
+	Height   int
+}
+
+// DetectType sniffs the MIME type from the file content, ignoring any client-supplied type
+func DetectType(data []byte) string {
+	return http.DetectContentType(data)
+}
+
+// Decode sniffs and decodes an uploaded image, applying the JPEG EXIF orientation to
+// the pixels. Images larger than maxPixels are rejected before being decoded.
+func Decode(data []byte, maxPixels int) (image.Image, string, error) {
+	mimeType := DetectType(data)
+	if !AllowedTypes[mimeType] {
+		return nil, mimeType, ErrUnsupportedType
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, mimeType, ErrInvalidImage
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > maxPixels {
+		return nil, mimeType, ErrTooManyPixels
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, mimeType, ErrInvalidImage
+	}
+
+	if mimeType == "image/jpeg" {
+		img = applyOrientation(img, jpegOrientation(data))
+	}
+
+	return img, mimeType, nil
+}
+
+// Sanitize decodes an uploaded image and encodes it again in the same format.
+// Only pixels survive the round trip, so EXIF (including GPS), XMP, ICC and text
+// chunks are all dropped.
+func Sanitize(data []byte, maxPixels int) (*Image, error) {
+	img, mimeType, err := Decode(data, maxPixels)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := Encode(img, mimeType)
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := img.Bounds()
+	return &Image{
+		Data:     out,
+		MimeType: mimeType,
+		Width:    bounds.Dx(),
+		Height:   bounds.Dy(),
+	}, nil
+}
+
+// Encode writes img as JPEG or PNG
+func Encode(img image.Image, mimeType string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch mimeType {
+	case "image/jpeg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})
+	case "image/png":
+		err = png.Encode(&buf, img)
+	default:
+		return nil, ErrUnsupportedType
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Extension returns the file extension for a supported MIME type
+func Extension(mimeType string) string {
+	if mimeType == "image/png" {
+		return ".png"
+	}
+	return ".jpg"
+}
@@ -0,0 +1,91 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/png"
+	"testing"
+)
+
+// jpegSegments lists the markers of the segments before the first scan
+func jpegSegments(t *testing.T, data []byte) []byte {
+	t.Helper()
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		t.Fatalf("not a JPEG")
+	}
+	var markers []byte
+	for pos := 2; pos+4 <= len(data); {
+		if data[pos] != 0xFF {
+			t.Fatalf("no marker at %d", pos)
+		}
+		marker := data[pos+1]
+		markers = append(markers, marker)
+		if marker == 0xDA {
+			break
+		}
+		pos += 2 + int(binary.BigEndian.Uint16(data[pos+2:]))
+	}
+	return markers
+}
+
+func TestSanitizeStripsGPS(t *testing.T) {
+	// Orientation plus a GPS IFD pointer (0x8825) to a GPSLatitudeRef of "N"
+	gpsIFD := make([]byte, 0, 18)
+	gpsIFD = binary.BigEndian.AppendUint16(gpsIFD, 1)
+	gpsIFD = binary.BigEndian.AppendUint16(gpsIFD, 0x0001)
+	gpsIFD = binary.BigEndian.AppendUint16(gpsIFD, 2)
+	gpsIFD = binary.BigEndian.AppendUint32(gpsIFD, 2)
+	gpsIFD = append(gpsIFD, 'N', 0, 0, 0)
+	gpsIFD = binary.BigEndian.AppendUint32(gpsIFD, 0)
+	tiff := buildTIFF(binary.BigEndian, []tiffEntry{
+		{0x0112, 3, 6},
+		{0x8825, 4, 8 + 2 + 2*12 + 4},
+	}, gpsIFD)
+
+	data := withAPP1(testJPEG(t, 4, 2), exifPayload(tiff))
+	if !bytes.Contains(jpegSegments(t, data), []byte{0xE1}) {
+		t.Fatal("test input has no APP1 segment")
+	}
+
+	img, err := Sanitize(data, 1<<20)
+	if err != nil {
+		t.Fatalf("Sanitize: %v", err)
+	}
+	if bytes.Contains(jpegSegments(t, img.Data), []byte{0xE1}) {
+		t.Error("output still has an APP1 segment")
+	}
+	if bytes.Contains(img.Data, []byte("Exif")) {
+		t.Error("output still contains EXIF data")
+	}
+	// The orientation is applied to the pixels instead
+	if img.MimeType != "image/jpeg" || img.Width != 2 || img.Height != 4 {
+		t.Errorf("got %s %dx%d, want image/jpeg 2x4", img.MimeType, img.Width, img.Height)
+	}
+	if got := jpegOrientation(img.Data); got != 1 {
+		t.Errorf("output orientation = %d, want 1", got)
+	}
+}
+
+func TestSanitizeRejects(t *testing.T) {
+	var pngData bytes.Buffer
+	if err := png.Encode(&pngData, image.NewNRGBA(image.Rect(0, 0, 100, 100))); err != nil {
+		t.Fatal(err)
+	}
+	jpg := testJPEG(t, 4, 2)
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"not an image", []byte("hello"), ErrUnsupportedType},
+		{"truncated JPEG", jpg[:len(jpg)/2], ErrInvalidImage},
+		{"too many pixels", pngData.Bytes(), ErrTooManyPixels},
+	}
+	for _, tt := range tests {
+		if _, err := Sanitize(tt.data, 1000); err != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
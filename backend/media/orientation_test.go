@@ -0,0 +1,253 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// tiffEntry is one 12-byte IFD entry holding a SHORT or LONG value
+type tiffEntry struct {
+	tag   uint16
+	typ   uint16
+	value uint32
+}
+
+// buildTIFF lays out a TIFF header followed by one IFD at offset 8, then extra
+func buildTIFF(order binary.ByteOrder, entries []tiffEntry, extra []byte) []byte {
+	var buf bytes.Buffer
+	if order == binary.LittleEndian {
+		buf.WriteString("II")
+	} else {
+		buf.WriteString("MM")
+	}
+	binary.Write(&buf, order, uint16(42))
+	binary.Write(&buf, order, uint32(8))
+	binary.Write(&buf, order, uint16(len(entries)))
+	for _, e := range entries {
+		binary.Write(&buf, order, e.tag)
+		binary.Write(&buf, order, e.typ)
+		binary.Write(&buf, order, uint32(1))
+		if e.typ == 3 {
+			binary.Write(&buf, order, uint16(e.value))
+			binary.Write(&buf, order, uint16(0))
+		} else {
+			binary.Write(&buf, order, e.value)
+		}
+	}
+	binary.Write(&buf, order, uint32(0))
+	buf.Write(extra)
+	return buf.Bytes()
+}
+
+func orientationTIFF(order binary.ByteOrder, orientation int) []byte {
+	return buildTIFF(order, []tiffEntry{{0x0112, 3, uint32(orientation)}}, nil)
+}
+
+// withAPP1 inserts an APP1 segment carrying payload right after the SOI marker
+func withAPP1(jpg, payload []byte) []byte {
+	out := append([]byte{}, jpg[:2]...)
+	out = append(out, 0xFF, 0xE1)
+	out = binary.BigEndian.AppendUint16(out, uint16(len(payload)+2))
+	out = append(out, payload...)
+	return append(out, jpg[2:]...)
+}
+
+func exifPayload(tiff []byte) []byte {
+	return append([]byte("Exif\x00\x00"), tiff...)
+}
+
+// testJPEG encodes a w x h image, with the top-left pixel white and the rest black
+func testJPEG(t testing.TB, w, h int) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{A: 0xff})
+		}
+	}
+	img.SetNRGBA(0, 0, color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff})
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestTiffOrientation(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		for o := 1; o <= 8; o++ {
+			if got := tiffOrientation(orientationTIFF(order, o)); got != o {
+				t.Errorf("%v orientation %d: got %d", order, o, got)
+			}
+		}
+	}
+
+	// The tag doesn't have to be the first entry
+	tiff := buildTIFF(binary.BigEndian, []tiffEntry{{0x010F, 3, 1}, {0x0112, 3, 6}}, nil)
+	if got := tiffOrientation(tiff); got != 6 {
+		t.Errorf("second entry: got %d, want 6", got)
+	}
+}
+
+func TestTiffOrientationMalformed(t *testing.T) {
+	valid := orientationTIFF(binary.LittleEndian, 6)
+
+	badIFD := append([]byte{}, valid...)
+	binary.LittleEndian.PutUint32(badIFD[4:], 0xFFFFFFF0)
+
+	lowIFD := append([]byte{}, valid...)
+	binary.LittleEndian.PutUint32(lowIFD[4:], 2)
+
+	// Claims more entries than there are, none of them the orientation tag
+	tooManyEntries := buildTIFF(binary.LittleEndian, []tiffEntry{{0x010F, 3, 6}}, nil)
+	binary.LittleEndian.PutUint16(tooManyEntries[8:], 0xFFFF)
+
+	tests := []struct {
+		name string
+		tiff []byte
+	}{
+		{"empty", nil},
+		{"short header", valid[:7]},
+		{"bad byte order", append([]byte("XX"), valid[2:]...)},
+		{"IFD past the end", badIFD},
+		{"IFD inside the header", lowIFD},
+		{"entry count past the end", tooManyEntries},
+		{"entry cut off", valid[:len(valid)-8]},
+		{"no orientation tag", buildTIFF(binary.LittleEndian, []tiffEntry{{0x010F, 3, 6}}, nil)},
+		{"orientation 0", orientationTIFF(binary.LittleEndian, 0)},
+		{"orientation 9", orientationTIFF(binary.LittleEndian, 9)},
+	}
+	for _, tt := range tests {
+		if got := tiffOrientation(tt.tiff); got != 1 {
+			t.Errorf("%s: got %d, want 1", tt.name, got)
+		}
+	}
+}
+
+func TestJPEGOrientation(t *testing.T) {
+	jpg := testJPEG(t, 4, 2)
+	if got := jpegOrientation(jpg); got != 1 {
+		t.Errorf("no EXIF: got %d, want 1", got)
+	}
+
+	for o := 1; o <= 8; o++ {
+		data := withAPP1(jpg, exifPayload(orientationTIFF(binary.BigEndian, o)))
+		if got := jpegOrientation(data); got != o {
+			t.Errorf("orientation %d: got %d", o, got)
+		}
+	}
+
+	// An APP1 segment that isn't EXIF (e.g. XMP) is skipped
+	withEXIF := withAPP1(jpg, exifPayload(orientationTIFF(binary.LittleEndian, 8)))
+	data := withAPP1(withEXIF, []byte("http://ns.adobe.com/xap/1.0/\x00<x/>"))
+	if got := jpegOrientation(data); got != 8 {
+		t.Errorf("EXIF after XMP: got %d, want 8", got)
+	}
+}
+
+func TestJPEGOrientationMalformed(t *testing.T) {
+	jpg := testJPEG(t, 4, 2)
+	valid := withAPP1(jpg, exifPayload(orientationTIFF(binary.BigEndian, 6)))
+
+	shortLength := append([]byte{}, valid...)
+	binary.BigEndian.PutUint16(shortLength[4:], 1)
+
+	longLength := append([]byte{}, valid...)
+	binary.BigEndian.PutUint16(longLength[4:], 0xFFFF)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"not a JPEG", []byte("\x89PNG\r\n\x1a\n")},
+		{"only SOI", []byte{0xFF, 0xD8}},
+		{"segment length below 2", shortLength},
+		{"segment length past the end", longLength},
+		{"garbage instead of a marker", append([]byte{0xFF, 0xD8, 0x00}, valid[2:]...)},
+		{"EXIF header without TIFF", withAPP1(jpg, []byte("Exif\x00\x00"))},
+		{"EXIF after the scan", append(append([]byte{}, jpg[:len(jpg)-2]...), withAPP1([]byte{0xFF, 0xD8}, exifPayload(orientationTIFF(binary.BigEndian, 6)))[2:]...)},
+	}
+	for _, tt := range tests {
+		if got := jpegOrientation(tt.data); got != 1 {
+			t.Errorf("%s: got %d, want 1", tt.name, got)
+		}
+	}
+
+	// Every truncation of a valid file must be handled without panicking
+	for n := 0; n <= len(valid); n++ {
+		if got := jpegOrientation(valid[:n]); got < 1 || got > 8 {
+			t.Fatalf("truncated to %d bytes: got %d", n, got)
+		}
+	}
+}
+
+func FuzzJPEGOrientation(f *testing.F) {
+	jpg := testJPEG(f, 4, 2)
+	f.Add(jpg)
+	f.Add(withAPP1(jpg, exifPayload(orientationTIFF(binary.LittleEndian, 6))))
+	f.Add(withAPP1(jpg, exifPayload(orientationTIFF(binary.BigEndian, 3))))
+	f.Add([]byte{0xFF, 0xD8, 0xFF, 0xE1, 0x00, 0x02})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if got := jpegOrientation(data); got < 1 || got > 8 {
+			t.Fatalf("got %d", got)
+		}
+	})
+}
+
+func TestApplyOrientation(t *testing.T) {
+	const w, h = 3, 2
+	src := image.NewNRGBA(image.Rect(0, 0, w, h))
+	marked := color.NRGBA{R: 0xff, A: 0xff}
+	src.SetNRGBA(0, 0, marked)
+
+	// Where the source's top-left pixel ends up
+	tests := []struct {
+		orientation int
+		w, h        int
+		x, y        int
+	}{
+		{1, w, h, 0, 0},
+		{2, w, h, w - 1, 0},
+		{3, w, h, w - 1, h - 1},
+		{4, w, h, 0, h - 1},
+		{5, h, w, 0, 0},
+		{6, h, w, h - 1, 0},
+		{7, h, w, h - 1, w - 1},
+		{8, h, w, 0, w - 1},
+	}
+	for _, tt := range tests {
+		img := applyOrientation(src, tt.orientation)
+		b := img.Bounds()
+		if b.Dx() != tt.w || b.Dy() != tt.h {
+			t.Errorf("orientation %d: size %dx%d, want %dx%d", tt.orientation, b.Dx(), b.Dy(), tt.w, tt.h)
+			continue
+		}
+		if got := color.NRGBAModel.Convert(img.At(tt.x, tt.y)); got != marked {
+			t.Errorf("orientation %d: pixel (%d,%d) = %v, want the marked pixel", tt.orientation, tt.x, tt.y, got)
+		}
+	}
+}
+
+func TestDecodeAppliesOrientation(t *testing.T) {
+	jpg := testJPEG(t, 4, 2)
+	for o := 1; o <= 8; o++ {
+		data := withAPP1(jpg, exifPayload(orientationTIFF(binary.LittleEndian, o)))
+		img, _, err := Decode(data, 1<<20)
+		if err != nil {
+			t.Fatalf("orientation %d: %v", o, err)
+		}
+		w, h := 4, 2
+		if o >= 5 {
+			w, h = 2, 4
+		}
+		if b := img.Bounds(); b.Dx() != w || b.Dy() != h {
+			t.Errorf("orientation %d: size %dx%d, want %dx%d", o, b.Dx(), b.Dy(), w, h)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package media
+
+import (
+	"image"
+	"image/color"
+)
+
+// Square crops img to its centered square and scales it down to size x size pixels.
+// Each output pixel averages the source pixels it covers. Images are never scaled up,
+// so the result is smaller than size when the source is.
+func Square(img image.Image, size int) *image.NRGBA {
+	b := img.Bounds()
+	side := b.Dx()
+	if b.Dy() < side {
+		side = b.Dy()
+	}
+	if size > side {
+		size = side
+	}
+	x0 := b.Min.X + (b.Dx()-side)/2
+	y0 := b.Min.Y + (b.Dy()-side)/2
+
+	dst := image.NewNRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		sy0, sy1 := span(y, size, side)
+		for x := 0; x < size; x++ {
+			sx0, sx1 := span(x, size, side)
+
+			// Average in premultiplied space so transparent pixels don't bleed color
+			var r, g, bl, a, n uint64
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					pr, pg, pb, pa := img.At(x0+sx, y0+sy).RGBA()
+					r += uint64(pr)
+					g += uint64(pg)
+					bl += uint64(pb)
+					a += uint64(pa)
+					n++
+				}
+			}
+			dst.SetNRGBA(x, y, unpremultiply(r/n, g/n, bl/n, a/n))
+		}
+	}
+	return dst
+}
+
+// span returns the source range [from, to) that output pixel i of size covers
+func span(i, size, side int) (int, int) {
+	from := i * side / size
+	to := (i + 1) * side / size
+	if to <= from {
+		to = from + 1
+	}
+	return from, to
+}
+
+func unpremultiply(r, g, b, a uint64) color.NRGBA {
+	if a == 0 {
+		return color.NRGBA{}
+	}
+	return color.NRGBA{
+		R: uint8(r * 0xffff / a >> 8),
+		G: uint8(g * 0xffff / a >> 8),
+		B: uint8(b * 0xffff / a >> 8),
+		A: uint8(a >> 8),
+	}
+}
@@ -0,0 +1,74 @@
+package media
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestSquareSizes(t *testing.T) {
+	tests := []struct {
+		name string
+		rect image.Rectangle
+		size int
+		want int
+	}{
+		{"landscape", image.Rect(0, 0, 100, 50), 32, 32},
+		{"portrait", image.Rect(0, 0, 50, 100), 32, 32},
+		{"exact", image.Rect(0, 0, 64, 64), 64, 64},
+		{"not scaled up", image.Rect(0, 0, 50, 100), 64, 50},
+		{"uneven ratio", image.Rect(0, 0, 7, 3), 2, 2},
+		{"single pixel", image.Rect(0, 0, 1, 1), 64, 1},
+		{"offset bounds", image.Rect(10, 20, 110, 70), 32, 32},
+	}
+	for _, tt := range tests {
+		dst := Square(image.NewNRGBA(tt.rect), tt.size)
+		if b := dst.Bounds(); b != image.Rect(0, 0, tt.want, tt.want) {
+			t.Errorf("%s: bounds %v, want %dx%d", tt.name, b, tt.want, tt.want)
+		}
+	}
+}
+
+func TestSquareCropsCenter(t *testing.T) {
+	// Red edges around a blue center square
+	red := color.NRGBA{R: 0xff, A: 0xff}
+	blue := color.NRGBA{B: 0xff, A: 0xff}
+	src := image.NewNRGBA(image.Rect(0, 0, 6, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 6; x++ {
+			c := red
+			if x == 2 || x == 3 {
+				c = blue
+			}
+			src.SetNRGBA(x, y, c)
+		}
+	}
+
+	if got := Square(src, 1).NRGBAAt(0, 0); got != blue {
+		t.Errorf("got %v, want %v", got, blue)
+	}
+}
+
+func TestSquareAverages(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b color.NRGBA
+		want color.NRGBA
+	}{
+		{"black and white", color.NRGBA{A: 0xff}, color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}, color.NRGBA{R: 0x7f, G: 0x7f, B: 0x7f, A: 0xff}},
+		{"transparent doesn't bleed", color.NRGBA{R: 0xff}, color.NRGBA{B: 0xff, A: 0xff}, color.NRGBA{B: 0xff, A: 0x7f}},
+		{"fully transparent", color.NRGBA{}, color.NRGBA{}, color.NRGBA{}},
+	}
+	for _, tt := range tests {
+		// A 2x2 checkerboard of a and b scaled to one pixel
+		src := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+		src.SetNRGBA(0, 0, tt.a)
+		src.SetNRGBA(1, 1, tt.a)
+		src.SetNRGBA(1, 0, tt.b)
+		src.SetNRGBA(0, 1, tt.b)
+
+		if got := Square(src, 1).NRGBAAt(0, 0); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminMiddleware only lets through users whose email is listed in ADMIN_EMAILS
+// (comma separated). It must run after AuthMiddleware.
+func AdminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		email, _ := c.Get("email")
+		emailStr, _ := email.(string)
+
+		if emailStr == "" || !isAdminEmail(emailStr) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func isAdminEmail(email string) bool {
+	for _, admin := range strings.Split(os.Getenv("ADMIN_EMAILS"), ",") {
+		if strings.EqualFold(strings.TrimSpace(admin), email) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,211 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"scene-on/backend/config"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultIdempotencyKeyTTL is how long a stored response can be replayed (IDEMPOTENCY_KEY_TTL)
+const DefaultIdempotencyKeyTTL = 24 * time.Hour
+
+// DefaultIdempotencyClaimTimeout is how long a claimed key may go without a stored
+// response before a retry takes it over (IDEMPOTENCY_CLAIM_TIMEOUT). It only matters
+// when the process died while handling the first request.
+const DefaultIdempotencyClaimTimeout = 2 * time.Minute
+
+// maxIdempotencyKeyLength bounds the Idempotency-Key header
+const maxIdempotencyKeyLength = 255
+
+// maxIdempotentBodyBytes bounds the request body read for fingerprinting. The routes
+// using the middleware take small JSON bodies.
+const maxIdempotentBodyBytes = 64 << 10
+
+// idempotencyWriter keeps a copy of the response body so it can be replayed
+type idempotencyWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *idempotencyWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *idempotencyWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// IdempotencyMiddleware makes a route safe to retry. When a request carries an
+// Idempotency-Key header, the first response is stored per user, scope and key, and
+// retries with the same key get it back instead of running the handler again.
+// Only successful responses are stored, and they replay as 200 OK with an
+// Idempotent-Replayed header, like a retried chat message. Any other outcome (errors,
+// rate limits, a panic) releases the key so the retry runs for real. Requests without
+// the header run normally. It must run after AuthMiddleware.
+func IdempotencyMiddleware(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader("Idempotency-Key")
+		if key == "" {
+			c.Next()
+			return
+		}
+
+		if len(key) > maxIdempotencyKeyLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key is too long", "code": "INVALID_IDEMPOTENCY_KEY"})
+			c.Abort()
+			return
+		}
+
+		userID, ok := GetUserID(c)
+		if !ok {
+			c.Next()
+			return
+		}
+
+		// Fingerprint the body so a key can't be reused for a different request
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxIdempotentBodyBytes))
+		if err != nil {
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request body is too large"})
+				c.Abort()
+				return
+			}
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(body)
+		requestHash := hex.EncodeToString(sum[:])
+
+		ttl := config.GetDuration("IDEMPOTENCY_KEY_TTL", DefaultIdempotencyKeyTTL)
+		claimTimeout := config.GetDuration("IDEMPOTENCY_CLAIM_TIMEOUT", DefaultIdempotencyClaimTimeout)
+
+		// Claim the key. Stored responses past the TTL are dropped first so the key can be
+		// used again, and so are claims that never got a response, which the process
+		// handling them must have died with.
+		now := time.Now()
+		_, err = config.DB.Exec(
+			`DELETE FROM idempotency_keys
+			 WHERE user_id = $1 AND scope = $2 AND key = $3
+			 AND (created_at < $4 OR (status_code IS NULL AND created_at < $5))`,
+			userID, scope, key, now.Add(-ttl), now.Add(-claimTimeout),
+		)
+		if err != nil {
+			log.Printf("Failed to drop stale idempotency key: %v", err)
+		}
+
+		// The claim's created_at identifies it, so a request whose claim was taken over
+		// can't release or overwrite the new one
+		var claimedAt time.Time
+		err = config.DB.QueryRow(
+			`INSERT INTO idempotency_keys (user_id, scope, key, request_hash)
+			 VALUES ($1, $2, $3, $4)
+			 ON CONFLICT (user_id, scope, key) DO NOTHING
+			 RETURNING created_at`,
+			userID, scope, key, requestHash,
+		).Scan(&claimedAt)
+		if err == sql.ErrNoRows {
+			replayIdempotentResponse(c, userID.String(), scope, key, requestHash)
+			c.Abort()
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to claim idempotency key: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process request"})
+			c.Abort()
+			return
+		}
+
+		// Released unless a response gets stored, including when the handler panics
+		stored := false
+		defer func() {
+			if stored {
+				return
+			}
+			_, err := config.DB.Exec(
+				`DELETE FROM idempotency_keys
+				 WHERE user_id = $1 AND scope = $2 AND key = $3 AND created_at = $4`,
+				userID, scope, key, claimedAt,
+			)
+			if err != nil {
+				log.Printf("Failed to release idempotency key: %v", err)
+			}
+		}()
+
+		writer := &idempotencyWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+
+		status := writer.Status()
+		if status < 200 || status >= 300 {
+			return
+		}
+
+		_, err = config.DB.Exec(
+			`UPDATE idempotency_keys SET status_code = $1, response_body = $2
+			 WHERE user_id = $3 AND scope = $4 AND key = $5 AND created_at = $6`,
+			status, writer.body.Bytes(), userID, scope, key, claimedAt,
+		)
+		if err != nil {
+			log.Printf("Failed to store idempotent response: %v", err)
+			return
+		}
+		stored = true
+	}
+}
+
+// replayIdempotentResponse answers a retry with the response stored for its key
+func replayIdempotentResponse(c *gin.Context, userID, scope, key, requestHash string) {
+	var storedHash string
+	var status sql.NullInt64
+	var body []byte
+	err := config.DB.QueryRow(
+		`SELECT request_hash, status_code, response_body FROM idempotency_keys
+		 WHERE user_id = $1 AND scope = $2 AND key = $3`,
+		userID, scope, key,
+	).Scan(&storedHash, &status, &body)
+
+	if err == sql.ErrNoRows {
+		// The first request failed and released the key in the meantime
+		c.JSON(http.StatusConflict, gin.H{"error": "Request is being retried, try again", "code": "IDEMPOTENCY_IN_PROGRESS"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load idempotency key: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process request"})
+		return
+	}
+
+	if storedHash != requestHash {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error": "Idempotency-Key was already used for a different request",
+			"code":  "IDEMPOTENCY_KEY_REUSED",
+		})
+		return
+	}
+
+	if !status.Valid {
+		c.JSON(http.StatusConflict, gin.H{"error": "The original request is still in progress", "code": "IDEMPOTENCY_IN_PROGRESS"})
+		return
+	}
+
+	code := int(status.Int64)
+	if code >= 200 && code < 300 {
+		code = http.StatusOK
+	}
+	c.Header("Idempotent-Replayed", "true")
+	c.Data(code, "application/json; charset=utf-8", body)
+}
@@ -53,14 +53,37 @@ type Persona struct {
 }
 
 type Scene struct {
-	ID        uuid.UUID `json:"id"`
-	PersonaID uuid.UUID `json:"persona_id"`
-	Latitude  float64   `json:"latitude"`
-	Longitude float64   `json:"longitude"`
-	IsActive  bool      `json:"is_active"`
-	StartedAt time.Time `json:"started_at"`
-	ExpiresAt time.Time `json:"expires_at"`
-	CreatedAt time.Time `json:"created_at"`
+	ID        uuid.UUID  `json:"id"`
+	PersonaID uuid.UUID  `json:"persona_id"`
+	Latitude  float64    `json:"latitude"`
+	Longitude float64    `json:"longitude"`
+	VenueID   *uuid.UUID `json:"venue_id,omitempty"`
+	PublicKey *string    `json:"public_key,omitempty"` // Ephemeral X25519 key for E2E chats (base64)
+	IsActive  bool       `json:"is_active"`
+	StartedAt time.Time  `json:"started_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// Venue is a named geofenced area. Boundary is the polygon as GeoJSON.
+type Venue struct {
+	ID        uuid.UUID       `json:"id"`
+	Name      string          `json:"name"`
+	Boundary  json.RawMessage `json:"boundary"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// SceneRecap summarises a scene at StopScene time. It only carries counts,
+// never content or counterpart identities.
+type SceneRecap struct {
+	SceneID           uuid.UUID `json:"scene_id"`
+	DurationSeconds   int64     `json:"duration_seconds"`
+	RequestsSent      int       `json:"requests_sent"`
+	RequestsReceived  int       `json:"requests_received"`
+	ChatsAccepted     int       `json:"chats_accepted"`
+	MessagesExchanged int       `json:"messages_exchanged"`
+	YellsPosted       int       `json:"yells_posted"`
+	PeakNearbyScenes  int       `json:"peak_nearby_scenes"`
 }
 
 type Yell struct {
@@ -71,22 +94,84 @@ type Yell struct {
 }
 
 type ChatRequest struct {
-	ID          uuid.UUID  `json:"id"`
-	FromSceneID uuid.UUID  `json:"from_scene_id"`
-	ToSceneID   uuid.UUID  `json:"to_scene_id"`
-	Message     *string    `json:"message,omitempty"`
-	Status      string     `json:"status"` // pending, accepted, rejected, expired
-	AcceptedAt  *time.Time `json:"accepted_at,omitempty"`
-	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
-	CreatedAt   time.Time  `json:"created_at"`
+	ID             uuid.UUID  `json:"id"`
+	FromSceneID    uuid.UUID  `json:"from_scene_id"`
+	ToSceneID      uuid.UUID  `json:"to_scene_id"`
+	Message        *string    `json:"message,omitempty"`
+	Status         string     `json:"status"` // pending, accepted, rejected, expired
+	AcceptedAt     *time.Time `json:"accepted_at,omitempty"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	ExtensionCount int        `json:"extension_count"`
+	E2E            bool       `json:"e2e"` // Both scenes had public keys when the chat was accepted
+	CreatedAt      time.Time  `json:"created_at"`
 }
 
 type ChatMessage struct {
-	ID            uuid.UUID `json:"id"`
-	ChatRequestID uuid.UUID `json:"chat_request_id"`
-	FromSceneID   uuid.UUID `json:"from_scene_id"`
-	Content       string    `json:"content"`
-	CreatedAt     time.Time `json:"created_at"`
+	ID            uuid.UUID       `json:"id"`
+	ChatRequestID uuid.UUID       `json:"chat_request_id"`
+	FromSceneID   uuid.UUID       `json:"from_scene_id"`
+	Content       string          `json:"content"`
+	Ciphertext    string          `json:"ciphertext,omitempty"`   // E2E chats only (base64)
+	CipherNonce   string          `json:"cipher_nonce,omitempty"` // E2E chats only (base64)
+	Edited        bool            `json:"edited"`
+	EditedAt      *time.Time      `json:"edited_at,omitempty"`
+	Status        string          `json:"status"` // sent, delivered or read
+	DeliveredAt   *time.Time      `json:"delivered_at,omitempty"`
+	ReadAt        *time.Time      `json:"read_at,omitempty"`
+	Reactions     map[string]int  `json:"reactions,omitempty"` // emoji -> count
+	AttachmentID  *uuid.UUID      `json:"attachment_id,omitempty"`
+	Attachment    *ChatAttachment `json:"attachment,omitempty"`
+	CreatedAt     time.Time       `json:"created_at"`
+}
+
+// ChatAttachment is an image shared in a chat. URL is a short-lived signed link.
+type ChatAttachment struct {
+	ID           uuid.UUID `json:"id"`
+	MimeType     string    `json:"mime_type"`
+	SizeBytes    int       `json:"size_bytes"`
+	Width        int       `json:"width"`
+	Height       int       `json:"height"`
+	URL          string    `json:"url"`
+	URLExpiresAt time.Time `json:"url_expires_at"`
+}
+
+// GroupRoom is an ephemeral chat among several nearby scenes. It ends when the
+// creator's scene ends or ExpiresAt passes.
+type GroupRoom struct {
+	ID             uuid.UUID     `json:"id"`
+	CreatorSceneID uuid.UUID     `json:"creator_scene_id"`
+	Name           string        `json:"name"`
+	ExpiresAt      time.Time     `json:"expires_at"`
+	CreatedAt      time.Time     `json:"created_at"`
+	Members        []GroupMember `json:"members"`
+}
+
+// GroupMember is a scene invited to or joined in a group room
+type GroupMember struct {
+	SceneID     uuid.UUID  `json:"scene_id"`
+	PersonaName string     `json:"persona_name"`
+	Status      string     `json:"status"` // invited, joined
+	JoinedAt    *time.Time `json:"joined_at,omitempty"`
+}
+
+type GroupMessage struct {
+	ID          uuid.UUID `json:"id"`
+	RoomID      uuid.UUID `json:"room_id"`
+	FromSceneID uuid.UUID `json:"from_scene_id"`
+	Content     string    `json:"content"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// NotificationPreferences controls which broadcast events reach a user.
+// Quiet hours are "HH:MM" in the user's timezone; both must be set to apply.
+type NotificationPreferences struct {
+	UserID          uuid.UUID `json:"user_id"`
+	RadiusMeters    int       `json:"radius_meters"`
+	MutedEvents     []string  `json:"muted_events"`
+	QuietHoursStart *string   `json:"quiet_hours_start,omitempty"`
+	QuietHoursEnd   *string   `json:"quiet_hours_end,omitempty"`
+	Timezone        string    `json:"timezone"`
+	UpdatedAt       time.Time `json:"updated_at"`
 }
 
 type OTPCode struct {
@@ -0,0 +1,81 @@
+package moderation
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	// Links with a scheme, www. prefix, or a bare domain with a common TLD
+	linkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)\S+|\b[a-z0-9-]+(?:\.[a-z0-9-]+)*\.(?:com|net|org|io|co|me|app|ly|gg|info|biz|xyz|link|site|online)\b`)
+	// Seven to fifteen digits, optionally separated by single spaces, dots or dashes and
+	// with parentheses around the area code, so "555 123 4567" and "+1 (555) 123-4567"
+	// match but longer runs of digits don't
+	phonePattern = regexp.MustCompile(`(?:^|[^\w+(])\+?\(?\d(?:[\s.\-]?\(?\d\)?){6,14}\b`)
+	// Year ranges, dates and times, which would otherwise pass for phone numbers
+	datePattern = regexp.MustCompile(`\b(?:(?:19|20)\d{2}\s?[\-.]\s?(?:19|20)\d{2}|(?:19|20)\d{2}[\-.]\d{1,2}[\-.]\d{1,2}|\d{1,2}[\-.]\d{1,2}[\-.](?:19|20)\d{2}|\d{1,2}:\d{2})\b`)
+)
+
+// DefaultContactContexts are the contexts links and phone numbers are blocked in by
+// default. Private chats are left out: by then both sides agreed to talk, and
+// swapping numbers is often the point.
+var DefaultContactContexts = []Context{
+	ContextChatRequest,
+	ContextGroupMessage,
+	ContextPersonaName,
+	ContextPersonaBio,
+	ContextYell,
+}
+
+// containsPhone reports whether text has something that looks like a phone number
+func containsPhone(text string) bool {
+	return phonePattern.MatchString(datePattern.ReplaceAllString(text, " "))
+}
+
+// ContactFilter rejects links and phone numbers, which are the usual way to move a
+// conversation (or a scam) off the platform. Each is only checked in the contexts it
+// was enabled for.
+type ContactFilter struct {
+	links  map[Context]bool
+	phones map[Context]bool
+}
+
+func NewContactFilter(linkContexts, phoneContexts []Context) *ContactFilter {
+	f := &ContactFilter{links: make(map[Context]bool), phones: make(map[Context]bool)}
+	for _, ctx := range linkContexts {
+		f.links[ctx] = true
+	}
+	for _, ctx := range phoneContexts {
+		f.phones[ctx] = true
+	}
+	return f
+}
+
+// ParseContexts parses a comma-separated list of contexts. An empty value means
+// fallback and "none" means no context at all.
+func ParseContexts(value string, fallback []Context) []Context {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return fallback
+	}
+	contexts := []Context{}
+	if value == "none" {
+		return contexts
+	}
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			contexts = append(contexts, Context(name))
+		}
+	}
+	return contexts
+}
+
+func (f *ContactFilter) Moderate(in Input) Result {
+	if f.links[in.Context] && linkPattern.MatchString(in.Text) {
+		return Result{Action: Reject, Code: "LINK_NOT_ALLOWED", Reason: "Links are not allowed"}
+	}
+	if f.phones[in.Context] && containsPhone(in.Text) {
+		return Result{Action: Reject, Code: "PHONE_NOT_ALLOWED", Reason: "Phone numbers are not allowed"}
+	}
+	return Result{Action: Allow, Text: in.Text}
+}
@@ -0,0 +1,77 @@
+package moderation
+
+import "testing"
+
+func TestContainsPhone(t *testing.T) {
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{"call me 555 123 4567", true},
+		{"+1 (555) 123-4567", true},
+		{"+49 30 1234567", true},
+		{"5551234", true},
+		{"0 1 7 6 1 2 3 4 5", true},
+		{"I was there 2023-2024", false},
+		{"from 2019 - 2021", false},
+		{"see you 2024-03-15", false},
+		{"see you 15.03.2024 14:30", false},
+		{"order #123456", false},
+		{"1234567890123456789", false},
+		{"2023-2024, then 555 123 4567", true},
+		{"version1234567", false},
+	}
+	for _, tt := range tests {
+		if got := containsPhone(tt.text); got != tt.want {
+			t.Errorf("containsPhone(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestContactFilterContexts(t *testing.T) {
+	f := NewContactFilter([]Context{ContextYell}, []Context{ContextYell, ContextChatRequest})
+
+	tests := []struct {
+		ctx    Context
+		text   string
+		action Action
+		code   string
+	}{
+		{ContextYell, "visit www.example.com", Reject, "LINK_NOT_ALLOWED"},
+		{ContextYell, "555 123 4567", Reject, "PHONE_NOT_ALLOWED"},
+		{ContextYell, "meet at 20:30", Allow, ""},
+		{ContextChatRequest, "555 123 4567", Reject, "PHONE_NOT_ALLOWED"},
+		{ContextChatRequest, "example.com", Allow, ""},
+		{ContextChatMessage, "555 123 4567 or example.com", Allow, ""},
+	}
+	for _, tt := range tests {
+		r := f.Moderate(Input{Context: tt.ctx, Text: tt.text})
+		if r.Action != tt.action || r.Code != tt.code {
+			t.Errorf("%s %q: got %v %q, want %v %q", tt.ctx, tt.text, r.Action, r.Code, tt.action, tt.code)
+		}
+	}
+}
+
+func TestParseContexts(t *testing.T) {
+	fallback := []Context{ContextYell}
+	tests := []struct {
+		value string
+		want  []Context
+	}{
+		{"", fallback},
+		{"none", []Context{}},
+		{"chat_message, yell,", []Context{ContextChatMessage, ContextYell}},
+	}
+	for _, tt := range tests {
+		got := ParseContexts(tt.value, fallback)
+		if len(got) != len(tt.want) {
+			t.Errorf("ParseContexts(%q) = %v, want %v", tt.value, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("ParseContexts(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		}
+	}
+}
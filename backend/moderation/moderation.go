@@ -0,0 +1,115 @@
+package moderation
+
+import (
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"scene-on/backend/config"
+
+	"github.com/google/uuid"
+)
+
+// Action is a moderator's verdict on a piece of text
+type Action int
+
+const (
+	Allow Action = iota
+	Mask
+	Reject
+)
+
+// Context says where the text is going, since not every rule applies everywhere
+type Context string
+
+const (
+	ContextChatMessage  Context = "chat_message"
+	ContextChatRequest  Context = "chat_request"
+	ContextGroupMessage Context = "group_message"
+	ContextPersonaName  Context = "persona_name"
+	ContextPersonaBio   Context = "persona_description"
+	ContextYell         Context = "yell"
+)
+
+// Input is the text to check and who is sending it
+type Input struct {
+	Context Context
+	Text    string
+	UserID  uuid.UUID
+}
+
+// Result is a verdict. Text is the (possibly masked) text to store. Rejections carry a
+// machine-readable Code and a Reason shown to the user.
+type Result struct {
+	Action Action
+	Text   string
+	Code   string
+	Reason string
+}
+
+// Moderator checks user-supplied text before it is stored or delivered
+type Moderator interface {
+	Moderate(in Input) Result
+}
+
+// Pipeline runs moderators in order. Masks are passed on to the next moderator;
+// the first rejection wins.
+type Pipeline []Moderator
+
+func (p Pipeline) Moderate(in Input) Result {
+	result := Result{Action: Allow, Text: in.Text}
+	for _, m := range p {
+		r := m.Moderate(Input{Context: in.Context, Text: result.Text, UserID: in.UserID})
+		switch r.Action {
+		case Reject:
+			return r
+		case Mask:
+			result.Action = Mask
+			result.Text = r.Text
+		}
+	}
+	return result
+}
+
+// NewDefault builds the built-in pipeline from the environment:
+//
+//	MODERATION_WORDS        comma-separated blocked words
+//	MODERATION_WORDS_FILE   file with one blocked word per line
+//	MODERATION_WORD_ACTION  "mask" (default) or "reject"
+//	MODERATION_REPEAT_LIMIT identical messages allowed per window (default 3)
+//	MODERATION_REPEAT_MIN_LENGTH shortest text the repeat check counts (default 20)
+//	MODERATION_REPEAT_WINDOW window for the repeat check (default 1m)
+//	MODERATION_BLOCK_LINKS  comma-separated contexts links are rejected in, or "none"
+//	                        (default: everywhere except chat_message)
+//	MODERATION_BLOCK_PHONES same for phone numbers
+func NewDefault() Moderator {
+	var words []string
+	if list := os.Getenv("MODERATION_WORDS"); list != "" {
+		words = append(words, strings.Split(list, ",")...)
+	}
+	if path := os.Getenv("MODERATION_WORDS_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("⚠️  Failed to read MODERATION_WORDS_FILE %s: %v", path, err)
+		} else {
+			words = append(words, strings.Split(string(data), "\n")...)
+		}
+	}
+
+	wordList := NewWordList(words, os.Getenv("MODERATION_WORD_ACTION") == "reject")
+	log.Printf("🛡️  Content moderation enabled (%d blocked words)", wordList.Len())
+
+	return Pipeline{
+		wordList,
+		NewContactFilter(
+			ParseContexts(os.Getenv("MODERATION_BLOCK_LINKS"), DefaultContactContexts),
+			ParseContexts(os.Getenv("MODERATION_BLOCK_PHONES"), DefaultContactContexts),
+		),
+		NewRepeatDetector(
+			config.GetInt("MODERATION_REPEAT_LIMIT", 3),
+			config.GetInt("MODERATION_REPEAT_MIN_LENGTH", 20),
+			config.GetDuration("MODERATION_REPEAT_WINDOW", time.Minute),
+		),
+	}
+}
@@ -0,0 +1,60 @@
+package moderation
+
+import (
+	"strings"
+	"testing"
+)
+
+// stub returns a fixed action and records that it ran
+type stub struct {
+	action Action
+	code   string
+	seen   *[]string
+}
+
+func (s stub) Moderate(in Input) Result {
+	*s.seen = append(*s.seen, in.Text)
+	switch s.action {
+	case Reject:
+		return Result{Action: Reject, Code: s.code}
+	case Mask:
+		return Result{Action: Mask, Text: strings.ToUpper(in.Text)}
+	}
+	return Result{Action: Allow, Text: in.Text}
+}
+
+func TestPipelineOrdering(t *testing.T) {
+	tests := []struct {
+		name    string
+		actions []Action
+		action  Action
+		text    string
+		code    string
+		seen    []string
+	}{
+		{"empty", nil, Allow, "hi", "", nil},
+		{"all allow", []Action{Allow, Allow}, Allow, "hi", "", []string{"hi", "hi"}},
+		{"mask is passed on", []Action{Mask, Allow}, Mask, "HI", "", []string{"hi", "HI"}},
+		{"mask survives later allow", []Action{Allow, Mask, Allow}, Mask, "HI", "", []string{"hi", "hi", "HI"}},
+		{"reject stops the pipeline", []Action{Allow, Reject, Mask}, Reject, "", "R1", []string{"hi", "hi"}},
+		{"first reject wins", []Action{Reject, Reject}, Reject, "", "R0", []string{"hi"}},
+		{"reject after mask", []Action{Mask, Reject}, Reject, "", "R1", []string{"hi", "HI"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen []string
+			var p Pipeline
+			for i, a := range tt.actions {
+				p = append(p, stub{action: a, code: "R" + string(rune('0'+i)), seen: &seen})
+			}
+
+			r := p.Moderate(Input{Context: ContextChatMessage, Text: "hi"})
+			if r.Action != tt.action || r.Text != tt.text || r.Code != tt.code {
+				t.Errorf("got %v %q %q, want %v %q %q", r.Action, r.Text, r.Code, tt.action, tt.text, tt.code)
+			}
+			if strings.Join(seen, ",") != strings.Join(tt.seen, ",") {
+				t.Errorf("moderators saw %q, want %q", seen, tt.seen)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package moderation
+
+import (
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// RepeatDetector rejects a user sending the same text more than limit times within
+// window, across chats. Texts shorter than minLength runes ("ok", "haha") are never
+// counted, since everyone repeats those. Persona fields are not checked.
+type RepeatDetector struct {
+	limit     int
+	minLength int
+	window    time.Duration
+	now       func() time.Time
+
+	mutex     sync.Mutex
+	history   map[uuid.UUID][]sentText
+	lastSweep time.Time
+}
+
+type sentText struct {
+	text string
+	at   time.Time
+}
+
+func NewRepeatDetector(limit, minLength int, window time.Duration) *RepeatDetector {
+	return &RepeatDetector{
+		limit:     limit,
+		minLength: minLength,
+		window:    window,
+		now:       time.Now,
+		history:   make(map[uuid.UUID][]sentText),
+	}
+}
+
+func (d *RepeatDetector) Moderate(in Input) Result {
+	if d.limit <= 0 || in.UserID == uuid.Nil ||
+		in.Context == ContextPersonaName || in.Context == ContextPersonaBio {
+		return Result{Action: Allow, Text: in.Text}
+	}
+
+	text := strings.ToLower(strings.Join(strings.Fields(in.Text), " "))
+	if utf8.RuneCountInString(text) < d.minLength {
+		return Result{Action: Allow, Text: in.Text}
+	}
+	now := d.now()
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	// Forget users who have been idle for a whole window
+	if now.Sub(d.lastSweep) > d.window {
+		for userID, sent := range d.history {
+			if len(sent) == 0 || now.Sub(sent[len(sent)-1].at) >= d.window {
+				delete(d.history, userID)
+			}
+		}
+		d.lastSweep = now
+	}
+
+	// Drop entries that fell out of the window
+	recent := d.history[in.UserID][:0]
+	for _, s := range d.history[in.UserID] {
+		if now.Sub(s.at) < d.window {
+			recent = append(recent, s)
+		}
+	}
+
+	repeats := 0
+	for _, s := range recent {
+		if s.text == text {
+			repeats++
+		}
+	}
+	if repeats >= d.limit {
+		d.history[in.UserID] = recent
+		return Result{Action: Reject, Code: "REPEATED_MESSAGE", Reason: "You are sending the same message too often"}
+	}
+
+	d.history[in.UserID] = append(recent, sentText{text: text, at: now})
+	return Result{Action: Allow, Text: in.Text}
+}
@@ -0,0 +1,89 @@
+package moderation
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestRepeatDetectorWindow(t *testing.T) {
+	const spam = "come find me at the north entrance"
+	alice, bob := uuid.New(), uuid.New()
+
+	type send struct {
+		after  time.Duration // since the previous send
+		user   uuid.UUID
+		ctx    Context
+		text   string
+		action Action
+	}
+	tests := []struct {
+		name  string
+		sends []send
+	}{
+		{"under the limit", []send{
+			{0, alice, ContextChatMessage, spam, Allow},
+			{time.Second, alice, ContextChatRequest, spam, Allow},
+		}},
+		{"over the limit across chats", []send{
+			{0, alice, ContextChatMessage, spam, Allow},
+			{time.Second, alice, ContextChatRequest, spam, Allow},
+			{time.Second, alice, ContextYell, spam, Reject},
+		}},
+		{"case and spacing ignored", []send{
+			{0, alice, ContextChatMessage, spam, Allow},
+			{time.Second, alice, ContextChatMessage, "Come  find me at the NORTH entrance", Allow},
+			{time.Second, alice, ContextChatMessage, " come find me at the north entrance ", Reject},
+		}},
+		{"window slides", []send{
+			{0, alice, ContextChatMessage, spam, Allow},
+			{30 * time.Second, alice, ContextChatMessage, spam, Allow},
+			{31 * time.Second, alice, ContextChatMessage, spam, Allow},
+			{time.Second, alice, ContextChatMessage, spam, Reject},
+		}},
+		{"rejections don't extend the window", []send{
+			{0, alice, ContextChatMessage, spam, Allow},
+			{time.Second, alice, ContextChatMessage, spam, Allow},
+			{time.Second, alice, ContextChatMessage, spam, Reject},
+			{50 * time.Second, alice, ContextChatMessage, spam, Reject},
+			{9 * time.Second, alice, ContextChatMessage, spam, Allow},
+		}},
+		{"per user", []send{
+			{0, alice, ContextChatMessage, spam, Allow},
+			{time.Second, alice, ContextChatMessage, spam, Allow},
+			{time.Second, bob, ContextChatMessage, spam, Allow},
+			{time.Second, bob, ContextChatMessage, spam, Allow},
+		}},
+		{"short texts not counted", []send{
+			{0, alice, ContextChatMessage, "ok", Allow},
+			{time.Second, alice, ContextChatMessage, "ok", Allow},
+			{time.Second, alice, ContextChatMessage, "ok", Allow},
+		}},
+		{"persona fields skipped", []send{
+			{0, alice, ContextPersonaBio, spam, Allow},
+			{time.Second, alice, ContextPersonaBio, spam, Allow},
+			{time.Second, alice, ContextPersonaBio, spam, Allow},
+		}},
+		{"anonymous skipped", []send{
+			{0, uuid.Nil, ContextChatMessage, spam, Allow},
+			{time.Second, uuid.Nil, ContextChatMessage, spam, Allow},
+			{time.Second, uuid.Nil, ContextChatMessage, spam, Allow},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewRepeatDetector(2, 20, time.Minute)
+			clock := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+			d.now = func() time.Time { return clock }
+
+			for i, s := range tt.sends {
+				clock = clock.Add(s.after)
+				r := d.Moderate(Input{Context: s.ctx, Text: s.text, UserID: s.user})
+				if r.Action != s.action {
+					t.Fatalf("send %d: action = %v, want %v", i, r.Action, s.action)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package moderation
+
+import (
+	"strings"
+	"unicode"
+)
+
+// leetspeak maps look-alike characters back to letters
+var leetspeak = map[rune]rune{
+	'0': 'o',
+	'1': 'i',
+	'3': 'e',
+	'4': 'a',
+	'5': 's',
+	'7': 't',
+	'8': 'b',
+	'@': 'a',
+	'$': 's',
+	'!': 'i',
+	'|': 'l',
+}
+
+// normalize lowercases a token, undoes leetspeak and collapses repeated letters,
+// so "Sh1iiit" and "shit" compare equal
+func normalize(token string) string {
+	var b strings.Builder
+	var last rune
+	for _, r := range strings.ToLower(token) {
+		if mapped, ok := leetspeak[r]; ok {
+			r = mapped
+		}
+		if !unicode.IsLetter(r) || r == last {
+			continue
+		}
+		b.WriteRune(r)
+		last = r
+	}
+	return b.String()
+}
+
+// isTokenRune reports whether r can be part of a word, leetspeak included
+func isTokenRune(r rune) bool {
+	_, leet := leetspeak[r]
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || leet
+}
+
+// WordList masks (or rejects) text containing blocked words. Persona names are always
+// rejected rather than masked.
+type WordList struct {
+	words  map[string]bool
+	reject bool
+}
+
+func NewWordList(words []string, reject bool) *WordList {
+	w := &WordList{words: make(map[string]bool), reject: reject}
+	for _, word := range words {
+		if n := normalize(strings.TrimSpace(word)); n != "" {
+			w.words[n] = true
+		}
+	}
+	return w
+}
+
+// Len returns the number of blocked words
+func (w *WordList) Len() int {
+	return len(w.words)
+}
+
+// matches checks a token, also without trailing "!" or "|", which are leetspeak inside
+// a word but usually punctuation at its end
+func (w *WordList) matches(token string) bool {
+	return w.words[normalize(token)] || w.words[normalize(strings.TrimRight(token, "!|"))]
+}
+
+func (w *WordList) Moderate(in Input) Result {
+	if len(w.words) == 0 {
+		return Result{Action: Allow, Text: in.Text}
+	}
+
+	runes := []rune(in.Text)
+	masked := make([]rune, len(runes))
+	copy(masked, runes)
+	found := false
+
+	for start := 0; start < len(runes); {
+		if !isTokenRune(runes[start]) {
+			start++
+			continue
+		}
+		end := start
+		for end < len(runes) && isTokenRune(runes[end]) {
+			end++
+		}
+		if w.matches(string(runes[start:end])) {
+			found = true
+			for i := start; i < end; i++ {
+				masked[i] = '*'
+			}
+		}
+		start = end
+	}
+
+	if !found {
+		return Result{Action: Allow, Text: in.Text}
+	}
+	if w.reject || in.Context == ContextPersonaName {
+		return Result{Action: Reject, Code: "BLOCKED_WORDS", Reason: "Contains words that are not allowed"}
+	}
+	return Result{Action: Mask, Text: string(masked)}
+}
@@ -0,0 +1,68 @@
+package moderation
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		token string
+		want  string
+	}{
+		{"shit", "shit"},
+		{"SHIT", "shit"},
+		{"Sh1iiit", "shit"},
+		{"$h!t", "shit"},
+		{"5h17", "shit"},
+		{"b00bs", "bobs"},
+		{"h3ll0", "helo"},
+		{"a-b_c", "abc"},
+		{"2", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := normalize(tt.token); got != tt.want {
+			t.Errorf("normalize(%q) = %q, want %q", tt.token, got, tt.want)
+		}
+	}
+}
+
+func TestWordListMasking(t *testing.T) {
+	words := NewWordList([]string{"shit", " Darn ", ""}, false)
+	if words.Len() != 2 {
+		t.Fatalf("Len() = %d, want 2", words.Len())
+	}
+
+	tests := []struct {
+		name   string
+		ctx    Context
+		text   string
+		action Action
+		want   string
+	}{
+		{"clean", ContextChatMessage, "hello there", Allow, "hello there"},
+		{"plain word", ContextChatMessage, "oh shit", Mask, "oh ****"},
+		{"leetspeak", ContextChatMessage, "oh $h1t!", Mask, "oh *****"},
+		{"stretched", ContextChatMessage, "darnnn it", Mask, "****** it"},
+		{"punctuation kept", ContextChatMessage, "shit, darn.", Mask, "****, ****."},
+		{"inside a word", ContextChatMessage, "shitake", Allow, "shitake"},
+		{"unicode around", ContextChatMessage, "ça shit ü", Mask, "ça **** ü"},
+		{"persona name rejects", ContextPersonaName, "darn", Reject, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := words.Moderate(Input{Context: tt.ctx, Text: tt.text})
+			if r.Action != tt.action {
+				t.Fatalf("action = %v, want %v", r.Action, tt.action)
+			}
+			if tt.action != Reject && r.Text != tt.want {
+				t.Errorf("text = %q, want %q", r.Text, tt.want)
+			}
+		})
+	}
+}
+
+func TestWordListRejectMode(t *testing.T) {
+	r := NewWordList([]string{"darn"}, true).Moderate(Input{Context: ContextChatMessage, Text: "d4rn"})
+	if r.Action != Reject || r.Code != "BLOCKED_WORDS" {
+		t.Errorf("got %v %q, want Reject BLOCKED_WORDS", r.Action, r.Code)
+	}
+}
@@ -1,8 +1,11 @@
 package routes
 
 import (
+	"scene-on/backend/geo"
 	"scene-on/backend/handlers"
 	"scene-on/backend/middleware"
+	"scene-on/backend/moderation"
+	"scene-on/backend/storage"
 	"scene-on/backend/websocket"
 
 	"github.com/gin-gonic/gin"
@@ -10,7 +13,10 @@ import (
 )
 
 // SetupRoutes configures all application routes
-func SetupRoutes(router *gin.Engine, wsHub *websocket.Hub) {
+func SetupRoutes(router *gin.Engine, wsHub *websocket.Hub, geoIndex geo.GeoIndex, blobs storage.BlobStore, mod moderation.Moderator) {
+	// WebSocket commands (client -> server)
+	handlers.RegisterWebSocketCommands(wsHub)
+
 	// WebSocket endpoint
 	router.GET("/ws", func(c *gin.Context) {
 		sceneIDStr := c.Query("scene_id")
@@ -22,6 +28,16 @@ func SetupRoutes(router *gin.Engine, wsHub *websocket.Hub) {
 			}
 		}
 
+		// Authenticate the connection when a token is supplied so it may send commands.
+		// The token comes in Sec-WebSocket-Protocol, never the query string, which the
+		// request logger writes out.
+		var userID uuid.UUID
+		if token := websocket.TokenFromRequest(c.Request); token != "" && sceneID != uuid.Nil {
+			if claims, err := middleware.ParseToken(token); err == nil && handlers.SceneOwnedBy(sceneID, claims.UserID) {
+				userID = claims.UserID
+			}
+		}
+
 		conn, err := websocket.Upgrader.Upgrade(c.Writer, c.Request, nil)
 		if err != nil {
 			return
@@ -30,10 +46,15 @@ func SetupRoutes(router *gin.Engine, wsHub *websocket.Hub) {
 		client := &websocket.Client{
 			ID:      uuid.New(),
 			SceneID: sceneID,
+			UserID:  userID,
 			Conn:    conn,
 			Send:    make(chan websocket.Message, 256),
 			Hub:     wsHub,
 		}
+		if sceneID != uuid.Nil {
+			client.Prefs = websocket.LoadScenePreferences(sceneID)
+			client.Blocked = websocket.LoadSceneBlocks(sceneID)
+		}
 
 		wsHub.Register <- client
 
@@ -58,6 +79,11 @@ func SetupRoutes(router *gin.Engine, wsHub *websocket.Hub) {
 			auth.POST("/google/dummy", handlers.DummyGoogleLogin)
 		}
 
+		// Chat attachments are authorized by their signed URL rather than a bearer token
+		v1.GET("/chat/attachments/:id", handlers.GetChatAttachment(blobs))
+		// Avatars are public so they load anywhere a persona is shown
+		v1.GET("/avatars/:id/:variant", handlers.GetAvatar(blobs))
+
 		// Protected routes (require authentication)
 		protected := v1.Group("")
 		protected.Use(middleware.AuthMiddleware())
@@ -73,16 +99,58 @@ func SetupRoutes(router *gin.Engine, wsHub *websocket.Hub) {
 			personas := protected.Group("/personas")
 			{
 				personas.GET("", handlers.GetUserPersonas)
-				personas.POST("", handlers.GetOrCreatePersona)
+				personas.POST("", handlers.CreatePersona(mod))
+				personas.PATCH("/:id", handlers.UpdatePersona(wsHub, blobs, mod))
+				personas.DELETE("/:id", handlers.DeletePersona(blobs))
+				personas.POST("/:id/avatar", handlers.UploadPersonaAvatar(wsHub, blobs))
+				personas.POST("/:id/block", handlers.BlockPersona(wsHub))
+				personas.DELETE("/:id/block", handlers.UnblockPersona(wsHub))
 			}
 
+			// Abuse reports
+			protected.POST("/reports", handlers.CreateReport)
+
 			// Scenes
 			scenes := protected.Group("/scenes")
 			{
-				scenes.POST("/start", handlers.StartScene(wsHub))
-				scenes.POST("/stop", handlers.StopScene(wsHub))
+				scenes.POST("/start", middleware.IdempotencyMiddleware("scenes.start"), handlers.StartScene(wsHub, geoIndex))
+				scenes.POST("/stop", handlers.StopScene(wsHub, blobs))
 				scenes.GET("/active", handlers.GetActiveScene)
-				scenes.GET("/nearby", handlers.GetNearbyScenes)
+				scenes.POST("/persona", handlers.SwitchScenePersona(wsHub))
+				scenes.POST("/invite", handlers.CreateSceneInvite)
+				scenes.GET("/nearby", handlers.GetNearbyScenes(geoIndex))
+			}
+
+			// Notification preferences
+			preferences := protected.Group("/preferences")
+			{
+				preferences.GET("/notifications", handlers.GetNotificationPreferences)
+				preferences.PUT("/notifications", handlers.UpdateNotificationPreferences(wsHub))
+			}
+
+			// Venues
+			protected.GET("/venues", handlers.ListVenues)
+
+			// Admin
+			admin := protected.Group("/admin")
+			admin.Use(middleware.AdminMiddleware())
+			{
+				admin.POST("/venues", handlers.CreateVenue(geoIndex))
+				admin.DELETE("/venues/:id", handlers.DeleteVenue)
+				admin.POST("/venues/:id/broadcast", handlers.BroadcastToVenue(wsHub))
+			}
+
+			// Group rooms
+			groups := protected.Group("/groups")
+			{
+				groups.GET("", handlers.GetGroupRooms)
+				groups.POST("", handlers.CreateGroupRoom(wsHub))
+				groups.POST("/:id/invite", handlers.InviteToGroupRoom(wsHub))
+				groups.POST("/:id/accept", handlers.AcceptGroupInvite(wsHub))
+				groups.POST("/:id/decline", handlers.DeclineGroupInvite(wsHub))
+				groups.POST("/:id/leave", handlers.LeaveGroupRoom(wsHub))
+				groups.GET("/:id/messages", handlers.GetGroupMessages)
+				groups.POST("/:id/messages", handlers.SendGroupMessage(wsHub, mod))
 			}
 
 			// Yells
@@ -95,16 +163,36 @@ func SetupRoutes(router *gin.Engine, wsHub *websocket.Hub) {
 			// Chat
 			chat := protected.Group("/chat")
 			{
-				chat.POST("/requests", handlers.SendChatRequest(wsHub))
+				chat.POST("/requests", middleware.IdempotencyMiddleware("chat.requests"), handlers.SendChatRequest(wsHub, mod))
 				chat.GET("/requests/inbox", handlers.GetChatInbox)
 				chat.GET("/requests/sent", handlers.GetSentChatRequests)
 				chat.POST("/requests/:id/accept", handlers.AcceptChatRequest(wsHub))
 				chat.POST("/requests/:id/reject", handlers.RejectChatRequest(wsHub))
 				chat.POST("/requests/:id/cancel", handlers.CancelChatRequest(wsHub))
-				chat.POST("/messages", handlers.SendChatMessage(wsHub))
+				chat.POST("/requests/:id/end", handlers.EndChat(wsHub))
+				chat.POST("/requests/:id/extend", handlers.ProposeChatExtension(wsHub))
+				chat.POST("/requests/:id/extend/accept", handlers.AcceptChatExtension(wsHub))
+				chat.POST("/requests/:id/attachments", handlers.UploadChatAttachment(blobs))
+				chat.POST("/requests/:id/status", handlers.UpdateMessageStatus(wsHub))
+				chat.POST("/requests/:id/export-consent", handlers.SetChatExportConsent(wsHub))
+				chat.DELETE("/requests/:id/export-consent", handlers.SetChatExportConsent(wsHub))
+				chat.POST("/messages", handlers.SendChatMessage(wsHub, mod))
 				chat.GET("/messages/:request_id", handlers.GetChatMessages)
+				chat.GET("/messages/:request_id/export", handlers.ExportChatTranscript(wsHub))
+				chat.PATCH("/messages/:id", handlers.EditChatMessage(wsHub, mod))
+				chat.DELETE("/messages/:id", handlers.DeleteChatMessage(wsHub, blobs))
+				chat.POST("/messages/:id/reactions", handlers.AddReaction(wsHub))
+				chat.DELETE("/messages/:id/reactions/:emoji", handlers.RemoveReaction(wsHub))
 				chat.GET("/sessions", handlers.GetActiveChatSessions)
 			}
+
+			// Mutual-match mode
+			matches := protected.Group("/matches")
+			{
+				matches.GET("/likes", handlers.GetLikes)
+				matches.POST("/likes", handlers.LikeScene(wsHub))
+				matches.DELETE("/likes/:scene_id", handlers.UnlikeScene)
+			}
 		}
 	}
 }
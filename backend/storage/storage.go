@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+)
+
+// Blob store backends selectable with BLOB_STORE
+const (
+	BackendLocal = "local"
+)
+
+// ErrNotFound is returned when a blob does not exist
+var ErrNotFound = errors.New("blob not found")
+
+// BlobStore keeps uploaded files (chat attachments, ...) outside the database.
+// Keys are slash-separated paths chosen by the caller, e.g. "attachments/<chat>/<id>.jpg".
+type BlobStore interface {
+	Put(key string, data []byte) error
+	Open(key string) (io.ReadCloser, error)
+	Delete(key string) error
+}
+
+// New returns the BlobStore for the configured backend (BLOB_STORE, default local)
+func New(backend string) BlobStore {
+	switch backend {
+	case "", BackendLocal:
+		dir := os.Getenv("BLOB_STORE_DIR")
+		if dir == "" {
+			dir = "data/blobs"
+		}
+		log.Printf("🗄️  Using local blob store in %s", dir)
+		return NewLocalStore(dir)
+	default:
+		log.Printf("⚠️  Unknown BLOB_STORE=%q, falling back to local", backend)
+		return New(BackendLocal)
+	}
+}
@@ -0,0 +1,25 @@
+package websocket
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// Subprotocol is the protocol the server selects during the handshake. Browsers can't
+// set headers on a WebSocket, so the client offers it together with "auth.<token>" in
+// Sec-WebSocket-Protocol, which keeps the bearer token out of URLs and access logs.
+const Subprotocol = "scene-on.v1"
+
+const authProtocolPrefix = "auth."
+
+// TokenFromRequest returns the bearer token offered as an "auth.<token>" subprotocol, or ""
+func TokenFromRequest(r *http.Request) string {
+	for _, protocol := range websocket.Subprotocols(r) {
+		if token, ok := strings.CutPrefix(protocol, authProtocolPrefix); ok {
+			return token
+		}
+	}
+	return ""
+}
@@ -0,0 +1,56 @@
+package websocket
+
+import (
+	"log"
+	"scene-on/backend/config"
+
+	"github.com/google/uuid"
+)
+
+// LoadSceneBlocks returns the users the owner of a scene blocked or was blocked by.
+// Messages sent on behalf of those users are not delivered to the scene's clients.
+func LoadSceneBlocks(sceneID uuid.UUID) map[uuid.UUID]bool {
+	rows, err := config.DB.Query(
+		`SELECT CASE WHEN b.blocker_user_id = p.user_id THEN b.blocked_user_id ELSE b.blocker_user_id END
+		 FROM scenes s
+		 JOIN personas p ON s.persona_id = p.id
+		 JOIN user_blocks b ON b.blocker_user_id = p.user_id OR b.blocked_user_id = p.user_id
+		 WHERE s.id = $1`,
+		sceneID,
+	)
+	if err != nil {
+		log.Printf("Failed to load blocks for scene %s: %v", sceneID, err)
+		return nil
+	}
+	defer rows.Close()
+
+	var blocked map[uuid.UUID]bool
+	for rows.Next() {
+		var userID uuid.UUID
+		if err := rows.Scan(&userID); err != nil {
+			log.Printf("Failed to scan block: %v", err)
+			continue
+		}
+		if blocked == nil {
+			blocked = make(map[uuid.UUID]bool)
+		}
+		blocked[userID] = true
+	}
+	return blocked
+}
+
+// SetSceneBlocks replaces the blocked-user set of every client connected for a scene
+func (h *Hub) SetSceneBlocks(sceneID uuid.UUID, blocked map[uuid.UUID]bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for _, client := range h.sceneClients[sceneID] {
+		client.Blocked = blocked
+	}
+}
+
+// hidesSender reports whether a message sent on behalf of fromUserID must be kept
+// from this client
+func (c *Client) hidesSender(fromUserID uuid.UUID) bool {
+	return fromUserID != uuid.Nil && c.Blocked[fromUserID]
+}
@@ -3,9 +3,8 @@ package websocket
 import (
 	"encoding/json"
 	"log"
-	"math"
 	"net/http"
-	"scene-on/backend/config"
+	"scene-on/backend/geo"
 	"sync"
 	"time"
 
@@ -21,10 +20,13 @@ type Message struct {
 type Client struct {
 	ID        uuid.UUID
 	SceneID   uuid.UUID
+	UserID    uuid.UUID // Set only when the connection authenticated as the scene's owner
 	Conn      *websocket.Conn
 	Send      chan Message
 	Hub       *Hub
 	Location  Location
+	Prefs     *Preferences       // Notification preferences of the scene's user, nil = defaults
+	Blocked   map[uuid.UUID]bool // Users blocked by or blocking the scene's user
 	closeChan chan struct{}
 }
 
@@ -33,6 +35,9 @@ type Location struct {
 	Longitude float64 `json:"longitude"`
 }
 
+// CommandHandler handles a client-sent message of a registered type
+type CommandHandler func(client *Client, data map[string]interface{})
+
 type Hub struct {
 	clients      map[uuid.UUID]*Client
 	sceneClients map[uuid.UUID]map[uuid.UUID]*Client // SceneID -> ClientID -> Client
@@ -40,19 +45,24 @@ type Hub struct {
 	Targeted     chan TargetedMessage
 	Register     chan *Client
 	Unregister   chan *Client
+	geoIndex     geo.GeoIndex
+	commands     map[string]CommandHandler
+	delivered    map[string]CommandHandler
 	mutex        sync.RWMutex
 }
 
 type TargetedMessage struct {
 	Message       Message
 	TargetSceneID uuid.UUID
+	FromUserID    uuid.UUID // User the message is sent on behalf of (uuid.Nil = system)
 }
 
 type BroadcastMessage struct {
-	Message  Message
-	Location *Location // If set, only send to clients within range
-	Radius   float64   // Radius in meters
-	Exclude  uuid.UUID // Client ID to exclude
+	Message    Message
+	Location   *Location // If set, only send to clients within range
+	Radius     float64   // Radius in meters; recipients' own notification radius wins
+	Exclude    uuid.UUID // Client ID to exclude
+	FromUserID uuid.UUID // User the message is sent on behalf of (uuid.Nil = system)
 }
 
 var Upgrader = websocket.Upgrader{
@@ -61,10 +71,15 @@ var Upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
 		return true // TODO: Implement proper origin checking
 	},
+	// Never echo the auth.<token> protocol back; see TokenFromRequest
+	Subprotocols: []string{Subprotocol},
 }
 
-func NewHub() *Hub {
+func NewHub(geoIndex geo.GeoIndex) *Hub {
 	return &Hub{
+		geoIndex:     geoIndex,
+		commands:     make(map[string]CommandHandler),
+		delivered:    make(map[string]CommandHandler),
 		clients:      make(map[uuid.UUID]*Client),
 		sceneClients: make(map[uuid.UUID]map[uuid.UUID]*Client),
 		Broadcast:    make(chan BroadcastMessage, 512),  // Increased buffer
@@ -74,6 +89,19 @@ func NewHub() *Hub {
 	}
 }
 
+// HandleCommand registers a handler for client messages of the given type.
+// Handlers only run for authenticated clients and must be registered before Run.
+func (h *Hub) HandleCommand(msgType string, handler CommandHandler) {
+	h.commands[msgType] = handler
+}
+
+// HandleDelivered registers a handler that runs, with the message data, after a server
+// message of the given type was written to an authenticated client. It must be
+// registered before Run.
+func (h *Hub) HandleDelivered(msgType string, handler CommandHandler) {
+	h.delivered[msgType] = handler
+}
+
 func (h *Hub) Run() {
 	// Use a worker pool pattern for better CPU utilization
 	for {
@@ -126,14 +154,19 @@ func (h *Hub) unregisterClient(client *Client) {
 
 func (h *Hub) sendTargeted(targetedMsg TargetedMessage) {
 	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
 	clients := h.sceneClients[targetedMsg.TargetSceneID]
-	h.mutex.RUnlock()
-	
 	if len(clients) == 0 {
 		return
 	}
-	
+
+	now := time.Now()
 	for _, client := range clients {
+		if !client.Prefs.Allows(targetedMsg.Message.Type, now) || client.hidesSender(targetedMsg.FromUserID) {
+			continue
+		}
+
 		select {
 		case client.Send <- targetedMsg.Message:
 		default:
@@ -145,20 +178,25 @@ func (h *Hub) sendTargeted(targetedMsg TargetedMessage) {
 func (h *Hub) sendBroadcast(broadcastMsg BroadcastMessage) {
 	h.mutex.RLock()
 	defer h.mutex.RUnlock()
-	
+
+	now := time.Now()
 	for _, client := range h.clients {
 		if client.ID == broadcastMsg.Exclude {
 			continue
 		}
 
+		if !client.Prefs.Allows(broadcastMsg.Message.Type, now) || client.hidesSender(broadcastMsg.FromUserID) {
+			continue
+		}
+
 		if broadcastMsg.Location != nil {
-			distance := calculateDistance(
+			distance := geo.Distance(
 				client.Location.Latitude,
 				client.Location.Longitude,
 				broadcastMsg.Location.Latitude,
 				broadcastMsg.Location.Longitude,
 			)
-			if distance > broadcastMsg.Radius {
+			if distance > client.Prefs.Radius(broadcastMsg.Radius) {
 				continue
 			}
 		}
@@ -171,46 +209,49 @@ func (h *Hub) sendBroadcast(broadcastMsg BroadcastMessage) {
 	}
 }
 
-// BroadcastToNearby sends a message to all scenes within a geographic radius using PostGIS.
-// This is much more efficient than the in-memory distance calculations in sendBroadcast.
-func (h *Hub) BroadcastToNearby(msg Message, lat, lon, radiusMeters float64, excludeSceneID uuid.UUID) {
-	// Query PostGIS for nearby active scenes
-	rows, err := config.DB.Query(`
-		SELECT DISTINCT s.id 
-		FROM scenes s
-		WHERE s.is_active = true 
-		  AND s.expires_at > NOW()
-		  AND s.id != $1
-		  AND ST_DWithin(
-		      ST_SetSRID(ST_MakePoint(s.longitude, s.latitude), 4326)::geography,
-		      ST_SetSRID(ST_MakePoint($2, $3), 4326)::geography,
-		      $4
-		  )`,
-		excludeSceneID, lon, lat, radiusMeters,
-	)
+// BroadcastToNearby sends a message to all scenes within a geographic radius using the
+// configured GeoIndex. This is much more efficient than the in-memory distance calculations
+// in sendBroadcast. Recipients that set their own notification radius are matched against
+// it instead of radiusMeters; muted events, quiet hours and blocks of fromUserID are
+// applied by sendTargeted.
+func (h *Hub) BroadcastToNearby(msg Message, lat, lon, radiusMeters float64, excludeSceneID, fromUserID uuid.UUID) {
+	hits, err := h.geoIndex.NearbyScenes(geo.NearbyQuery{
+		Latitude:           lat,
+		Longitude:          lon,
+		RadiusMeters:       radiusMeters,
+		ExcludeSceneID:     excludeSceneID,
+		UseRecipientRadius: true,
+	})
 	if err != nil {
 		log.Printf("Failed to query nearby scenes: %v", err)
 		return
 	}
-	defer rows.Close()
-	
+
 	// Send targeted messages to each nearby scene
-	for rows.Next() {
-		var sceneID uuid.UUID
-		if err := rows.Scan(&sceneID); err == nil {
-			// Use the existing Targeted channel for delivery
-			h.Targeted <- TargetedMessage{
-				TargetSceneID: sceneID,
-				Message:       msg,
-			}
+	for _, hit := range hits {
+		h.Targeted <- TargetedMessage{
+			TargetSceneID: hit.SceneID,
+			Message:       msg,
+			FromUserID:    fromUserID,
 		}
 	}
-	
-	if err := rows.Err(); err != nil {
-		log.Printf("Error iterating nearby scenes: %v", err)
-	}
 }
 
+// BroadcastToVenue sends a message to every active scene located inside the venue polygon.
+func (h *Hub) BroadcastToVenue(msg Message, venueID uuid.UUID, excludeSceneID uuid.UUID) {
+	sceneIDs, err := h.geoIndex.VenueScenes(venueID, excludeSceneID)
+	if err != nil {
+		log.Printf("Failed to query venue scenes: %v", err)
+		return
+	}
+
+	for _, sceneID := range sceneIDs {
+		h.Targeted <- TargetedMessage{
+			TargetSceneID: sceneID,
+			Message:       msg,
+		}
+	}
+}
 
 func (c *Client) ReadPump() {
 	defer func() {
@@ -249,6 +290,19 @@ func (c *Client) ReadPump() {
 					c.Location = Location{Latitude: lat, Longitude: lon}
 				}
 			}
+		default:
+			handler, ok := c.Hub.commands[msg.Type]
+			if !ok {
+				continue
+			}
+			if c.UserID == uuid.Nil {
+				c.Send <- Message{Type: "error", Data: map[string]interface{}{
+					"type":  msg.Type,
+					"error": "Authentication required",
+				}}
+				continue
+			}
+			handler(c, msg.Data)
 		}
 	}
 }
@@ -279,6 +333,12 @@ func (c *Client) WritePump() {
 				return
 			}
 
+			// Off the write loop, so slow handlers don't hold up the connection. Like
+			// commands, they only run for authenticated clients.
+			if handler, ok := c.Hub.delivered[message.Type]; ok && c.UserID != uuid.Nil {
+				go handler(c, message.Data)
+			}
+
 		case <-ticker.C:
 			c.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
 			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
@@ -287,23 +347,3 @@ func (c *Client) WritePump() {
 		}
 	}
 }
-
-// Haversine formula to calculate distance between two coordinates
-func calculateDistance(lat1, lon1, lat2, lon2 float64) float64 {
-	const earthRadius = 6371000 // meters
-
-	rad := func(deg float64) float64 {
-		return deg * (3.14159265359 / 180)
-	}
-
-	dLat := rad(lat2 - lat1)
-	dLon := rad(lon2 - lon1)
-
-	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
-		math.Cos(rad(lat1))*math.Cos(rad(lat2))*
-			math.Sin(dLon/2)*math.Sin(dLon/2)
-
-	c := 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
-
-	return earthRadius * c
-}
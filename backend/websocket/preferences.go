@@ -0,0 +1,138 @@
+package websocket
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"scene-on/backend/config"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DefaultNotificationRadius is used for users who never set their own radius
+const DefaultNotificationRadius = 5000 // meters
+
+// MutableEvents lists the event types a user may mute or silence with quiet hours.
+// Chat traffic is never filtered, otherwise an open conversation would go dark, and
+// neither is scene.ended, which clients need to take ended scenes off the map.
+var MutableEvents = map[string]bool{
+	"scene.started":      true,
+	"venue.announcement": true,
+}
+
+// Preferences is the in-memory form of a user's notification preferences,
+// attached to every client of that user's scene.
+type Preferences struct {
+	RadiusMeters float64 // notification radius, 0 = the sender's radius
+	MutedEvents  map[string]bool
+	QuietStart   int // minutes after midnight, -1 if unset
+	QuietEnd     int // minutes after midnight, -1 if unset
+	Location     *time.Location
+}
+
+// Radius returns the radius a broadcast sent with radius reaches this user in
+func (p *Preferences) Radius(radius float64) float64 {
+	if p == nil || p.RadiusMeters <= 0 {
+		return radius
+	}
+	return p.RadiusMeters
+}
+
+// Allows reports whether an event of the given type should be delivered at time now
+func (p *Preferences) Allows(eventType string, now time.Time) bool {
+	if p == nil || !MutableEvents[eventType] {
+		return true
+	}
+	if p.MutedEvents[eventType] {
+		return false
+	}
+	if p.QuietStart < 0 || p.QuietEnd < 0 || p.QuietStart == p.QuietEnd {
+		return true
+	}
+
+	local := now.In(p.Location)
+	minute := local.Hour()*60 + local.Minute()
+
+	if p.QuietStart < p.QuietEnd {
+		return minute < p.QuietStart || minute >= p.QuietEnd
+	}
+	// Quiet hours wrap around midnight (e.g. 22:00 -> 07:00)
+	return minute < p.QuietStart && minute >= p.QuietEnd
+}
+
+// ParseClock parses an "HH:MM" string into minutes after midnight
+func ParseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return -1, fmt.Errorf("invalid time %q, expected HH:MM", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// LoadScenePreferences loads the notification preferences of the user owning a scene.
+// It returns nil when the user has no preferences stored.
+func LoadScenePreferences(sceneID uuid.UUID) *Preferences {
+	var radius int
+	var mutedRaw []byte
+	var quietStart, quietEnd sql.NullString
+	var timezone string
+
+	err := config.DB.QueryRow(
+		`SELECT np.radius_meters, np.muted_events, np.quiet_hours_start, np.quiet_hours_end, np.timezone
+		 FROM scenes s
+		 JOIN personas p ON s.persona_id = p.id
+		 JOIN notification_preferences np ON np.user_id = p.user_id
+		 WHERE s.id = $1`,
+		sceneID,
+	).Scan(&radius, &mutedRaw, &quietStart, &quietEnd, &timezone)
+
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		log.Printf("Failed to load notification preferences for scene %s: %v", sceneID, err)
+		return nil
+	}
+
+	prefs := &Preferences{
+		RadiusMeters: float64(radius),
+		MutedEvents:  make(map[string]bool),
+		QuietStart:   -1,
+		QuietEnd:     -1,
+		Location:     time.UTC,
+	}
+
+	var muted []string
+	if err := json.Unmarshal(mutedRaw, &muted); err == nil {
+		for _, event := range muted {
+			prefs.MutedEvents[event] = true
+		}
+	}
+
+	if quietStart.Valid && quietEnd.Valid {
+		start, errStart := ParseClock(quietStart.String)
+		end, errEnd := ParseClock(quietEnd.String)
+		if errStart == nil && errEnd == nil {
+			prefs.QuietStart = start
+			prefs.QuietEnd = end
+		}
+	}
+
+	if loc, err := time.LoadLocation(timezone); err == nil {
+		prefs.Location = loc
+	}
+
+	return prefs
+}
+
+// SetScenePreferences replaces the preferences of every client connected for a scene
+func (h *Hub) SetScenePreferences(sceneID uuid.UUID, prefs *Preferences) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for _, client := range h.sceneClients[sceneID] {
+		client.Prefs = prefs
+	}
+}
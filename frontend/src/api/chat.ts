@@ -6,7 +6,7 @@ export interface ChatRequest {
     from_scene_id: string;
     to_scene_id: string;
     message?: string;
-    status: 'pending' | 'accepted' | 'rejected' | 'expired';
+    status: 'pending' | 'accepted' | 'rejected' | 'canceled' | 'expired' | 'ended';
     accepted_at?: string;
     expires_at?: string;
     created_at: string;
@@ -23,6 +23,14 @@ export interface ChatMessage {
     created_at: string;
 }
 
+export interface ChatMessagesPage {
+    messages: ChatMessage[];
+    has_more_before: boolean;
+    has_more_after: boolean;
+    before_cursor?: string;
+    after_cursor?: string;
+}
+
 export interface ChatSession {
     request_id: string;
     from_scene_id: string;
@@ -93,10 +101,19 @@ export const chatApi = {
         return response.data;
     },
 
-    // Get all messages in a chat session
+    // Get the latest page of messages in a chat session
     getChatMessages: async (requestId: string): Promise<ChatMessage[]> => {
+        const page = await chatApi.getChatMessagesPage(requestId);
+        return page.messages;
+    },
+
+    // Get a page of messages; pass before/after cursors or a since timestamp to page or sync
+    getChatMessagesPage: async (
+        requestId: string,
+        params?: { limit?: number; before?: string; after?: string; since?: string },
+    ): Promise<ChatMessagesPage> => {
         const api = createAuthAxios();
-        const response = await api.get<ChatMessage[]>(`/chat/messages/${requestId}`);
+        const response = await api.get<ChatMessagesPage>(`/chat/messages/${requestId}`, { params });
         return response.data;
     },
 
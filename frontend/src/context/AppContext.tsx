@@ -21,7 +21,7 @@ export interface ChatRequest {
   fromPersona: Persona;
   message?: string;
   timestamp: Date;
-  status: 'pending' | 'accepted' | 'rejected' | 'expired';
+  status: 'pending' | 'accepted' | 'rejected' | 'canceled' | 'expired' | 'ended';
 }
 
 export interface ChatMessage {
@@ -1,5 +1,6 @@
 // WebSocket hook for real-time chat updates
 import { useEffect, useRef, useCallback, useState } from 'react';
+import { getAuthToken } from '@/api/axios-config';
 
 interface WSMessage {
     type: string;
@@ -18,6 +19,15 @@ const getWsUrl = () => {
 
 const WS_BASE_URL = getWsUrl();
 
+// The server selects WS_PROTOCOL; the token rides along as a second offered protocol
+// so it never appears in the URL (and so in access logs)
+const WS_PROTOCOL = 'scene-on.v1';
+
+const getProtocols = (): string[] => {
+    const token = getAuthToken();
+    return token ? [WS_PROTOCOL, `auth.${token}`] : [WS_PROTOCOL];
+};
+
 export const useWebSocket = (sceneId?: string | null) => {
     const ws = useRef<WebSocket | null>(null);
     const handlers = useRef<Map<string, MessageHandler[]>>(new Map());
@@ -35,7 +45,7 @@ export const useWebSocket = (sceneId?: string | null) => {
 
         console.log(`🔌 Connecting to WebSocket: ${url}`); // Added console.log
         try {
-            ws.current = new WebSocket(url);
+            ws.current = new WebSocket(url, getProtocols());
 
             ws.current.onopen = () => {
                 setIsConnected(true);
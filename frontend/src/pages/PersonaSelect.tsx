@@ -3,12 +3,12 @@ import { useNavigate } from 'react-router-dom';
 import { motion, AnimatePresence } from 'framer-motion';
 import { useApp, Persona } from '@/context/AppContext';
 import { Button } from '@/components/ui/button';
-import { ArrowRight, Sparkles, User, MessageSquare, Info, RefreshCw, ArrowLeft, X } from 'lucide-react';
-import axios from 'axios';
+import { ArrowRight, Sparkles, User, MessageSquare, Info, RefreshCw, ArrowLeft, X, Plus } from 'lucide-react';
+import { createAuthAxios } from '@/api/axios-config';
 import { scenesApi } from '@/api/scenes';
 import { useToast } from '@/hooks/use-toast';
 
-const API_BASE_URL = `${import.meta.env.VITE_API_URL || 'http://localhost:8080'}/api/v1`;
+const API_ORIGIN = import.meta.env.VITE_API_URL || 'http://localhost:8080';
 
 const AVATARS = ['🌟', '⚔️', '🔮', '🛡️', '🛰️', '🎭', '🦋', '🔥', '⚡', '🌌', '🐉', '🏔️'];
 
@@ -17,6 +17,10 @@ const PersonaSelect = () => {
   const navigate = useNavigate();
   const { selectedPersona, setSelectedPersona, authState, isSceneActive } = useApp();
   const [isCreating, setIsCreating] = useState(false);
+  const [personas, setPersonas] = useState<Persona[]>([]);
+  // The persona being edited, or null while creating a new one
+  const [editingId, setEditingId] = useState<string | null>(selectedPersona?.id || null);
+  const editing = personas.find((p) => p.id === editingId) || (editingId === selectedPersona?.id ? selectedPersona : null);
 
   // Pre-fill from existing persona if available
   const [name, setName] = useState(selectedPersona?.name || '');
@@ -24,6 +28,29 @@ const PersonaSelect = () => {
   const [selectedAvatar, setSelectedAvatar] = useState(selectedPersona?.avatar || AVATARS[0]);
   const [nameError, setNameError] = useState('');
 
+  useEffect(() => {
+    if (!authState) return;
+    createAuthAxios()
+      .get('/personas')
+      .then((response) => {
+        setPersonas(response.data.map((p: any) => ({
+          id: p.id,
+          name: p.name,
+          avatar: p.avatar_url,
+          description: p.description || '',
+        })));
+      })
+      .catch((error) => console.error('Failed to load personas:', error));
+  }, [authState]);
+
+  const editPersona = (persona: Persona | null) => {
+    setEditingId(persona?.id || null);
+    setName(persona?.name || '');
+    setDescription(persona?.description || '');
+    setSelectedAvatar(persona?.avatar || AVATARS[0]);
+    setNameError('');
+  };
+
   // ESC key handler to close/go back
   useEffect(() => {
     const handleKeyDown = (e: KeyboardEvent) => {
@@ -41,20 +68,21 @@ const PersonaSelect = () => {
 
     setIsCreating(true);
     try {
-      // Create or update persona in backend
-      const response = await axios.post(
-        `${API_BASE_URL}/personas`,
-        {
+      // Update the persona being edited with the fields that changed, or create a new one
+      let response;
+      if (editing) {
+        const changes: Record<string, string> = {};
+        if (name !== editing.name) changes.name = name;
+        if (selectedAvatar !== editing.avatar) changes.avatar_url = selectedAvatar;
+        if (description !== editing.description) changes.description = description;
+        response = await createAuthAxios().patch(`/personas/${editing.id}`, changes);
+      } else {
+        response = await createAuthAxios().post('/personas', {
           name,
           avatar_url: selectedAvatar,
           description: description,
-        },
-        {
-          headers: {
-            Authorization: `Bearer ${authState.accessToken}`,
-          },
-        }
-      );
+        });
+      }
 
       const personaId = response.data.id || response.data.ID;
       if (!personaId) throw new Error('Backend response missing ID');
@@ -147,11 +175,11 @@ const PersonaSelect = () => {
           <div className="inline-flex items-center gap-2 px-4 py-1.5 rounded-full bg-secondary/20 border border-secondary/30 mb-4">
             <Sparkles className="w-4 h-4 text-secondary" />
             <span className="text-sm font-medium text-secondary">
-              {selectedPersona ? 'Edit Identity' : 'Character Creation'}
+              {editing ? 'Edit Identity' : 'Character Creation'}
             </span>
           </div>
           <h1 className="text-4xl font-bold text-foreground mb-3">
-            {selectedPersona ? 'Update Your Identity' : 'Define Your Identity'}
+            {editing ? 'Update Your Identity' : 'Define Your Identity'}
           </h1>
           <p className="text-muted-foreground">
             {isSceneActive
@@ -162,6 +190,42 @@ const PersonaSelect = () => {
 
         <div className="bg-card/40 backdrop-blur-xl rounded-3xl border border-border/50 overflow-hidden shadow-2xl">
           <div className="p-8 space-y-8">
+            {/* Persona Selection */}
+            {personas.length > 0 && (
+              <div className="flex flex-wrap gap-2">
+                {personas.map((persona) => (
+                  <button
+                    key={persona.id}
+                    onClick={() => editPersona(persona)}
+                    className={`
+                      flex items-center gap-2 px-3 py-1.5 rounded-full border text-sm transition-all
+                      ${editingId === persona.id
+                        ? 'border-primary bg-primary/20 text-foreground'
+                        : 'border-border/50 bg-card/50 text-muted-foreground hover:text-foreground'}
+                    `}
+                  >
+                    {persona.avatar?.startsWith('/api/') ? (
+                      <img src={`${API_ORIGIN}${persona.avatar}`} alt="" className="w-5 h-5 rounded-full" />
+                    ) : (
+                      <span>{persona.avatar}</span>
+                    )}
+                    {persona.name}
+                  </button>
+                ))}
+                <button
+                  onClick={() => editPersona(null)}
+                  className={`
+                    flex items-center gap-1 px-3 py-1.5 rounded-full border text-sm transition-all
+                    ${editingId === null
+                      ? 'border-primary bg-primary/20 text-foreground'
+                      : 'border-border/50 bg-card/50 text-muted-foreground hover:text-foreground'}
+                  `}
+                >
+                  <Plus className="w-4 h-4" /> New
+                </button>
+              </div>
+            )}
+
             {/* Avatar Selection */}
             <div className="space-y-4">
               <label className="text-sm font-medium text-muted-foreground flex items-center gap-2">